@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gathertown/casper-3/internal/config"
+	"github.com/gathertown/casper-3/pkg/audit"
+	"github.com/gathertown/casper-3/pkg/kubernetes"
+	"github.com/gathertown/casper-3/pkg/log"
+)
+
+// auditFlushTimeout bounds storing the audit lines of a reconcile. The
+// reconcile context may be cancelled by then.
+const auditFlushTimeout = 10 * time.Second
+
+// setupAudit records the record mutations to AUDIT_SINK, if set, and returns
+// a function storing the remaining lines and closing the sink.
+func setupAudit(cfg *config.Config, logger *log.Logger) (func(), error) {
+	var sink audit.Sink
+	closeSink := func() error { return nil }
+
+	switch kind, target := splitSink(cfg.AuditSink); kind {
+	case "":
+		return func() {}, nil
+	case "stdout":
+		sink = audit.Writer{W: os.Stdout}
+	case "file":
+		if target == "" {
+			return nil, fmt.Errorf("invalid AUDIT_SINK %q: missing path", cfg.AuditSink)
+		}
+		file, err := audit.OpenFile(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUDIT_SINK %q: %w", cfg.AuditSink, err)
+		}
+		sink, closeSink = file, file.W.(*os.File).Close
+	case "configmap":
+		parts := strings.Split(target, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid AUDIT_SINK %q: want configmap:<namespace>/<name>", cfg.AuditSink)
+		}
+		size, err := strconv.Atoi(cfg.AuditConfigMapSize)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid AUDIT_CONFIGMAP_SIZE %q", cfg.AuditConfigMapSize)
+		}
+		c, err := kubernetes.New(cfg.Kubeconfig, cfg.KubeContext)
+		if err != nil {
+			return nil, fmt.Errorf("audit ConfigMap: %w", err)
+		}
+		sink = &kubernetes.AuditConfigMap{Client: c.Client, Namespace: parts[0], Name: parts[1], Size: size}
+	default:
+		return nil, fmt.Errorf("invalid AUDIT_SINK %q: want stdout, file:<path> or configmap:<namespace>/<name>", cfg.AuditSink)
+	}
+
+	audit.Setup(sink)
+	return func() {
+		flushAudit(logger)
+		audit.Setup(nil)
+		if err := closeSink(); err != nil {
+			logger.Error("Error occured while closing audit log", "error", err.Error())
+		}
+	}, nil
+}
+
+// flushAudit stores the audit lines recorded so far.
+func flushAudit(logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), auditFlushTimeout)
+	defer cancel()
+	if err := audit.Flush(ctx); err != nil {
+		logger.Error("Error occured while storing audit log", "error", err.Error())
+	}
+}
+
+// splitSink splits "file:/var/log/casper-3.jsonl" into its kind and target.
+func splitSink(sink string) (string, string) {
+	i := strings.Index(sink, ":")
+	if i < 0 {
+		return sink, ""
+	}
+	return sink[:i], sink[i+1:]
+}
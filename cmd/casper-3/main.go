@@ -1,81 +1,242 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"strconv"
-
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gathertown/casper-3/internal/config"
 	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
 	common "github.com/gathertown/casper-3/pkg"
-	"github.com/gathertown/casper-3/pkg/kubernetes"
 	"github.com/gathertown/casper-3/pkg/log"
 	cloudflare "github.com/gathertown/casper-3/pkg/providers/cloudflare"
 	digitalocean "github.com/gathertown/casper-3/pkg/providers/digitalocean"
+	"github.com/gathertown/casper-3/pkg/snapshot"
 )
 
 type Node = common.Node
 type Pod = common.Pod
+type RecordSet = common.RecordSet
 
 type provider interface {
-	Sync(nodes []Node)
-	SyncPods(pods []Pod)
+	Sync(ctx context.Context, nodes []Node) error
+	SyncPods(ctx context.Context, pods []Pod) error
+	Repair(ctx context.Context, nodes []Node, pods []Pod) error
+	SyncRecordSets(ctx context.Context, kind string, sets []RecordSet) error
+	DeletePod(ctx context.Context, pod Pod) error
+	Verify(ctx context.Context) error
+	Records(ctx context.Context) ([]snapshot.Record, error)
+	Restore(ctx context.Context, records []snapshot.Record, dryRun bool) error
 }
 
-// run labels nodes if label is missing
+const usage = `Usage: casper-3 [command] [flags]
+
+Commands:
+  run          reconcile DNS records in a loop (default)
+  sync --once  reconcile DNS records once and exit, non-zero on failure
+  export       write the records owned by casper-3 to a snapshot file
+  restore      re-create the records of a snapshot file that are missing
+
+Run 'casper-3 <command> -h' for the flags of a command.
+`
+
+// exit codes
+const (
+	exitOK = iota
+	exitFailure
+	exitUsage
+)
+
 func main() {
 	// Generic configuration setup
 	cfg := config.FromEnv()
-	logger := log.New(os.Stdout, cfg.LogLevel)
+	logger := log.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+
+	// Without a command, casper-3 keeps its historical behaviour of running the loop.
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	// The root context is cancelled on SIGTERM or SIGINT. In-flight record
+	// pairs are given SHUTDOWN_TIMEOUT to complete.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-sigs
+		logger.Info("Shutting down", "signal", sig.String(), "shutdownTimeout", cfg.ShutdownTimeoutSeconds)
+		stop()
+	}()
+
+	shutdownTracing, err := setupTracing(ctx, cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(exitUsage)
+	}
+
+	code := exitOK
+	switch command {
+	case "run":
+		code = runCmd(ctx, cfg, logger, args)
+	case "sync":
+		code = syncCmd(ctx, cfg, logger, args)
+	case "export":
+		code = exportCmd(ctx, cfg, logger, args)
+	case "restore":
+		code = restoreCmd(ctx, cfg, logger, args)
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		code = exitUsage
+	}
+
+	if err := shutdownTracing(); err != nil {
+		logger.Error("Error occured while exporting spans", "error", err.Error())
+	}
+	os.Exit(code)
+}
+
+// setupTracing exports the spans to TRACING_ENDPOINT, if set. The returned
+// function flushes the remaining spans within SHUTDOWN_TIMEOUT.
+func setupTracing(ctx context.Context, cfg *config.Config) (func() error, error) {
+	if cfg.TracingEndpoint == "" {
+		return func() error { return nil }, nil
+	}
+	insecure, err := strconv.ParseBool(cfg.TracingInsecure)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRACING_INSECURE %q: %w", cfg.TracingInsecure, err)
+	}
+	shutdownTimeout, err := strconv.ParseInt(cfg.ShutdownTimeoutSeconds, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q: %w", cfg.ShutdownTimeoutSeconds, err)
+	}
+	exporter, err := tracing.NewExporter(ctx, cfg.TracingEndpoint, insecure)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRACING_ENDPOINT %q: %w", cfg.TracingEndpoint, err)
+	}
+	shutdown := tracing.Setup(exporter, cfg.Env)
+	return func() error {
+		// ctx is cancelled by now, the flush gets its own deadline.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdownTimeout)*time.Second)
+		defer cancel()
+		return shutdown(ctx)
+	}, nil
+}
+
+// newFlagSet returns a flag set for a command with the flags shared by all
+// commands registered against cfg.
+func newFlagSet(name string, cfg *config.Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&cfg.Kubeconfig, "kubeconfig", cfg.Kubeconfig, "path to a kubeconfig file, in-cluster configuration is used when empty")
+	fs.StringVar(&cfg.KubeContext, "context", cfg.KubeContext, "kubeconfig context to use")
+	return fs
+}
+
+// runCmd reconciles DNS records every interval until the process is stopped.
+func runCmd(ctx context.Context, cfg *config.Config, logger *log.Logger, args []string) int {
+	fs := newFlagSet("run", cfg)
+	_ = fs.Parse(args)
+
+	return loop(ctx, cfg, logger)
+}
+
+// syncCmd reconciles DNS records once when --once is given. Otherwise it
+// behaves like the run command.
+func syncCmd(ctx context.Context, cfg *config.Config, logger *log.Logger, args []string) int {
+	fs := newFlagSet("sync", cfg)
+	once := fs.Bool("once", false, "run a single reconcile and exit")
+	_ = fs.Parse(args)
+
+	if !*once {
+		return loop(ctx, cfg, logger)
+	}
+
+	r, stopReconciler, err := newReconciler(cfg, logger, true)
+	if err != nil {
+		logger.Error(err.Error())
+		return exitUsage
+	}
+	defer stopReconciler()
+
+	logger.Info("Running casper-3 once", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "environment", cfg.Env, "kubeContext", cfg.KubeContext)
+	if err := r.reconcile(ctx); err != nil {
+		logger.Error("Reconcile failed", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+		return exitFailure
+	}
+	return exitOK
+}
+
+func loop(ctx context.Context, cfg *config.Config, logger *log.Logger) int {
 	interval, err := strconv.ParseInt(cfg.ScanIntervalSeconds, 10, 64)
 	if err != nil {
 		logger.Error(err.Error())
-		return
+		return exitUsage
 	}
 
-	// Run loop based on interval. Check if there are unlabelled instances.
-	// If there are unlabelled instances, add label. If not, skip.
-	var p provider
-	if cfg.Provider == "digitalocean" {
-		p = digitalocean.DigitalOceanDNS{}
+	r, stopReconciler, err := newReconciler(cfg, logger, false)
+	if err != nil {
+		logger.Error(err.Error())
+		return exitUsage
 	}
-	if cfg.Provider == "cloudflare" {
-		p = cloudflare.CloudFlareDNS{}
+	defer stopReconciler()
+
+	livenessIntervals, err := strconv.ParseInt(cfg.LivenessIntervals, 10, 64)
+	if err != nil {
+		logger.Error("Invalid LIVENESS_INTERVALS", "value", cfg.LivenessIntervals, "error", err.Error())
+		return exitUsage
+	}
+	health := &metrics.Health{
+		MaxReconcileAge: time.Duration(interval*livenessIntervals) * time.Second,
+		Checks:          r.checks(),
+		CheckInterval:   time.Duration(interval) * time.Second,
 	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- metrics.Serve(health, r.status) }()
 
-	go metrics.Serve()
+	logger.Info("Launching casper-3", "labelKey", cfg.LabelKey, "labelValues", cfg.LabelValues, "interval", cfg.ScanIntervalSeconds, "environment", cfg.Env, "TXT identifier", fmt.Sprintf("heritage=casper-3,environment=%s", cfg.Env), "logLevel", cfg.LogLevel, "logFormat", cfg.LogFormat, "tracingEndpoint", cfg.TracingEndpoint, "kubeContext", cfg.KubeContext)
 
-	logger.Info("Launching casper-3", "labelKey", cfg.LabelKey, "labelValues", cfg.LabelValues, "interval", cfg.ScanIntervalSeconds, "environment", cfg.Env, "TXT identifier", fmt.Sprintf("heritage=casper-3,environment=%s", cfg.Env), "logLevel", cfg.LogLevel)
+	// Run loop based on interval. Errors are logged by reconcile and the
+	// next iteration retries.
 	for {
-		c, err := kubernetes.New()
-		if err != nil {
-			logger.Error("Error occured while initializing pods", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
-			// Wait before we continue to next iteration
-			time.Sleep(time.Duration(interval) * time.Second)
-			continue
-		}
+		_ = r.reconcile(ctx)
 
-		n, err := c.Nodes()
-		if err != nil {
-			logger.Error("Error occured while fetching kubernetes nodes info", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
-			// Wait before we continue to next iteration
-			time.Sleep(time.Duration(interval) * time.Second)
-			continue
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopped casper-3")
+			return exitOK
+		case err := <-serveErr:
+			logger.Error("Error occured while serving metrics", "error", err.Error())
+			return exitFailure
+		case <-time.After(time.Duration(interval) * time.Second):
 		}
+	}
+}
 
-		p.Sync(n)
-
-		if syncPodsAllowed, _ := strconv.ParseBool(cfg.AllowSyncPods); syncPodsAllowed {
-			pods, err := c.Pods()
-			if err != nil {
-				logger.Error("Error occured while syncing pods", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
-			}
+// newProvider returns the configured provider. Its record operations are
+// collected in changes and the owned records it finds in observed.
+func newProvider(cfg *config.Config, changes *common.Changes, observed *common.Observed) (provider, error) {
+	shutdownTimeout, err := strconv.ParseInt(cfg.ShutdownTimeoutSeconds, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q: %w", cfg.ShutdownTimeoutSeconds, err)
+	}
+	grace := time.Duration(shutdownTimeout) * time.Second
 
-			p.SyncPods(pods)
-		}
-		time.Sleep(time.Duration(interval) * time.Second)
+	switch cfg.Provider {
+	case "digitalocean":
+		return digitalocean.DigitalOceanDNS{ShutdownTimeout: grace, Changes: changes, Observed: observed}, nil
+	case "cloudflare":
+		return cloudflare.CloudFlareDNS{ShutdownTimeout: grace, Changes: changes, Observed: observed}, nil
 	}
+	return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
 }
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gathertown/casper-3/internal/config"
+	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
+	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/audit"
+	"github.com/gathertown/casper-3/pkg/kubernetes"
+	"github.com/gathertown/casper-3/pkg/log"
+	"github.com/gathertown/casper-3/pkg/notify"
+	"github.com/gathertown/casper-3/pkg/status"
+	"go.opentelemetry.io/otel/attribute"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// reconciler reconciles the DNS records of a cluster with a provider and
+// reports the record operations of the provider.
+type reconciler struct {
+	cfg      *config.Config
+	logger   *log.Logger
+	provider provider
+	changes  *common.Changes    // record operations of provider
+	observed *common.Observed   // owned records found by provider
+	events   *kubernetes.Events // nil if RECORD_EVENTS is disabled
+	notifier *notify.Notifier   // nil if WEBHOOK_URLS is empty
+	status   *status.Status     // state of the last reconciles for the status API
+	released bool               // whether the finalizers of all namespaces were released once
+}
+
+// newReconciler returns a reconciler for the configured provider, and a
+// function releasing its resources once the last reconcile returned. With
+// once set, the reconciler runs a single reconcile before the process exits.
+func newReconciler(cfg *config.Config, logger *log.Logger, once bool) (*reconciler, func(), error) {
+	if err := kubernetes.ValidateAddressTypes(cfg.AddressTypes); err != nil {
+		return nil, nil, fmt.Errorf("invalid ADDRESS_TYPES: %w", err)
+	}
+	changes, observed := &common.Changes{}, &common.Observed{}
+	p, err := newProvider(cfg, changes, observed)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &reconciler{
+		cfg:      cfg,
+		logger:   logger,
+		provider: p,
+		changes:  changes,
+		observed: observed,
+		status:   &status.Status{Provider: cfg.Provider, Zone: cfg.Zone, Subdomain: cfg.Subdomain},
+	}
+
+	// The resources are released in the reverse order.
+	var stops []func()
+	stop := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+	if len(cfg.WebhookURLs) > 0 {
+		batch, err := strconv.ParseBool(cfg.WebhookBatch)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid WEBHOOK_BATCH %q: %w", cfg.WebhookBatch, err)
+		}
+		retries, err := strconv.Atoi(cfg.WebhookRetries)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid WEBHOOK_RETRIES %q: %w", cfg.WebhookRetries, err)
+		}
+		shutdownTimeout, err := strconv.ParseInt(cfg.ShutdownTimeoutSeconds, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q: %w", cfg.ShutdownTimeoutSeconds, err)
+		}
+		// The notifications are posted within the grace period of the shutdown
+		notifier, stopNotifier, err := notify.New(cfg.WebhookURLs, cfg.WebhookTemplate, batch, cfg.WebhookActions, retries, time.Duration(shutdownTimeout)*time.Second)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid webhook configuration: %w", err)
+		}
+		r.notifier = notifier
+		stops = append(stops, stopNotifier)
+	}
+	if recordEvents, _ := strconv.ParseBool(cfg.RecordEvents); recordEvents {
+		// Events are best effort, a cluster that can't be reached now is
+		// reported by reconcile.
+		c, err := kubernetes.New(cfg.Kubeconfig, cfg.KubeContext)
+		if err != nil {
+			logger.Error("Error occured while initializing kubernetes client, events disabled", "error", err.Error())
+		} else {
+			var stopEvents func()
+			r.events, stopEvents = kubernetes.NewEvents(c.Client, once)
+			stops = append(stops, stopEvents)
+		}
+	}
+	stopAudit, err := setupAudit(cfg, logger)
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	stops = append(stops, stopAudit)
+	return r, stop, nil
+}
+
+// checks returns the readiness checks of the reconciler: a working
+// Kubernetes client and provider credentials.
+func (r *reconciler) checks() map[string]metrics.Check {
+	return map[string]metrics.Check{
+		"kubernetes": func(ctx context.Context) error {
+			c, err := kubernetes.New(r.cfg.Kubeconfig, r.cfg.KubeContext)
+			if err != nil {
+				return err
+			}
+			// Listing a node checks the permissions as well as the connection.
+			_, err = c.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: 1})
+			return err
+		},
+		"provider": r.provider.Verify,
+	}
+}
+
+// reconcile runs sync once and records its duration. The log lines of the
+// reconcile carry a new reconcile ID.
+func (r *reconciler) reconcile(ctx context.Context) error {
+	id := newReconcileID()
+	log.SetReconcileID(id)
+	defer log.SetReconcileID("")
+
+	ctx, span := tracing.Start(ctx, "reconcile", attribute.String("reconcileID", id))
+	ctx = audit.WithReconcileID(ctx, id)
+	start := time.Now()
+	err := r.sync(ctx, id)
+	flushAudit(r.logger)
+	tracing.End(span, err)
+	metrics.ObserveReconcile(start, err)
+	r.status.Complete(id, start, err)
+	return err
+}
+
+// newReconcileID returns a random ID correlating the log lines, status and
+// records of a reconcile.
+func newReconcileID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// sync syncs the DNS records of the cluster nodes and, if allowed, the
+// node pools, the cluster pods and services and the CasperRecords once. It returns an error if
+// any step or record operation failed. The record operations of the provider
+// are reported on the nodes and pods they were made for, and posted to the
+// webhooks with the reconcile id.
+func (r *reconciler) sync(ctx context.Context, id string) error {
+	cfg, logger, p := r.cfg, r.logger, r.provider
+
+	c, err := kubernetes.New(cfg.Kubeconfig, cfg.KubeContext)
+	if err != nil {
+		logger.Error("Error occured while initializing kubernetes client", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+		return err
+	}
+
+	n, err := c.Nodes(ctx)
+	if err != nil {
+		logger.Error("Error occured while fetching kubernetes nodes info", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+		return err
+	}
+
+	var pods []Pod
+	var podsErr error
+	syncPodsAllowed, _ := strconv.ParseBool(cfg.AllowSyncPods)
+	if syncPodsAllowed {
+		pods, podsErr = c.Pods(ctx)
+		if podsErr != nil {
+			logger.Error("Error occured while fetching kubernetes pods info", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", podsErr.Error())
+		}
+	}
+
+	var failed bool
+
+	// Repairing against an incomplete list of pods would remove their records.
+	if repairAllowed, _ := strconv.ParseBool(cfg.RepairRecords); repairAllowed && podsErr == nil {
+		if err := p.Repair(ctx, n, pods); err != nil {
+			logger.Error("Error occured while repairing records", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+			failed = true
+		}
+	}
+
+	if err := p.Sync(ctx, n); err != nil {
+		logger.Error("Error occured while syncing nodes", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+		failed = true
+	}
+
+	if syncPodsAllowed {
+		// Syncing an incomplete list would delete the records of the missing pods.
+		if podsErr != nil {
+			failed = true
+		} else if err := p.SyncPods(ctx, pods); err != nil {
+			logger.Error("Error occured while syncing pods", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+			failed = true
+		}
+	}
+
+	// The records of terminating pods are withdrawn by SyncPods, unless it failed.
+	// Finalized pods are released even once the finalizer or the pod sync is
+	// disabled, so that they are not stuck in deletion. Finding them takes a
+	// list of all pods, which is only done until it succeeded once.
+	finalizerEnabled, _ := strconv.ParseBool(cfg.PodFinalizer)
+	if timeout, err := strconv.ParseInt(cfg.PodFinalizerTimeoutSeconds, 10, 64); err != nil {
+		logger.Error("Invalid POD_FINALIZER_TIMEOUT", "value", cfg.PodFinalizerTimeoutSeconds, "error", err.Error())
+		failed = true
+	} else {
+		var cleanup func(context.Context, Pod) error
+		if syncPodsAllowed {
+			cleanup = p.DeletePod
+		}
+		if syncPodsAllowed && finalizerEnabled {
+			if err := c.FinalizePods(ctx, time.Duration(timeout)*time.Second, cleanup); err != nil {
+				logger.Error("Error occured while finalizing pods", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+				failed = true
+			}
+		}
+		if !r.released {
+			if err := c.ReleasePods(ctx, time.Duration(timeout)*time.Second, cleanup); err != nil {
+				logger.Error("Error occured while releasing pod finalizers", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+				failed = true
+			} else {
+				r.released = true
+			}
+		}
+	}
+
+	// Record sets are not published under the names of the nodes and pods, nor
+	// under the name of an older record set.
+	claims := kubernetes.NewClaims(n, pods)
+	// The record sets to publish by kind, for the status API
+	sets := make(map[string][]kubernetes.RecordSet)
+
+	if syncPoolsAllowed, _ := strconv.ParseBool(cfg.AllowSyncPools); syncPoolsAllowed {
+		maxAddresses, err := strconv.Atoi(cfg.PoolMaxAddresses)
+		if err != nil {
+			logger.Error("Invalid POOL_MAX_ADDRESSES", "value", cfg.PoolMaxAddresses, "error", err.Error())
+			failed = true
+		} else {
+			sets["pool"] = claims.Filter(kubernetes.Pools(n, maxAddresses))
+			if err := p.SyncRecordSets(ctx, "pool", sets["pool"]); err != nil {
+				logger.Error("Error occured while syncing pools", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+				failed = true
+			}
+		}
+	}
+
+	if syncServicesAllowed, _ := strconv.ParseBool(cfg.AllowSyncServices); syncServicesAllowed {
+		services, err := c.Services(ctx)
+		if err != nil {
+			logger.Error("Error occured while fetching kubernetes services info", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+			failed = true
+		} else {
+			sets["service"] = claims.Filter(services)
+			if err := p.SyncRecordSets(ctx, "service", sets["service"]); err != nil {
+				logger.Error("Error occured while syncing services", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+				failed = true
+			}
+		}
+	}
+
+	if syncRecordsAllowed, _ := strconv.ParseBool(cfg.AllowSyncRecords); syncRecordsAllowed {
+		records, err := c.CasperRecords(ctx, claims)
+		if err != nil {
+			logger.Error("Error occured while fetching casper records", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+			failed = true
+		} else {
+			sets["record"] = kubernetes.RecordSets(records)
+			syncErr := p.SyncRecordSets(ctx, "record", sets["record"])
+			if syncErr != nil {
+				logger.Error("Error occured while syncing casper records", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", syncErr.Error())
+				failed = true
+			}
+			if err := c.UpdateCasperRecordStatus(ctx, records, syncErr); err != nil {
+				logger.Error("Error occured while updating casper record status", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+				failed = true
+			}
+		}
+	}
+
+	recorded := r.changes.Drain()
+	if podsErr != nil {
+		pods = nil
+	}
+	r.status.Update(n, pods, sets, r.observed.Drain(), recorded)
+	if r.events != nil {
+		r.events.Record(ctx, recorded)
+	}
+	if r.notifier != nil {
+		r.notifier.Notify(id, recorded)
+	}
+	if annotate, _ := strconv.ParseBool(cfg.AnnotateObjects); annotate {
+		if err := c.AnnotateChanges(ctx, recorded); err != nil {
+			logger.Error("Error occured while annotating objects", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", cfg.Subdomain, "error", err.Error())
+			failed = true
+		}
+	}
+
+	if failed {
+		return errors.New("one or more record operations failed")
+	}
+	return nil
+}
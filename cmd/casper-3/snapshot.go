@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gathertown/casper-3/internal/config"
+	"github.com/gathertown/casper-3/pkg/log"
+	"github.com/gathertown/casper-3/pkg/snapshot"
+)
+
+// exportCmd writes the records owned by casper-3 in the zone, or in a
+// subdomain of it, to a snapshot file.
+func exportCmd(ctx context.Context, cfg *config.Config, logger *log.Logger, args []string) int {
+	fs := newFlagSet("export", cfg)
+	output := fs.String("output", "-", "path of the snapshot file, stdout if -")
+	format := fs.String("format", "", "json or yaml, from the extension of --output by default, json for stdout")
+	subdomain := fs.String("subdomain", cfg.Subdomain, "subdomain of the records to export, the whole zone if empty")
+	_ = fs.Parse(args)
+
+	// Logging to stdout would corrupt the snapshot.
+	if *output == "-" {
+		log.SetOutput(os.Stderr)
+	}
+
+	if *format == "" {
+		*format = snapshot.FormatJSON
+		if ext := strings.ToLower(filepath.Ext(*output)); ext == ".yaml" || ext == ".yml" {
+			*format = snapshot.FormatYAML
+		}
+	}
+	if *format != snapshot.FormatJSON && *format != snapshot.FormatYAML {
+		logger.Error("Invalid --format, want json or yaml", "value", *format)
+		return exitUsage
+	}
+
+	p, err := newProvider(cfg, nil, nil)
+	if err != nil {
+		logger.Error(err.Error())
+		return exitUsage
+	}
+	records, err := p.Records(ctx)
+	if err != nil {
+		logger.Error("Error occured while fetching records", "provider", cfg.Provider, "zone", cfg.Zone, "error", err.Error())
+		return exitFailure
+	}
+	s := snapshot.Snapshot{
+		Version:     snapshot.Version,
+		Provider:    cfg.Provider,
+		Zone:        cfg.Zone,
+		Subdomain:   *subdomain,
+		Environment: cfg.Env,
+		ExportedAt:  time.Now().UTC(),
+		Records:     snapshot.Owned(records, cfg.Env, *subdomain, cfg.Zone),
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			logger.Error("Error occured while creating snapshot file", "path", *output, "error", err.Error())
+			return exitFailure
+		}
+	}
+	err = snapshot.Write(out, s, *format)
+	if out != os.Stdout {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		logger.Error("Error occured while writing snapshot", "path", *output, "error", err.Error())
+		return exitFailure
+	}
+	logger.Info("Exported records", "provider", cfg.Provider, "zone", cfg.Zone, "subdomain", *subdomain, "records", len(s.Records), "path", *output)
+	return exitOK
+}
+
+// restoreCmd re-creates the records of a snapshot file that are missing from
+// the zone through the provider.
+func restoreCmd(ctx context.Context, cfg *config.Config, logger *log.Logger, args []string) int {
+	fs := newFlagSet("restore", cfg)
+	input := fs.String("input", "", "path of the snapshot file, stdin if -")
+	dryRun := fs.Bool("dry-run", false, "log the records that would be created without creating them")
+	_ = fs.Parse(args)
+
+	if *input == "" {
+		logger.Error("Missing --input")
+		return exitUsage
+	}
+	in := os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			logger.Error("Error occured while opening snapshot file", "path", *input, "error", err.Error())
+			return exitFailure
+		}
+		defer f.Close()
+		in = f
+	}
+	s, err := snapshot.Read(in)
+	if err != nil {
+		logger.Error("Error occured while reading snapshot", "path", *input, "error", err.Error())
+		return exitFailure
+	}
+	// The names are FQDNs of the zone, and the TXT records mark the records
+	// as owned for the environment of the snapshot.
+	if s.Zone != cfg.Zone || s.Environment != cfg.Env {
+		logger.Error(fmt.Sprintf("Snapshot of zone %s and environment %s, want zone %s and environment %s", s.Zone, s.Environment, cfg.Zone, cfg.Env), "path", *input)
+		return exitUsage
+	}
+
+	p, err := newProvider(cfg, nil, nil)
+	if err != nil {
+		logger.Error(err.Error())
+		return exitUsage
+	}
+	if !*dryRun {
+		stopAudit, err := setupAudit(cfg, logger)
+		if err != nil {
+			logger.Error(err.Error())
+			return exitUsage
+		}
+		defer stopAudit()
+	}
+
+	logger.Info("Restoring records", "provider", cfg.Provider, "zone", cfg.Zone, "records", len(s.Records), "exportedAt", s.ExportedAt, "dryRun", *dryRun)
+	if err := p.Restore(ctx, s.Records, *dryRun); err != nil {
+		logger.Error("Error occured while restoring records", "provider", cfg.Provider, "zone", cfg.Zone, "error", err.Error())
+		return exitFailure
+	}
+	return exitOK
+}
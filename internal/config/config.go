@@ -16,11 +16,47 @@ const (
 	defaultToken                      = "abcd123"
 	defaultZone                       = "k8s.gather.town"
 	defaultSubdomain                  = ""     // effective only for DigitalOcean provider
-	defaultLogLevel                   = "info" // use to "debug" for debug level, everything else is INFO
+	defaultLogLevel                   = "info" // "debug", "info", "warn" or "error", unknown levels are INFO
 	defaultAllowSyncPods              = "false"
 	defaultSyncPodLabelKey            = "casper-3.gather.town/sync"
 	defaultSyncPodLabelValue          = "true"
 	defaultCloudFlareProxiedNodePools = ""
+	defaultKubeconfig                 = ""   // empty means in-cluster configuration
+	defaultKubeContext                = ""   // empty means the kubeconfig's current-context
+	defaultShutdownTimeoutSeconds     = "25" // keep below the pod's terminationGracePeriodSeconds
+	defaultRepairRecords              = "false"
+	defaultAllowSyncServices          = "false"
+	defaultAllowSyncPools             = "false"
+	defaultPoolMaxAddresses           = "10" // keeps the answer within a 512 byte UDP response
+	defaultNodeRequireReady           = "true"
+	defaultNodeExcludeUnschedulable   = "true"
+	defaultNodeExcludeTaints          = "ToBeDeletedByClusterAutoscaler" // key[=value][:effect], comma separated
+	defaultPodRequireReady            = "true"
+	defaultPodIgnoreReadinessGates    = "false" // "true" uses ContainersReady, e.g. for gates waiting on DNS
+	defaultPodFinalizer               = "false"
+	defaultPodFinalizerTimeoutSeconds = "300" // counted from the pod's deletion timestamp
+	defaultAnnotateObjects            = "false"
+	defaultRecordEvents               = "false"
+	defaultAllowSyncRecords           = "false"
+	defaultNodeSelector               = "" // empty means LABEL_KEY in (LABEL_VALUES)
+	defaultNodeFieldSelector          = ""
+	defaultSyncPodSelector            = "" // empty means SYNC_POD_LABEL_KEY=SYNC_POD_LABEL_VALUE
+	defaultSyncPodFieldSelector       = ""
+	defaultSyncNamespaces             = "" // empty means all namespaces
+	defaultSyncExcludeNamespaces      = ""
+	defaultAddressTypes               = "ExternalIP" // tried in order: ExternalIP, InternalIP, Annotation, HostIP, PodIP
+	defaultLivenessIntervals          = "5"          // intervals without a completed reconcile before /healthz fails
+	defaultLogFormat                  = "text"       // text (logfmt) or json
+	defaultTracingEndpoint            = ""           // OTLP/HTTP host:port of the spans, tracing is disabled when empty
+	defaultTracingInsecure            = "false"      // export spans without TLS
+	defaultWebhookURLs                = ""           // comma separated, notifications are disabled when empty
+	defaultWebhookTemplate            = ""           // Go template of the bodies, "slack", or the JSON payload when empty
+	defaultWebhookBatch               = "true"       // one post per reconcile rather than per change
+	defaultWebhookActions             = ""           // create, update, delete, blocked, all when empty
+	defaultWebhookRetries             = "3"          // attempts after the first one of a failed post
+	defaultAuditSink                  = ""           // stdout, file:<path> or configmap:<namespace>/<name>, disabled when empty
+	defaultAuditConfigMapSize         = "1000"       // lines kept by the configmap sink
+	defaultSyncRecordNamespaces       = ""           // empty means all namespaces
 )
 
 // Config contains service information that can be changed from the
@@ -39,6 +75,42 @@ type Config struct {
 	SyncPodLabelKey            string
 	SyncPodLabelValue          string
 	CloudflareProxiedNodePools []string
+	Kubeconfig                 string
+	KubeContext                string
+	ShutdownTimeoutSeconds     string
+	RepairRecords              string
+	AllowSyncServices          string
+	AllowSyncPools             string
+	PoolMaxAddresses           string
+	NodeRequireReady           string
+	NodeExcludeUnschedulable   string
+	NodeExcludeTaints          []string
+	PodRequireReady            string
+	PodIgnoreReadinessGates    string
+	PodFinalizer               string
+	PodFinalizerTimeoutSeconds string
+	AnnotateObjects            string
+	RecordEvents               string
+	AllowSyncRecords           string
+	NodeSelector               string
+	NodeFieldSelector          string
+	SyncPodSelector            string
+	SyncPodFieldSelector       string
+	SyncNamespaces             []string
+	SyncExcludeNamespaces      []string
+	AddressTypes               []string
+	LivenessIntervals          string
+	LogFormat                  string
+	TracingEndpoint            string
+	TracingInsecure            string
+	WebhookURLs                []string
+	WebhookTemplate            string
+	WebhookBatch               string
+	WebhookActions             []string
+	WebhookRetries             string
+	AuditSink                  string
+	AuditConfigMapSize         string
+	SyncRecordNamespaces       []string
 }
 
 // FromEnv returns the service configuration from the environment variables.
@@ -58,6 +130,42 @@ func FromEnv() *Config {
 		syncPodLabelKey            = getenv("SYNC_POD_LABEL_KEY", defaultSyncPodLabelKey)
 		syncPodLabelValue          = getenv("SYNC_POD_LABEL_VALUE", defaultSyncPodLabelValue)
 		cloudflareProxiedNodePools = getenv("CLOUDFLARE_PROXIED_NODE_POOLS", defaultCloudFlareProxiedNodePools)
+		kubeconfig                 = getenv("KUBECONFIG", defaultKubeconfig)
+		kubeContext                = getenv("KUBE_CONTEXT", defaultKubeContext)
+		shutdownTimeoutSeconds     = getenv("SHUTDOWN_TIMEOUT", defaultShutdownTimeoutSeconds)
+		repairRecords              = getenv("REPAIR_RECORDS", defaultRepairRecords)
+		allowSyncServices          = getenv("ALLOW_SYNC_SERVICES", defaultAllowSyncServices)
+		allowSyncPools             = getenv("ALLOW_SYNC_POOLS", defaultAllowSyncPools)
+		poolMaxAddresses           = getenv("POOL_MAX_ADDRESSES", defaultPoolMaxAddresses)
+		nodeRequireReady           = getenv("NODE_REQUIRE_READY", defaultNodeRequireReady)
+		nodeExcludeUnschedulable   = getenv("NODE_EXCLUDE_UNSCHEDULABLE", defaultNodeExcludeUnschedulable)
+		nodeExcludeTaints          = getenv("NODE_EXCLUDE_TAINTS", defaultNodeExcludeTaints)
+		podRequireReady            = getenv("POD_REQUIRE_READY", defaultPodRequireReady)
+		podIgnoreReadinessGates    = getenv("POD_IGNORE_READINESS_GATES", defaultPodIgnoreReadinessGates)
+		podFinalizer               = getenv("POD_FINALIZER", defaultPodFinalizer)
+		podFinalizerTimeoutSeconds = getenv("POD_FINALIZER_TIMEOUT", defaultPodFinalizerTimeoutSeconds)
+		annotateObjects            = getenv("ANNOTATE_OBJECTS", defaultAnnotateObjects)
+		recordEvents               = getenv("RECORD_EVENTS", defaultRecordEvents)
+		allowSyncRecords           = getenv("ALLOW_SYNC_RECORDS", defaultAllowSyncRecords)
+		nodeSelector               = getenv("NODE_SELECTOR", defaultNodeSelector)
+		nodeFieldSelector          = getenv("NODE_FIELD_SELECTOR", defaultNodeFieldSelector)
+		syncPodSelector            = getenv("SYNC_POD_SELECTOR", defaultSyncPodSelector)
+		syncPodFieldSelector       = getenv("SYNC_POD_FIELD_SELECTOR", defaultSyncPodFieldSelector)
+		syncNamespaces             = getenv("SYNC_NAMESPACES", defaultSyncNamespaces)
+		syncExcludeNamespaces      = getenv("SYNC_EXCLUDE_NAMESPACES", defaultSyncExcludeNamespaces)
+		addressTypes               = getenv("ADDRESS_TYPES", defaultAddressTypes)
+		livenessIntervals          = getenv("LIVENESS_INTERVALS", defaultLivenessIntervals)
+		logFormat                  = getenv("LOG_FORMAT", defaultLogFormat)
+		tracingEndpoint            = getenv("TRACING_ENDPOINT", defaultTracingEndpoint)
+		tracingInsecure            = getenv("TRACING_INSECURE", defaultTracingInsecure)
+		webhookURLs                = getenv("WEBHOOK_URLS", defaultWebhookURLs)
+		webhookTemplate            = getenv("WEBHOOK_TEMPLATE", defaultWebhookTemplate)
+		webhookBatch               = getenv("WEBHOOK_BATCH", defaultWebhookBatch)
+		webhookActions             = getenv("WEBHOOK_ACTIONS", defaultWebhookActions)
+		webhookRetries             = getenv("WEBHOOK_RETRIES", defaultWebhookRetries)
+		auditSink                  = getenv("AUDIT_SINK", defaultAuditSink)
+		auditConfigMapSize         = getenv("AUDIT_CONFIGMAP_SIZE", defaultAuditConfigMapSize)
+		syncRecordNamespaces       = getenv("SYNC_RECORD_NAMESPACES", defaultSyncRecordNamespaces)
 	)
 
 	c := &Config{
@@ -74,6 +182,42 @@ func FromEnv() *Config {
 		SyncPodLabelKey:            syncPodLabelKey,
 		SyncPodLabelValue:          syncPodLabelValue,
 		CloudflareProxiedNodePools: stringToList(cloudflareProxiedNodePools),
+		Kubeconfig:                 kubeconfig,
+		KubeContext:                kubeContext,
+		ShutdownTimeoutSeconds:     shutdownTimeoutSeconds,
+		RepairRecords:              repairRecords,
+		AllowSyncServices:          allowSyncServices,
+		AllowSyncPools:             allowSyncPools,
+		PoolMaxAddresses:           poolMaxAddresses,
+		NodeRequireReady:           nodeRequireReady,
+		NodeExcludeUnschedulable:   nodeExcludeUnschedulable,
+		NodeExcludeTaints:          stringToList(nodeExcludeTaints),
+		PodRequireReady:            podRequireReady,
+		PodIgnoreReadinessGates:    podIgnoreReadinessGates,
+		PodFinalizer:               podFinalizer,
+		PodFinalizerTimeoutSeconds: podFinalizerTimeoutSeconds,
+		AnnotateObjects:            annotateObjects,
+		RecordEvents:               recordEvents,
+		AllowSyncRecords:           allowSyncRecords,
+		NodeSelector:               nodeSelector,
+		NodeFieldSelector:          nodeFieldSelector,
+		SyncPodSelector:            syncPodSelector,
+		SyncPodFieldSelector:       syncPodFieldSelector,
+		SyncNamespaces:             stringToList(syncNamespaces),
+		SyncExcludeNamespaces:      stringToList(syncExcludeNamespaces),
+		AddressTypes:               stringToList(addressTypes),
+		LivenessIntervals:          livenessIntervals,
+		LogFormat:                  logFormat,
+		TracingEndpoint:            tracingEndpoint,
+		TracingInsecure:            tracingInsecure,
+		WebhookURLs:                stringToList(webhookURLs),
+		WebhookTemplate:            webhookTemplate,
+		WebhookBatch:               webhookBatch,
+		WebhookActions:             stringToList(webhookActions),
+		WebhookRetries:             webhookRetries,
+		AuditSink:                  auditSink,
+		AuditConfigMapSize:         auditConfigMapSize,
+		SyncRecordNamespaces:       stringToList(syncRecordNamespaces),
 	}
 	return c
 }
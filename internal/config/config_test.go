@@ -31,6 +31,8 @@ func TestFromEnv(t *testing.T) {
 	setenv(t, "SUBDOMAIN", "dev")
 	setenv(t, "ZONE", "k8s.gather.town")
 	setenv(t, "CLOUDFLARE_PROXIED_NODE_POOLS", "sfu, engine")
+	setenv(t, "KUBECONFIG", "/tmp/kubeconfig")
+	setenv(t, "KUBE_CONTEXT", "dev")
 
 	cfg := FromEnv()
 
@@ -69,6 +71,14 @@ func TestFromEnv(t *testing.T) {
 		t.Errorf("FromEnv() 'CLOUDFLARE_PROXIED_NODE_POOLS' = %q; want %q", got, want)
 	}
 
+	if got, want := cfg.Kubeconfig, "/tmp/kubeconfig"; got != want {
+		t.Errorf("FromEnv() 'KUBECONFIG' = %q; want %q", got, want)
+	}
+
+	if got, want := cfg.KubeContext, "dev"; got != want {
+		t.Errorf("FromEnv() 'KUBE_CONTEXT' = %q; want %q", got, want)
+	}
+
 	unsetenv(t, "ENV")
 	unsetenv(t, "INTERVAL")
 	unsetenv(t, "PROVIDER")
@@ -77,6 +87,8 @@ func TestFromEnv(t *testing.T) {
 	unsetenv(t, "TOKEN")
 	unsetenv(t, "SUBDOMAIN")
 	unsetenv(t, "ZONE")
+	unsetenv(t, "KUBECONFIG")
+	unsetenv(t, "KUBE_CONTEXT")
 }
 
 func TestSplitAndRejoin(t *testing.T) {
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Error categories, the values of the category label of execution_error.
+const (
+	CategoryCanceled     = "canceled"
+	CategoryTimeout      = "timeout"
+	CategoryNetwork      = "network"
+	CategoryUnauthorized = "unauthorized"
+	CategoryNotFound     = "not_found"
+	CategoryConflict     = "conflict"
+	CategoryRateLimited  = "rate_limited"
+	CategoryInvalid      = "invalid_request"
+	CategoryUnavailable  = "unavailable"
+	CategoryOther        = "other"
+)
+
+// ErrorCategory returns the category of an error of the Kubernetes or
+// provider APIs, from its status code where there is one.
+func ErrorCategory(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return CategoryCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return CategoryTimeout
+	}
+
+	if code := StatusCode(err); code != 0 {
+		switch {
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			return CategoryUnauthorized
+		case code == http.StatusNotFound:
+			return CategoryNotFound
+		case code == http.StatusConflict:
+			return CategoryConflict
+		case code == http.StatusTooManyRequests:
+			return CategoryRateLimited
+		case code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout:
+			return CategoryTimeout
+		case code >= http.StatusInternalServerError:
+			return CategoryUnavailable
+		case code >= http.StatusBadRequest:
+			return CategoryInvalid
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return CategoryTimeout
+		}
+		return CategoryNetwork
+	}
+	return CategoryOther
+}
+
+// statusCodes are the functions returning the status code of the errors of
+// the provider APIs, see RegisterStatusCode.
+var statusCodes struct {
+	sync.RWMutex
+	all []func(error) int
+}
+
+// RegisterStatusCode adds fn returning the HTTP status code of the errors of a
+// provider API, or 0 for other errors. The providers register theirs when
+// their package is initialized.
+func RegisterStatusCode(fn func(error) int) {
+	statusCodes.Lock()
+	defer statusCodes.Unlock()
+	statusCodes.all = append(statusCodes.all, fn)
+}
+
+// StatusCode returns the HTTP status code of an error of the Kubernetes or a
+// registered provider API, or 0 if unknown.
+func StatusCode(err error) int {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return int(status.Status().Code)
+	}
+
+	statusCodes.RLock()
+	defer statusCodes.RUnlock()
+	for _, fn := range statusCodes.all {
+		if code := fn(err); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// providerErr is an error of a provider API with a status code.
+type providerErr int
+
+func (e providerErr) Error() string {
+	return http.StatusText(int(e))
+}
+
+func TestErrorCategory(t *testing.T) {
+	RegisterStatusCode(func(err error) int {
+		var e providerErr
+		if errors.As(err, &e) {
+			return int(e)
+		}
+		return 0
+	})
+	nodes := schema.GroupResource{Resource: "nodes"}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"canceled", fmt.Errorf("listing nodes: %w", context.Canceled), CategoryCanceled},
+		{"deadline", context.DeadlineExceeded, CategoryTimeout},
+		{"kubernetes not found", apierrors.NewNotFound(nodes, "sfu-8mh0d"), CategoryNotFound},
+		{"kubernetes forbidden", apierrors.NewForbidden(nodes, "", errors.New("denied")), CategoryUnauthorized},
+		{"kubernetes conflict", apierrors.NewConflict(nodes, "sfu-8mh0d", errors.New("modified")), CategoryConflict},
+		{"kubernetes too many requests", apierrors.NewTooManyRequests("slow down", 1), CategoryRateLimited},
+		{"provider rate limited", providerErr(http.StatusTooManyRequests), CategoryRateLimited},
+		{"provider unavailable", providerErr(http.StatusBadGateway), CategoryUnavailable},
+		{"provider invalid", fmt.Errorf("adding record: %w", providerErr(http.StatusUnprocessableEntity)), CategoryInvalid},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, CategoryNetwork},
+		{"other", errors.New("deleteRecord() wants to delete wrong record"), CategoryOther},
+	}
+	for _, tt := range tests {
+		if got := ErrorCategory(tt.err); got != tt.want {
+			t.Errorf("Expecting category %q for %s, got %q", tt.want, tt.name, got)
+		}
+	}
+}
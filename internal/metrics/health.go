@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check is a readiness check, e.g. that the provider credentials work.
+type Check func(ctx context.Context) error
+
+// Health serves /healthz and /readyz.
+type Health struct {
+	// MaxReconcileAge is how long /healthz tolerates no reconcile completing,
+	// counted from the start of the process until the first one.
+	MaxReconcileAge time.Duration
+	// Checks are the readiness checks of /readyz by name.
+	Checks map[string]Check
+	// CheckInterval is how long the results of Checks are reused, as probes
+	// would otherwise call the provider API every probe period.
+	CheckInterval time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	results map[string]error
+	running chan struct{} // closed once the running checks completed, nil if none
+}
+
+// checkTimeout bounds a single run of the readiness checks.
+const checkTimeout = 10 * time.Second
+
+// lastReconcile is the time the last reconcile completed, successful or not.
+var lastReconcile = struct {
+	sync.Mutex
+	t time.Time
+}{t: time.Now()}
+
+func reconciled() {
+	lastReconcile.Lock()
+	lastReconcile.t = time.Now()
+	lastReconcile.Unlock()
+}
+
+// healthz fails once no reconcile completed within MaxReconcileAge, so that
+// a wedged loop gets restarted.
+func (h *Health) healthz(w http.ResponseWriter, r *http.Request) {
+	lastReconcile.Lock()
+	age := time.Since(lastReconcile.t)
+	lastReconcile.Unlock()
+
+	if h.MaxReconcileAge > 0 && age > h.MaxReconcileAge {
+		http.Error(w, fmt.Sprintf("no reconcile completed for %s", age.Round(time.Second)), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports the last results of the readiness checks, and runs them in
+// the background once they are older than CheckInterval. Only the probes
+// before the first results wait for the checks, so that a slow check doesn't
+// hold up the probes.
+func (h *Health) readyz(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	results := h.results
+	var done <-chan struct{}
+	if results == nil || time.Since(h.checked) > h.CheckInterval {
+		done = h.check()
+	}
+	h.mu.Unlock()
+
+	if results == nil {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			http.Error(w, "readiness checks did not complete", http.StatusServiceUnavailable)
+			return
+		}
+		h.mu.Lock()
+		results = h.results
+		h.mu.Unlock()
+	}
+
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	status := http.StatusOK
+	for _, name := range names {
+		if results[name] != nil {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	for _, name := range names {
+		if err := results[name]; err != nil {
+			fmt.Fprintf(w, "%s: %s\n", name, err)
+		} else {
+			fmt.Fprintf(w, "%s: ok\n", name)
+		}
+	}
+}
+
+// check runs the checks in the background, unless they are running already,
+// and returns a channel closed once they completed. h.mu must be held.
+func (h *Health) check() <-chan struct{} {
+	if h.running != nil {
+		return h.running
+	}
+	done := make(chan struct{})
+	h.running = done
+	go func() {
+		// The checks outlive the probe that started them
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		defer cancel()
+		results := make(map[string]error, len(h.Checks))
+		for name, check := range h.Checks {
+			results[name] = check(ctx)
+		}
+
+		h.mu.Lock()
+		h.results, h.checked, h.running = results, time.Now(), nil
+		h.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	h := &Health{MaxReconcileAge: time.Minute}
+
+	lastReconcile.Lock()
+	lastReconcile.t = time.Now().Add(-2 * time.Minute)
+	lastReconcile.Unlock()
+
+	w := httptest.NewRecorder()
+	h.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expecting status %d without a recent reconcile, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	ObserveReconcile(time.Now(), errors.New("failed"))
+
+	w = httptest.NewRecorder()
+	h.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expecting status %d after a failed reconcile, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	var calls int32
+	providerErr := errors.New("invalid token")
+	release := make(chan struct{}, 1)
+	release <- struct{}{}
+	h := &Health{
+		Checks: map[string]Check{
+			"kubernetes": func(ctx context.Context) error { return nil },
+			"provider": func(ctx context.Context) error {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return providerErr
+			},
+		},
+		CheckInterval: time.Minute,
+	}
+
+	// The first probe waits for the checks.
+	w := httptest.NewRecorder()
+	h.readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expecting status %d with a failing check, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if want := "kubernetes: ok\nprovider: invalid token\n"; w.Body.String() != want {
+		t.Errorf("Expecting body %q, got %q", want, w.Body.String())
+	}
+
+	// The results are reused within CheckInterval.
+	providerErr = nil
+	w = httptest.NewRecorder()
+	h.readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if calls := atomic.LoadInt32(&calls); calls != 1 || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expecting status %d after 1 check within CheckInterval, got %d after %d", http.StatusServiceUnavailable, w.Code, calls)
+	}
+
+	// Once they expired, the last results are reported while the checks run.
+	h.mu.Lock()
+	h.checked = time.Now().Add(-2 * time.Minute)
+	h.mu.Unlock()
+	w = httptest.NewRecorder()
+	h.readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expecting the last status %d while the checks run, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	h.mu.Lock()
+	done := h.running
+	h.mu.Unlock()
+	release <- struct{}{}
+	<-done
+
+	w = httptest.NewRecorder()
+	h.readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "provider: ok") {
+		t.Errorf("Expecting status %d after the checks ran again, got %d %q", http.StatusOK, w.Code, w.Body.String())
+	}
+}
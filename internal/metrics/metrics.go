@@ -3,6 +3,7 @@ package metrics
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -18,9 +19,54 @@ var (
 		Name:      "execution_error",
 		Namespace: namespace,
 		Subsystem: "app",
-		Help:      "Execution errors encountered by description",
+		Help:      "Execution errors encountered by category, see ErrorCategory",
 	},
-		[]string{"errorMessage"},
+		[]string{"category"},
+	)
+
+	lastSuccessfulSync = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:      "last_successful_sync_timestamp_seconds",
+		Namespace: namespace,
+		Subsystem: "app",
+		Help:      "Unix time of the last reconcile without errors",
+	})
+
+	reconcileDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "duration_seconds",
+		Namespace: namespace,
+		Subsystem: "reconcile",
+		Help:      "Duration of a reconcile by result",
+		Buckets:   []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	},
+		[]string{"result"},
+	)
+
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "request_duration_seconds",
+		Namespace: namespace,
+		Subsystem: "api",
+		Help:      "Duration of the requests to the Kubernetes and provider APIs by HTTP method and status code",
+		Buckets:   prometheus.DefBuckets,
+	},
+		[]string{"api", "method", "code"},
+	)
+
+	dnsRecordOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:      "record_operations_total",
+		Namespace: namespace,
+		Subsystem: "dns",
+		Help:      "Records created, updated and deleted by provider, record type and result",
+	},
+		[]string{"provider", "type", "operation", "result"},
+	)
+
+	dnsOwnedRecords = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "owned_records",
+		Namespace: namespace,
+		Subsystem: "dns",
+		Help:      "Names owned by casper-3 in the zone by kind, e.g. node, pod or service",
+	},
+		[]string{"provider", "kind"},
 	)
 
 	dnsRecordTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
@@ -31,17 +77,77 @@ var (
 	},
 		[]string{"provider"},
 	)
+
+	dnsInconsistentRecords = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "inconsistent_records",
+		Namespace: namespace,
+		Subsystem: "dns",
+		Help:      "Owned names where only the A or the TXT record of the pair exists, by kind",
+	},
+		[]string{"provider", "kind"},
+	)
 )
 
-func ExecErrInc(msg string) {
-	executionError.WithLabelValues(msg).Inc()
+// ExecErrInc counts an error by its category, the error message would make
+// for an unbounded number of series.
+func ExecErrInc(err error) {
+	executionError.WithLabelValues(ErrorCategory(err)).Inc()
+}
+
+// ObserveReconcile records the duration of a reconcile started at start, and
+// the time of the last completed and last successful one.
+func ObserveReconcile(start time.Time, err error) {
+	reconcileDuration.WithLabelValues(result(err)).Observe(time.Since(start).Seconds())
+	reconciled()
+	if err == nil {
+		lastSuccessfulSync.SetToCurrentTime()
+	}
+}
+
+// RecordOperation counts a record operation, "create", "update" or "delete".
+func RecordOperation(provider string, recordType string, operation string, err error) {
+	dnsRecordOperations.WithLabelValues(provider, recordType, operation, result(err)).Inc()
+}
+
+// InstrumentRoundTripper returns next observing the duration of the requests
+// to api, e.g. "kubernetes" or the provider.
+func InstrumentRoundTripper(api string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return promhttp.InstrumentRoundTripperDuration(apiRequestDuration.MustCurryWith(prometheus.Labels{"api": api}), next)
+}
+
+func DNSOwnedRecords(provider string, kind string, n float64) {
+	dnsOwnedRecords.WithLabelValues(provider, kind).Set(n)
 }
 
 func DNSRecordsTotal(provider string, n float64) {
 	dnsRecordTotal.WithLabelValues(provider).Set(n)
 }
 
-func Serve() {
+func DNSInconsistentRecords(provider string, kind string, n float64) {
+	dnsInconsistentRecords.WithLabelValues(provider, kind).Set(n)
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// Serve serves the metrics, /healthz and /readyz of h and the status API
+// under /status, unless nil, on :8080 until the server fails.
+func Serve(h *Health, status http.Handler) error {
 	http.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(":8080", nil)
+	if h != nil {
+		http.HandleFunc("/healthz", h.healthz)
+		http.HandleFunc("/readyz", h.readyz)
+	}
+	if status != nil {
+		http.Handle("/status", status)
+		http.Handle("/status/", status)
+	}
+	return http.ListenAndServe(":8080", nil)
 }
@@ -0,0 +1,94 @@
+// Package tracing records OpenTelemetry spans of the reconciles, the
+// Kubernetes and provider API calls. Spans are only exported once Setup
+// installed an exporter, tracing is a no-op otherwise.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation is the name of the tracer of the spans.
+const instrumentation = "github.com/gathertown/casper-3"
+
+// NewExporter returns an exporter of spans over OTLP/HTTP to endpoint, e.g.
+// "otel-collector.monitoring:4318". TLS is used unless insecure.
+func NewExporter(ctx context.Context, endpoint string, insecure bool) (sdktrace.SpanExporter, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// Setup exports the spans of the service with exporter from then on. The
+// returned function flushes the remaining spans and stops the export.
+func Setup(exporter sdktrace.SpanExporter, environment string) func(context.Context) error {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("casper-3"),
+			semconv.DeploymentEnvironmentKey.String(environment),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown
+}
+
+// Start starts a span as a child of the span of ctx, if any.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, with an error status if err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Transport returns next recording a client span per request to api, e.g.
+// "kubernetes" or the provider, as a child of the span of the request context.
+func Transport(api string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{api: api, next: next}
+}
+
+type transport struct {
+	api  string
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := otel.Tracer(instrumentation).Start(req.Context(), t.api+" "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(req.Method),
+			semconv.HTTPHostKey.String(req.URL.Host),
+			semconv.HTTPTargetKey.String(req.URL.Path),
+		),
+	)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		End(span, err)
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(resp.StatusCode))
+	span.End()
+	return resp, nil
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	// Setup batches the spans, the test exports them as they end.
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: Transport("cloudflare", nil)}
+
+	ctx, span := Start(context.Background(), "reconcile")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/zones", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET /zones returned error: %v", err)
+	}
+	resp.Body.Close()
+	End(span, errors.New("not found"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expecting 2 spans, got %d", len(spans))
+	}
+	request, reconcile := spans[0], spans[1]
+	if request.Name != "cloudflare GET" {
+		t.Errorf("Expecting request span %q, got %q", "cloudflare GET", request.Name)
+	}
+	if request.Parent.SpanID() != reconcile.SpanContext.SpanID() {
+		t.Errorf("Expecting the request span to be a child of the reconcile span")
+	}
+	if request.Status.Code != codes.Error {
+		t.Errorf("Expecting request span status %v, got %v", codes.Error, request.Status.Code)
+	}
+	if reconcile.Status.Code != codes.Error || reconcile.Status.Description != "not found" {
+		t.Errorf("Expecting reconcile span error %q, got %+v", "not found", reconcile.Status)
+	}
+}
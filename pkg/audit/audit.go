@@ -0,0 +1,166 @@
+// Package audit keeps an append-only log of the DNS records casper-3
+// creates, updates and deletes, one JSON line per API call, so that
+// incidents like mass deletions can be reconstructed.
+//
+// Nothing is recorded until Setup installed a sink.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gathertown/casper-3/internal/config"
+	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/pkg/log"
+)
+
+var cfg = config.FromEnv()
+var logger = log.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+
+// Value is the value of a record before or after a mutation.
+type Value struct {
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+}
+
+// Entry is an audit line.
+type Entry struct {
+	Time        time.Time `json:"time"`
+	ReconcileID string    `json:"reconcileID,omitempty"`
+	Provider    string    `json:"provider"`
+	Zone        string    `json:"zone"`
+	Action      string    `json:"action"` // "create", "update" or "delete"
+	Record      string    `json:"record"` // FQDN, or name relative to the zone
+	Type        string    `json:"type"`
+	Before      *Value    `json:"before,omitempty"` // nil for creates
+	After       *Value    `json:"after,omitempty"`  // nil for deletes
+	Object      string    `json:"object,omitempty"` // that triggered the mutation, see WithObject
+	Status      int       `json:"status"`           // HTTP status of the provider response, 0 if there was none
+	Error       string    `json:"error,omitempty"`
+}
+
+// Sink stores audit lines.
+type Sink interface {
+	// Write appends a line, a JSON object without newline.
+	Write(ctx context.Context, line []byte) error
+	// Flush stores the lines written so far, if the sink buffers them.
+	Flush(ctx context.Context) error
+}
+
+var (
+	mu   sync.Mutex
+	sink Sink
+)
+
+// Setup records the mutations to s from then on, or stops recording them if
+// s is nil.
+func Setup(s Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	sink = s
+}
+
+type contextKey int
+
+const (
+	reconcileIDKey contextKey = iota
+	objectKey
+)
+
+// WithReconcileID returns ctx whose mutations are recorded for the reconcile
+// id.
+func WithReconcileID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, reconcileIDKey, id)
+}
+
+// WithObject returns ctx whose mutations are recorded as triggered by the
+// object of kind, e.g. "node", "pod" or "service/infra/router" for the owner
+// of a record set when kind is empty.
+func WithObject(ctx context.Context, kind string, namespace string, name string) context.Context {
+	object := name
+	if namespace != "" {
+		object = namespace + "/" + object
+	}
+	if kind != "" {
+		object = kind + "/" + object
+	}
+	return context.WithValue(ctx, objectKey, object)
+}
+
+// Record records the mutation e that failed with err, if not nil. The time,
+// reconcile ID, object, provider and zone are filled in. Without a status,
+// it is taken from err, and is 200 if the mutation succeeded.
+func Record(ctx context.Context, e Entry, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if sink == nil {
+		return
+	}
+
+	e.Time = time.Now().UTC()
+	e.ReconcileID, _ = ctx.Value(reconcileIDKey).(string)
+	e.Object, _ = ctx.Value(objectKey).(string)
+	e.Provider, e.Zone = cfg.Provider, cfg.Zone
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if e.Status == 0 {
+		if err == nil {
+			e.Status = http.StatusOK
+		} else {
+			e.Status = metrics.StatusCode(err)
+		}
+	}
+
+	line, merr := json.Marshal(e)
+	if merr != nil {
+		logger.Error("Error occured while encoding audit entry", "record", e.Record, "error", merr.Error())
+		return
+	}
+	// The mutation is made, ctx being cancelled mustn't keep it from the log.
+	if werr := sink.Write(context.Background(), line); werr != nil {
+		metrics.ExecErrInc(werr)
+		logger.Error("Error occured while writing audit entry", "record", e.Record, "action", e.Action, "error", werr.Error())
+	}
+}
+
+// Flush stores the entries recorded so far, if the sink buffers them.
+func Flush(ctx context.Context) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if sink == nil {
+		return nil
+	}
+	return sink.Flush(ctx)
+}
+
+// Writer is a sink writing each line as it is recorded, e.g. to stdout or
+// a file opened for appending.
+type Writer struct {
+	W io.Writer
+}
+
+// Write writes line and a newline.
+func (w Writer) Write(_ context.Context, line []byte) error {
+	_, err := w.W.Write(append(line, '\n'))
+	return err
+}
+
+// Flush syncs files to disk.
+func (w Writer) Flush(context.Context) error {
+	if f, ok := w.W.(*os.File); ok && f != os.Stdout {
+		return f.Sync()
+	}
+	return nil
+}
+
+// OpenFile returns a sink appending to the file at path, created if needed.
+func OpenFile(path string) (Writer, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return Writer{W: f}, err
+}
@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestRecord(t *testing.T) {
+	var buf bytes.Buffer
+	Setup(Writer{W: &buf})
+	defer Setup(nil)
+
+	ctx := WithReconcileID(context.Background(), "4f2a9c1e")
+	ctx = WithObject(ctx, "pod", "infra", "router-0")
+	Record(ctx, Entry{Action: "create", Record: "router-0.dev.k8s.gather.town", Type: "A", After: &Value{Content: "1.2.3.4", TTL: 60}}, nil)
+	Record(WithObject(ctx, "", "", "service/infra/turn"), Entry{Action: "delete", Record: "turn.dev.k8s.gather.town", Type: "A", Before: &Value{Content: "1.2.3.5"}}, apierrors.NewTooManyRequests("slow down", 1))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expecting 2 lines, got %d: %q", len(lines), buf.String())
+	}
+	var created, deleted Entry
+	if err := json.Unmarshal([]byte(lines[0]), &created); err != nil {
+		t.Fatalf("Decoding %q returned error: %v", lines[0], err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &deleted); err != nil {
+		t.Fatalf("Decoding %q returned error: %v", lines[1], err)
+	}
+
+	if created.ReconcileID != "4f2a9c1e" || created.Object != "pod/infra/router-0" || created.Status != http.StatusOK || created.Before != nil || created.After.Content != "1.2.3.4" || created.Time.IsZero() {
+		t.Errorf("Expecting the create of router-0 by reconcile 4f2a9c1e, got %+v", created)
+	}
+	if deleted.Object != "service/infra/turn" || deleted.Status != http.StatusTooManyRequests || deleted.Error == "" || deleted.After != nil {
+		t.Errorf("Expecting the rate limited delete of turn, got %+v", deleted)
+	}
+}
+
+func TestRecordWithoutSink(t *testing.T) {
+	Setup(nil)
+	// Must not panic, and Flush has nothing to store.
+	Record(context.Background(), Entry{Action: "delete"}, errors.New("failed"))
+	if err := Flush(context.Background()); err != nil {
+		t.Errorf("Expecting Flush() to succeed without a sink, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Change is a record operation of a provider on behalf of a Kubernetes object.
+type Change struct {
+	Action    string    // "create", "update", "delete" or "blocked" if not attempted
+	Kind      string    // "node", "pod", the kind of a record set or empty if not made for an object
+	Namespace string    // namespace of the object, if namespaced
+	Name      string    // name of the object, or the record name if the object is gone
+	FQDN      string    // name of the records
+	Type      string    // type of the address record, "A" or "CNAME"
+	Content   string    // address of the record, if known
+	RecordIDs []string  // provider IDs of the records created
+	Err       error     // nil if the operation succeeded, why it was blocked otherwise
+	Time      time.Time // when the operation completed
+}
+
+// Reasons a record operation is blocked.
+var (
+	ErrNoAddress   = errors.New("no IP address found")
+	ErrPrefixGuard = errors.New("record does not match the prefix of any node")
+	ErrNameTaken   = errors.New("name has records not owned by the record set")
+)
+
+// Changes collects the changes made during a reconcile. It is safe for
+// concurrent use. Changes added to a nil *Changes are discarded.
+type Changes struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+// Add records a change, stamped with the current time if it has none.
+func (c *Changes) Add(change Change) {
+	if c == nil {
+		return
+	}
+	if change.Time.IsZero() {
+		change.Time = time.Now()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes = append(c.changes, change)
+}
+
+// Drain returns the changes recorded so far and forgets them.
+func (c *Changes) Drain() []Change {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changes := c.changes
+	c.changes = nil
+	return changes
+}
+
+// Record is an owned record found in the zone by a provider.
+type Record struct {
+	Kind    string // "node", "pod" or the kind of a record set
+	FQDN    string // name of the records
+	Type    string // type of the record, "TXT" for the ownership record
+	Content string
+}
+
+// Observed collects the owned records found in the zone during a reconcile,
+// by kind. It is safe for concurrent use. Records set on a nil *Observed are
+// discarded.
+type Observed struct {
+	mu      sync.Mutex
+	records map[string][]Record
+}
+
+// Set records the owned records of kind, replacing those found before.
+func (o *Observed) Set(kind string, records []Record) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.records == nil {
+		o.records = make(map[string][]Record)
+	}
+	o.records[kind] = records
+}
+
+// Drain returns the records found so far by kind and forgets them.
+func (o *Observed) Drain() map[string][]Record {
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	records := o.records
+	o.records = nil
+	return records
+}
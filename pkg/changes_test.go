@@ -0,0 +1,26 @@
+package common
+
+import "testing"
+
+func TestChanges(t *testing.T) {
+	var discard *Changes
+	discard.Add(Change{Action: "create"})
+	if got := discard.Drain(); got != nil {
+		t.Errorf("Expecting a nil *Changes to discard changes, got %v", got)
+	}
+
+	c := &Changes{}
+	c.Add(Change{Action: "create", Name: "sfu-8mh0d"})
+	c.Add(Change{Action: "delete", Name: "sfu-8quob"})
+
+	got := c.Drain()
+	if len(got) != 2 || got[0].Name != "sfu-8mh0d" || got[1].Name != "sfu-8quob" {
+		t.Errorf("Expecting both changes in order, got %v", got)
+	}
+	if got[0].Time.IsZero() {
+		t.Errorf("Expecting changes to be stamped with the current time")
+	}
+	if got := c.Drain(); len(got) != 0 {
+		t.Errorf("Expecting no changes after Drain(), got %v", got)
+	}
+}
@@ -1,20 +1,162 @@
 package common
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // shared structures
 type Node struct {
 	Name       string
 	ExternalIP string
+	Pool       string // value of the node label LABEL_KEY
+	RecordOptions
 }
 
 type Pod struct {
 	Name         string
+	Namespace    string
 	AssignedNode Node
 	Labels       map[string]string
+	RecordOptions
+}
+
+// RecordOptions are the settings of the records published for a node or pod,
+// which can be overridden through annotations.
+type RecordOptions struct {
+	Hostname  string // record name without subdomain and zone
+	TTL       int    // 0 means the default TTL
+	Proxied   *bool  // nil means the default of the node pool, Cloudflare only
+	Subdomain string // empty means the configured subdomain
+}
+
+// SubdomainOr returns the subdomain of the records, or def if not overridden.
+func (o RecordOptions) SubdomainOr(def string) string {
+	if o.Subdomain != "" {
+		return o.Subdomain
+	}
+	return def
+}
+
+// FQDN returns the FQDN of the records, published under subdomain of zone
+// unless the subdomain is overridden.
+func (o RecordOptions) FQDN(subdomain string, zone string) string {
+	if subdomain = o.SubdomainOr(subdomain); subdomain != "" {
+		return fmt.Sprintf("%s.%s.%s", o.Hostname, subdomain, zone)
+	}
+	return fmt.Sprintf("%s.%s", o.Hostname, zone)
+}
+
+// TTLOr returns the TTL of the records, or def if not overridden.
+func (o RecordOptions) TTLOr(def int) int {
+	if o.TTL > 0 {
+		return o.TTL
+	}
+	return def
+}
+
+// ProxiedOr returns whether the A record is proxied, or def if not overridden.
+func (o RecordOptions) ProxiedOr(def bool) bool {
+	if o.Proxied != nil {
+		return *o.Proxied
+	}
+	return def
+}
+
+// RecordSet is a DNS record with one or more targets that is published for a
+// Kubernetes object other than a node or pod, e.g. a Service.
+type RecordSet struct {
+	Name    string   // record name without subdomain and zone, e.g. "router"
+	Type    string   // "A" or "CNAME"
+	Targets []string // IPv4 addresses for "A", a single hostname for "CNAME"
+	Owner   string   // object the record set is published for, e.g. "service/infra/router"
+	TTL     int      // 0 means the default TTL
+}
+
+// TTLOr returns the TTL of the records, or def if not set.
+func (s RecordSet) TTLOr(def int) int {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+	return def
+}
+
+// TXTName returns the name of the ownership TXT record of the record set. A
+// CNAME record cannot share its name with other records, so its TXT record is
+// prefixed.
+func (s RecordSet) TXTName() string {
+	if s.Type == "CNAME" {
+		return "cname-" + s.Name
+	}
+	return s.Name
+}
+
+// RecordSetLabel returns the content of the ownership TXT record of a record
+// set of the given kind, e.g. "service". The label of nodes must not be a
+// substring of it, as records are looked up by label content.
+func RecordSetLabel(kind string, env string, set RecordSet) string {
+	return fmt.Sprintf("heritage=casper-3,%s-sync=true,environment=%s,owner=%s,type=%s", kind, env, set.Owner, set.Type)
+}
+
+// LabelKind returns what the TXT record with the given content was created for:
+// "node", "pod" or the kind of a record set.
+func LabelKind(content string) string {
+	for k, v := range ParseLabel(content) {
+		if strings.HasSuffix(k, "-sync") && v == "true" {
+			return strings.TrimSuffix(k, "-sync")
+		}
+	}
+	return "node"
+}
+
+// RecordSetName returns the name of a record set from the name of its
+// ownership TXT record relative to the subdomain.
+func RecordSetName(txtName string, recordType string) string {
+	if recordType == "CNAME" {
+		return strings.TrimPrefix(txtName, "cname-")
+	}
+	return txtName
+}
+
+// SyncError is returned by a provider when record operations failed during a
+// sync. The sync carries on past a failing record, so it lists all of them.
+type SyncError struct {
+	Records []string
+}
+
+func (e *SyncError) Error() string {
+	return fmt.Sprintf("failed to sync %d record(s): %s", len(e.Records), strings.Join(e.Records, ", "))
+}
+
+// SyncErr returns a *SyncError for the failed records, or nil if there are none.
+func SyncErr(failed []string) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return &SyncError{Records: failed}
+}
+
+// ParseLabel parses the content of a TXT record created by casper-3, e.g.
+// "heritage=casper-3,environment=dev", into its key value pairs.
+func ParseLabel(content string) map[string]string {
+	fields := make(map[string]string)
+	for _, kv := range strings.Split(strings.Trim(content, `"`), ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return fields
+}
+
+// IsOwned reports whether the content of a TXT record marks it as created by
+// casper-3 for the given environment.
+func IsOwned(content string, env string) bool {
+	fields := ParseLabel(content)
+	return fields["heritage"] == "casper-3" && fields["environment"] == env
 }
 
 // Compare slices: https://stackoverflow.com/a/45428032/577133
@@ -48,3 +190,35 @@ func RecordPrefixMatchesNodePrefixes(recordName string, nodeNames []string) bool
 	}
 	return false
 }
+
+// WithGrace returns a context that is cancelled once grace has elapsed after
+// parent is done, rather than together with parent. It lets in-flight
+// operations, like creating an A/TXT record pair, complete during shutdown.
+// Values are looked up in parent.
+func WithGrace(parent context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-parent.Done():
+		}
+		t := time.NewTimer(grace)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+		case <-t.C:
+			cancel()
+		}
+	}()
+	return graceContext{Context: ctx, parent: parent}, cancel
+}
+
+type graceContext struct {
+	context.Context
+	parent context.Context
+}
+
+func (c graceContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
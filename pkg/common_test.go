@@ -1,7 +1,11 @@
 package common
 
 import (
+	"context"
+	"errors"
+	"reflect"
 	"testing"
+	"time"
 )
 
 type recordPrefixMatchesNodePrefixesTest struct {
@@ -35,3 +39,137 @@ func TestRecordPrefixMatchesNodePrefixes(t *testing.T) {
 		}
 	}
 }
+
+func TestSyncErr(t *testing.T) {
+	if err := SyncErr(nil); err != nil {
+		t.Errorf("Expecting nil error for no failed records, got %v", err)
+	}
+
+	err := SyncErr([]string{"sfu-abc", "sfu-def"})
+	var syncErr *SyncError
+	if !errors.As(err, &syncErr) {
+		t.Fatalf("Expecting *SyncError, got %T", err)
+	}
+	if got, want := err.Error(), "failed to sync 2 record(s): sfu-abc, sfu-def"; got != want {
+		t.Errorf("SyncErr() = %q; want %q", got, want)
+	}
+}
+
+func TestWithGrace(t *testing.T) {
+	type key struct{}
+	parent, cancelParent := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	ctx, cancel := WithGrace(parent, 50*time.Millisecond)
+	defer cancel()
+
+	if got := ctx.Value(key{}); got != "value" {
+		t.Errorf("Expecting value of parent context, got %v", got)
+	}
+
+	cancelParent()
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("Expecting context to outlive its parent, got %v", err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Expecting context to be cancelled after the grace period")
+	}
+}
+
+func TestParseLabel(t *testing.T) {
+	tests := []struct {
+		content string
+		want    map[string]string
+	}{
+		{"heritage=casper-3,environment=dev", map[string]string{"heritage": "casper-3", "environment": "dev"}},
+		{`"heritage=casper-3,environment=dev"`, map[string]string{"heritage": "casper-3", "environment": "dev"}},
+		{"heritage=casper-3,pod-sync=true,environment=dev,podName=router-0", map[string]string{"heritage": "casper-3", "pod-sync": "true", "environment": "dev", "podName": "router-0"}},
+		{"v=spf1 include:_spf.google.com ~all", map[string]string{"v": "spf1 include:_spf.google.com ~all"}},
+		{"", map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := ParseLabel(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLabel(%q) = %v; want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestIsOwned(t *testing.T) {
+	tests := []struct {
+		content string
+		env     string
+		want    bool
+	}{
+		{"heritage=casper-3,environment=dev", "dev", true},
+		{"heritage=casper-3,pod-sync=true,environment=dev,podName=router-0", "dev", true},
+		{"heritage=casper-3,environment=development", "dev", false},
+		{"heritage=casper-3,environment=dev", "prod", false},
+		{"heritage=external-dns,environment=dev", "dev", false},
+	}
+	for _, tt := range tests {
+		if got := IsOwned(tt.content, tt.env); got != tt.want {
+			t.Errorf("IsOwned(%q, %q) = %v; want %v", tt.content, tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestLabelKind(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"heritage=casper-3,environment=dev", "node"},
+		{"heritage=casper-3,pod-sync=true,environment=dev,podName=router-0", "pod"},
+		{RecordSetLabel("service", "dev", RecordSet{Name: "router", Type: "A", Owner: "service/infra/router"}), "service"},
+	}
+	for _, tt := range tests {
+		if got := LabelKind(tt.content); got != tt.want {
+			t.Errorf("LabelKind(%q) = %q; want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestRecordSetTXTName(t *testing.T) {
+	tests := []struct {
+		set  RecordSet
+		want string
+	}{
+		{RecordSet{Name: "router", Type: "A"}, "router"},
+		{RecordSet{Name: "router", Type: "CNAME"}, "cname-router"},
+	}
+	for _, tt := range tests {
+		got := tt.set.TXTName()
+		if got != tt.want {
+			t.Errorf("TXTName() of %v = %q; want %q", tt.set, got, tt.want)
+		}
+		if name := RecordSetName(got, tt.set.Type); name != tt.set.Name {
+			t.Errorf("RecordSetName(%q, %q) = %q; want %q", got, tt.set.Type, name, tt.set.Name)
+		}
+	}
+}
+
+func TestRecordOptions(t *testing.T) {
+	proxied := false
+	o := RecordOptions{Hostname: "sfu-eu-1", TTL: 60, Proxied: &proxied, Subdomain: "media.dev"}
+	if got := o.SubdomainOr("dev"); got != "media.dev" {
+		t.Errorf("SubdomainOr() = %q; want %q", got, "media.dev")
+	}
+	if got := o.TTLOr(1800); got != 60 {
+		t.Errorf("TTLOr() = %d; want %d", got, 60)
+	}
+	if got := o.ProxiedOr(true); got != false {
+		t.Errorf("ProxiedOr() = %v; want %v", got, false)
+	}
+
+	var d RecordOptions
+	if got := d.SubdomainOr("dev"); got != "dev" {
+		t.Errorf("SubdomainOr() = %q; want %q", got, "dev")
+	}
+	if got := d.TTLOr(1800); got != 1800 {
+		t.Errorf("TTLOr() = %d; want %d", got, 1800)
+	}
+	if got := d.ProxiedOr(true); got != true {
+		t.Errorf("ProxiedOr() = %v; want %v", got, true)
+	}
+}
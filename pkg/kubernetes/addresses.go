@@ -0,0 +1,99 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// AddressAnnotation sets the address published for a node or pod, see
+// AddressFromAnnotation.
+const AddressAnnotation = AnnotationPrefix + "address"
+
+// Address types of ADDRESS_TYPES, tried in the configured order.
+const (
+	AddressExternalIP     = "ExternalIP" // the ExternalIP of the node
+	AddressInternalIP     = "InternalIP" // the InternalIP of the node
+	AddressFromAnnotation = "Annotation" // AddressAnnotation of the pod or its node
+	AddressHostIP         = "HostIP"     // the hostIP of the pod, pods only
+	AddressPodIP          = "PodIP"      // the podIP of the pod, pods only
+)
+
+// ValidateAddressTypes returns an error if types is empty or has an unknown
+// address type.
+func ValidateAddressTypes(types []string) error {
+	if len(types) == 0 {
+		return errors.New("no address type")
+	}
+	for _, t := range types {
+		switch t {
+		case AddressExternalIP, AddressInternalIP, AddressFromAnnotation, AddressHostIP, AddressPodIP:
+		default:
+			return fmt.Errorf("unknown address type %q", t)
+		}
+	}
+	return nil
+}
+
+// nodeAddress returns the first IPv4 address of node in the order of types,
+// or an empty string if it has none. Pod address types are skipped.
+func nodeAddress(node *v1.Node, types []string) string {
+	for _, t := range types {
+		if ip := nodeAddressOfType(node, t); ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+// podAddress returns the first IPv4 address of pod, running on node, in the
+// order of types, or an empty string if it has none. An annotation of the pod
+// takes precedence over one of its node.
+func podAddress(pod v1.Pod, node *v1.Node, types []string) string {
+	for _, t := range types {
+		var ip string
+		switch t {
+		case AddressHostIP:
+			ip = ipv4(pod.Status.HostIP)
+		case AddressPodIP:
+			ip = ipv4(pod.Status.PodIP)
+		case AddressFromAnnotation:
+			ip = ipv4(pod.Annotations[AddressAnnotation])
+			if ip == "" {
+				ip = nodeAddressOfType(node, t)
+			}
+		default:
+			ip = nodeAddressOfType(node, t)
+		}
+		if ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+func nodeAddressOfType(node *v1.Node, t string) string {
+	if node == nil {
+		return ""
+	}
+	if t == AddressFromAnnotation {
+		return ipv4(node.Annotations[AddressAnnotation])
+	}
+	for _, addr := range node.Status.Addresses {
+		if string(addr.Type) == t && ipv4(addr.Address) != "" {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+// ipv4 returns s if it is an IPv4 address, as records are "A" records, or an
+// empty string otherwise.
+func ipv4(s string) string {
+	if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+		return s
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package kubernetes
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeAddress(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{AddressAnnotation: "203.0.113.7"}},
+		Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+			{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+			{Type: v1.NodeExternalIP, Address: "2001:db8::1"},
+			{Type: v1.NodeExternalIP, Address: "1.1.1.1"},
+			{Type: v1.NodeHostName, Address: "sfu-8mh0d"},
+		}},
+	}
+	bare := &v1.Node{Status: v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeHostName, Address: "sfu-8mh0d"}}}}
+
+	tests := []struct {
+		name  string
+		node  *v1.Node
+		types []string
+		want  string
+	}{
+		{"external IPv4 over IPv6", node, []string{AddressExternalIP}, "1.1.1.1"},
+		{"internal first", node, []string{AddressInternalIP, AddressExternalIP}, "10.0.0.1"},
+		{"annotation first", node, []string{AddressFromAnnotation, AddressExternalIP}, "203.0.113.7"},
+		{"pod types skipped", node, []string{AddressPodIP, AddressHostIP, AddressExternalIP}, "1.1.1.1"},
+		{"fallback", bare, []string{AddressExternalIP, AddressInternalIP}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeAddress(tt.node, tt.types); got != tt.want {
+				t.Errorf("Expecting %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPodAddress(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{AddressAnnotation: "203.0.113.7"}},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.1.1.1"}}},
+	}
+	pod := v1.Pod{Status: v1.PodStatus{HostIP: "10.0.0.1", PodIP: "10.244.0.5"}}
+	annotated := v1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{AddressAnnotation: "203.0.113.9"}}}
+
+	tests := []struct {
+		name  string
+		pod   v1.Pod
+		node  *v1.Node
+		types []string
+		want  string
+	}{
+		{"node external IP", pod, node, []string{AddressExternalIP}, "1.1.1.1"},
+		{"host IP", pod, node, []string{AddressHostIP, AddressExternalIP}, "10.0.0.1"},
+		{"pod IP", pod, node, []string{AddressPodIP}, "10.244.0.5"},
+		{"pod annotation", annotated, node, []string{AddressFromAnnotation}, "203.0.113.9"},
+		{"node annotation", pod, node, []string{AddressFromAnnotation}, "203.0.113.7"},
+		{"fallback without node", annotated, nil, []string{AddressHostIP, AddressExternalIP}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := podAddress(tt.pod, tt.node, tt.types); got != tt.want {
+				t.Errorf("Expecting %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateAddressTypes(t *testing.T) {
+	if err := ValidateAddressTypes([]string{AddressHostIP, AddressExternalIP}); err != nil {
+		t.Errorf("Expecting no error, got %v", err)
+	}
+	if err := ValidateAddressTypes([]string{"externalip"}); err == nil {
+		t.Errorf("Expecting an error for an unknown address type")
+	}
+	if err := ValidateAddressTypes(nil); err == nil {
+		t.Errorf("Expecting an error for no address type")
+	}
+}
@@ -0,0 +1,141 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gathertown/casper-3/internal/metrics"
+	common "github.com/gathertown/casper-3/pkg"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Annotations overriding the records published for a node or pod.
+const (
+	AnnotationPrefix    = "casper-3.gather.town/"
+	HostnameAnnotation  = AnnotationPrefix + "hostname"
+	TTLAnnotation       = AnnotationPrefix + "ttl"
+	ProxiedAnnotation   = AnnotationPrefix + "proxied"
+	SubdomainAnnotation = AnnotationPrefix + "subdomain"
+)
+
+// Annotations set by casper-3 on the nodes and pods it publishes.
+const (
+	FQDNAnnotation           = AnnotationPrefix + "fqdn"
+	RecordIDsAnnotation      = AnnotationPrefix + "record-ids"
+	LastSyncAnnotation       = AnnotationPrefix + "last-sync"
+	LastSyncResultAnnotation = AnnotationPrefix + "last-sync-result"
+)
+
+// recordOptions returns the record options of an object from its annotations.
+// The hostname defaults to name. Invalid values are logged and ignored.
+func recordOptions(object string, name string, annotations map[string]string) common.RecordOptions {
+	opts := common.RecordOptions{Hostname: name}
+
+	if v, ok := annotations[HostnameAnnotation]; ok {
+		if errs := validation.IsDNS1123Label(v); len(errs) > 0 {
+			logger.Info("Ignoring invalid annotation", "object", object, "annotation", HostnameAnnotation, "value", v, "error", errs[0])
+		} else {
+			opts.Hostname = v
+		}
+	}
+
+	if v, ok := annotations[TTLAnnotation]; ok {
+		ttl, err := strconv.Atoi(v)
+		if err != nil || ttl <= 0 {
+			logger.Info("Ignoring invalid annotation", "object", object, "annotation", TTLAnnotation, "value", v)
+		} else {
+			opts.TTL = ttl
+		}
+	}
+
+	if v, ok := annotations[ProxiedAnnotation]; ok {
+		proxied, err := strconv.ParseBool(v)
+		if err != nil {
+			logger.Info("Ignoring invalid annotation", "object", object, "annotation", ProxiedAnnotation, "value", v)
+		} else {
+			opts.Proxied = &proxied
+		}
+	}
+
+	if v, ok := annotations[SubdomainAnnotation]; ok {
+		if errs := validation.IsDNS1123Subdomain(v); len(errs) > 0 {
+			logger.Info("Ignoring invalid annotation", "object", object, "annotation", SubdomainAnnotation, "value", v, "error", errs[0])
+		} else {
+			opts.Subdomain = v
+		}
+	}
+
+	return opts
+}
+
+// AnnotateChanges annotates the nodes and pods records were created for with
+// the published FQDN, the record IDs and the time and result of the sync, and
+// removes the FQDN and record IDs once their records are deleted. Objects that
+// are gone by now are skipped.
+func (c *Cluster) AnnotateChanges(ctx context.Context, changes []common.Change) error {
+	var failed bool
+	for _, change := range changes {
+		if change.Kind != "node" && change.Kind != "pod" {
+			continue
+		}
+
+		// A nil value removes the annotation
+		var annotations map[string]interface{}
+		switch {
+		case change.Action == "create":
+			annotations = map[string]interface{}{
+				LastSyncAnnotation:       change.Time.UTC().Format(time.RFC3339),
+				LastSyncResultAnnotation: "success",
+			}
+			if change.Err != nil {
+				annotations[LastSyncResultAnnotation] = "failed"
+			} else {
+				annotations[FQDNAnnotation] = change.FQDN
+				annotations[RecordIDsAnnotation] = strings.Join(change.RecordIDs, ",")
+			}
+		case change.Action == "delete" && change.Err == nil:
+			annotations = map[string]interface{}{
+				FQDNAnnotation:      nil,
+				RecordIDsAnnotation: nil,
+			}
+		default:
+			continue
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": annotations},
+		})
+		if err != nil {
+			failed = true
+			logger.Error("Error occured while encoding annotations", "kind", change.Kind, "namespace", change.Namespace, "name", change.Name, "error", err.Error())
+			continue
+		}
+
+		if change.Kind == "node" {
+			_, err = c.Client.CoreV1().Nodes().Patch(ctx, change.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		} else {
+			_, err = c.Client.CoreV1().Pods(change.Namespace).Patch(ctx, change.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		}
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			metrics.ExecErrInc(err)
+			failed = true
+			logger.Error("Error occured while annotating object", "kind", change.Kind, "namespace", change.Namespace, "name", change.Name, "error", err.Error())
+		}
+	}
+
+	if failed {
+		return errAnnotate
+	}
+	return nil
+}
+
+var errAnnotate = errors.New("one or more objects could not be annotated")
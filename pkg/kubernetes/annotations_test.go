@@ -0,0 +1,102 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	common "github.com/gathertown/casper-3/pkg"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRecordOptions(t *testing.T) {
+	proxied := false
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        common.RecordOptions
+	}{
+		{"no annotations", nil, common.RecordOptions{Hostname: "sfu-8mh0d"}},
+		{"all annotations", map[string]string{
+			HostnameAnnotation:  "sfu-eu-1",
+			TTLAnnotation:       "60",
+			ProxiedAnnotation:   "false",
+			SubdomainAnnotation: "media.dev",
+		}, common.RecordOptions{Hostname: "sfu-eu-1", TTL: 60, Proxied: &proxied, Subdomain: "media.dev"}},
+		{"invalid annotations", map[string]string{
+			HostnameAnnotation:  "sfu.eu",
+			TTLAnnotation:       "-1",
+			ProxiedAnnotation:   "maybe",
+			SubdomainAnnotation: "Media_Dev",
+		}, common.RecordOptions{Hostname: "sfu-8mh0d"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := recordOptions("node/sfu-8mh0d", "sfu-8mh0d", tt.annotations)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expecting %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAnnotateChanges(t *testing.T) {
+	c := setupClusterWithPods(t)
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	changes := []common.Change{
+		{Action: "create", Kind: "node", Name: mockNodeOpts.nodeName, FQDN: "sfu-8mh0d.dev.k8s.gather.town", RecordIDs: []string{"1", "2"}, Time: now},
+		{Action: "create", Kind: "pod", Name: "router-0", FQDN: "router-0.dev.k8s.gather.town", Err: errors.New("rate limited"), Time: now},
+		{Action: "create", Kind: "pod", Name: "gone", FQDN: "gone.dev.k8s.gather.town", Time: now},
+		{Action: "delete", Kind: "pod", Name: "router-1", FQDN: "router-1.dev.k8s.gather.town", Time: now},
+	}
+	if err := c.AnnotateChanges(context.TODO(), changes); err != nil {
+		t.Fatalf("AnnotateChanges() returned error: %v", err)
+	}
+
+	node, _ := c.Client.CoreV1().Nodes().Get(context.TODO(), mockNodeOpts.nodeName, metav1.GetOptions{})
+	want := map[string]string{
+		FQDNAnnotation:           "sfu-8mh0d.dev.k8s.gather.town",
+		RecordIDsAnnotation:      "1,2",
+		LastSyncAnnotation:       "2021-03-01T12:00:00Z",
+		LastSyncResultAnnotation: "success",
+	}
+	if !reflect.DeepEqual(node.Annotations, want) {
+		t.Errorf("Expecting node annotations %v, got %v", want, node.Annotations)
+	}
+
+	pod, _ := c.Client.CoreV1().Pods("").Get(context.TODO(), "router-0", metav1.GetOptions{})
+	want = map[string]string{
+		LastSyncAnnotation:       "2021-03-01T12:00:00Z",
+		LastSyncResultAnnotation: "failed",
+	}
+	if !reflect.DeepEqual(pod.Annotations, want) {
+		t.Errorf("Expecting pod annotations %v, got %v", want, pod.Annotations)
+	}
+
+	pod, _ = c.Client.CoreV1().Pods("").Get(context.TODO(), "router-1", metav1.GetOptions{})
+	if len(pod.Annotations) != 0 {
+		t.Errorf("Expecting no annotations for a deletion, got %v", pod.Annotations)
+	}
+
+	// Deleting the records removes their FQDN and IDs.
+	changes = []common.Change{
+		{Action: "delete", Kind: "node", Name: mockNodeOpts.nodeName, FQDN: "sfu-8mh0d.dev.k8s.gather.town", Time: now},
+	}
+	if err := c.AnnotateChanges(context.TODO(), changes); err != nil {
+		t.Fatalf("AnnotateChanges() returned error: %v", err)
+	}
+
+	node, _ = c.Client.CoreV1().Nodes().Get(context.TODO(), mockNodeOpts.nodeName, metav1.GetOptions{})
+	want = map[string]string{
+		LastSyncAnnotation:       "2021-03-01T12:00:00Z",
+		LastSyncResultAnnotation: "success",
+	}
+	if !reflect.DeepEqual(node.Annotations, want) {
+		t.Errorf("Expecting node annotations %v, got %v", want, node.Annotations)
+	}
+}
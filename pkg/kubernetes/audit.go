@@ -0,0 +1,99 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/gathertown/casper-3/internal/metrics"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AuditKey is the key of the audit lines in the data of the ConfigMap.
+const AuditKey = "audit.jsonl"
+
+// maxAuditBytes keeps the ConfigMap below the 1MiB limit of objects.
+const maxAuditBytes = 900 * 1024
+
+// AuditConfigMap is an audit sink keeping the last lines in a ConfigMap, a
+// ring buffer of Size lines. Lines are buffered until Flush, so that a
+// reconcile updates the ConfigMap once.
+type AuditConfigMap struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Size      int // lines kept
+
+	mu      sync.Mutex
+	pending [][]byte
+}
+
+// Write buffers line until the next Flush. Only the last Size lines are
+// buffered while the ConfigMap can't be updated.
+func (a *AuditConfigMap) Write(_ context.Context, line []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = append(a.pending, line)
+	if len(a.pending) > a.Size {
+		a.pending = a.pending[len(a.pending)-a.Size:]
+	}
+	return nil
+}
+
+// Flush appends the buffered lines to the ConfigMap, created if needed, and
+// drops the oldest lines beyond Size. The lines are kept for the next Flush
+// if the update fails.
+func (a *AuditConfigMap) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.pending) == 0 {
+		return nil
+	}
+
+	configMaps := a.Client.CoreV1().ConfigMaps(a.Namespace)
+	cm, err := configMaps.Get(ctx, a.Name, metav1.GetOptions{})
+	create := apierrors.IsNotFound(err)
+	if create {
+		cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:      a.Name,
+			Namespace: a.Namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "casper-3"},
+		}}
+	} else if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+
+	var lines [][]byte
+	if existing := cm.Data[AuditKey]; existing != "" {
+		lines = bytes.Split([]byte(existing), []byte("\n"))
+	}
+	lines = append(lines, a.pending...)
+	if len(lines) > a.Size {
+		lines = lines[len(lines)-a.Size:]
+	}
+	data := bytes.Join(lines, []byte("\n"))
+	for len(data) > maxAuditBytes && len(lines) > 1 {
+		lines = lines[1:]
+		data = bytes.Join(lines, []byte("\n"))
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[AuditKey] = string(data)
+
+	if create {
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	} else {
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+	a.pending = nil
+	return nil
+}
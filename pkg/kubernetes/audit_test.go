@@ -0,0 +1,38 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	f "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAuditConfigMap(t *testing.T) {
+	client := f.NewSimpleClientset()
+	a := &AuditConfigMap{Client: client, Namespace: "infrastructure", Name: "casper-3-audit", Size: 3}
+
+	for i := 0; i < 2; i++ {
+		_ = a.Write(context.TODO(), []byte(fmt.Sprintf(`{"n":%d}`, i)))
+	}
+	if err := a.Flush(context.TODO()); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	for i := 2; i < 4; i++ {
+		_ = a.Write(context.TODO(), []byte(fmt.Sprintf(`{"n":%d}`, i)))
+	}
+	if err := a.Flush(context.TODO()); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+
+	cm, err := client.CoreV1().ConfigMaps("infrastructure").Get(context.TODO(), "casper-3-audit", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get(ConfigMap) returned error: %v", err)
+	}
+	want := strings.Join([]string{`{"n":1}`, `{"n":2}`, `{"n":3}`}, "\n")
+	if got := cm.Data[AuditKey]; got != want {
+		t.Errorf("Expecting the last 3 lines %q in %s, got %q", want, AuditKey, got)
+	}
+}
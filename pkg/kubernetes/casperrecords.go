@@ -0,0 +1,339 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
+	common "github.com/gathertown/casper-3/pkg"
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// CasperRecordResource is the resource of the CasperRecord custom resource,
+// see deployments/base/casperrecord-crd.yaml.
+var CasperRecordResource = schema.GroupVersionResource{Group: "casper-3.gather.town", Version: "v1alpha1", Resource: "casperrecords"}
+
+// Condition types and reasons reported on CasperRecords.
+const (
+	ConditionReady  = "Ready"
+	ReasonSynced    = "Synced"
+	ReasonSyncError = "SyncFailed"
+	ReasonInvalid   = "Invalid"
+	ReasonNoTargets = "NoTargets"
+	ReasonConflict  = "Conflict"
+)
+
+// CasperRecord declares a record set to publish next to the records of the
+// nodes and pods.
+type CasperRecord struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CasperRecordSpec   `json:"spec"`
+	Status CasperRecordStatus `json:"status,omitempty"`
+}
+
+// CasperRecordSpec is the record set of a CasperRecord.
+type CasperRecordSpec struct {
+	Name    string              `json:"name"`              // record name without subdomain and zone
+	Type    string              `json:"type"`              // "A" or "CNAME"
+	Targets []string            `json:"targets,omitempty"` // static targets
+	TTL     int                 `json:"ttl,omitempty"`     // 0 means the default TTL
+	Source  *CasperRecordSource `json:"source,omitempty"`  // dynamic targets, "A" records only
+}
+
+// CasperRecordSource selects the nodes or pods whose node addresses, see
+// ADDRESS_TYPES, are targets of an "A" record. Pods are
+// selected in the namespace of the CasperRecord.
+type CasperRecordSource struct {
+	Kind     string                `json:"kind"`               // "Node" or "Pod"
+	Name     string                `json:"name,omitempty"`     // a single object
+	Selector *metav1.LabelSelector `json:"selector,omitempty"` // objects matching the selector
+}
+
+// CasperRecordStatus is the last sync of a CasperRecord.
+type CasperRecordStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Targets            []string           `json:"targets,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// RecordSet returns the record set of r, its owner is "record/<namespace>/<name>".
+func (r *CasperRecord) RecordSet() RecordSet {
+	return RecordSet{
+		Name:    r.Spec.Name,
+		Type:    r.Spec.Type,
+		Targets: r.Status.Targets,
+		Owner:   fmt.Sprintf("record/%s/%s", r.Namespace, r.Name),
+		TTL:     r.Spec.TTL,
+	}
+}
+
+// CasperRecords returns the CasperRecords of the namespaces of RecordSelector
+// with their targets resolved into Status.Targets. Records that can't be
+// published, e.g. under a name claimed by a node, a pod, a record set or an
+// older record, have their Ready condition set to false and no targets, see
+// RecordSets.
+func (c *Cluster) CasperRecords(ctx context.Context, claims Claims) (_ []*CasperRecord, err error) {
+	ctx, span := tracing.Start(ctx, "kubernetes.CasperRecords")
+	defer func() { tracing.End(span, err) }()
+
+	s := RecordSelector()
+	var records []*CasperRecord
+	c.listed = make(map[string]CasperRecordStatus)
+	for _, namespace := range s.namespaces() {
+		list, err := c.listResource(ctx, CasperRecordResource, namespace, metav1.ListOptions{})
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return nil, err
+		}
+		for _, item := range list.Items {
+			if !s.NamespaceAllowed(item.GetNamespace()) {
+				continue
+			}
+			r := &CasperRecord{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), r); err != nil {
+				return nil, err
+			}
+			// setReady changes the conditions in place
+			listed := r.Status
+			listed.Conditions = append([]metav1.Condition(nil), r.Status.Conditions...)
+			c.listed[r.Namespace+"/"+r.Name] = listed
+			r.Status.Targets = nil
+			records = append(records, r)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].CreationTimestamp.Before(&records[j].CreationTimestamp)
+	})
+
+	for _, r := range records {
+		if err := validateCasperRecord(r); err != nil {
+			r.setReady(metav1.ConditionFalse, ReasonInvalid, err.Error())
+			continue
+		}
+		// A name is published once, for the oldest record claiming it.
+		owner := r.RecordSet().Owner
+		if claimant, found := claims[r.Spec.Name]; found && claimant != owner {
+			r.setReady(metav1.ConditionFalse, ReasonConflict, fmt.Sprintf("record %s is published for %s", r.Spec.Name, claimant))
+			continue
+		}
+
+		targets, err := c.casperRecordTargets(ctx, r)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return nil, err
+		}
+		if len(targets) == 0 {
+			r.setReady(metav1.ConditionFalse, ReasonNoTargets, "no targets found")
+			continue
+		}
+		claims.Claim(r.Spec.Name, owner)
+		r.Status.Targets = targets
+	}
+	return records, nil
+}
+
+// RecordSets returns the record sets of the records to publish, those with
+// targets.
+func RecordSets(records []*CasperRecord) []RecordSet {
+	var sets []RecordSet
+	for _, r := range records {
+		if len(r.Status.Targets) > 0 {
+			sets = append(sets, r.RecordSet())
+		}
+	}
+	return sets
+}
+
+// UpdateCasperRecordStatus writes the result of syncing the record sets of
+// records, as returned by SyncRecordSets, to the status of those whose status
+// changed since CasperRecords listed them.
+func (c *Cluster) UpdateCasperRecordStatus(ctx context.Context, records []*CasperRecord, syncErr error) error {
+	failed := make(map[string]bool)
+	var se *common.SyncError
+	if errors.As(syncErr, &se) {
+		for _, name := range se.Records {
+			failed[name] = true
+		}
+	}
+
+	var updateFailed bool
+	for _, r := range records {
+		if len(r.Status.Targets) > 0 {
+			switch {
+			case failed[r.Spec.Name]:
+				r.setReady(metav1.ConditionFalse, ReasonSyncError, "failed to sync the records")
+			case syncErr != nil && se == nil:
+				r.setReady(metav1.ConditionFalse, ReasonSyncError, syncErr.Error())
+			default:
+				r.setReady(metav1.ConditionTrue, ReasonSynced, "records synced")
+			}
+		}
+		r.Status.ObservedGeneration = r.Generation
+		if listed, found := c.listed[r.Namespace+"/"+r.Name]; found && apiequality.Semantic.DeepEqual(r.Status, listed) {
+			continue
+		}
+
+		if err := c.updateCasperRecordStatus(ctx, r); err != nil {
+			metrics.ExecErrInc(err)
+			updateFailed = true
+			logger.Error("Error occured while updating record status", "namespace", r.Namespace, "name", r.Name, "error", err.Error())
+		}
+	}
+
+	if updateFailed {
+		return errors.New("one or more record statuses could not be updated")
+	}
+	return nil
+}
+
+func (c *Cluster) updateCasperRecordStatus(ctx context.Context, r *CasperRecord) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+	if err != nil {
+		return err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	_, err = c.Dynamic.Resource(CasperRecordResource).Namespace(r.Namespace).UpdateStatus(ctx, u, metav1.UpdateOptions{})
+	return err
+}
+
+// setReady sets the Ready condition of r.
+func (r *CasperRecord) setReady(status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&r.Status.Conditions, metav1.Condition{
+		Type:               ConditionReady,
+		Status:             status,
+		ObservedGeneration: r.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// validateCasperRecord returns an error if the spec of r is invalid.
+func validateCasperRecord(r *CasperRecord) error {
+	if errs := validation.IsDNS1123Subdomain(r.Spec.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid name %q: %s", r.Spec.Name, errs[0])
+	}
+	if r.Spec.TTL < 0 {
+		return fmt.Errorf("invalid ttl %d", r.Spec.TTL)
+	}
+
+	switch r.Spec.Type {
+	case "A":
+		for _, target := range r.Spec.Targets {
+			if ip := net.ParseIP(target); ip == nil || ip.To4() == nil {
+				return fmt.Errorf("invalid IPv4 address %q", target)
+			}
+		}
+	case "CNAME":
+		if len(r.Spec.Targets) != 1 || r.Spec.Source != nil {
+			return errors.New("a CNAME record has a single target and no source")
+		}
+		if errs := validation.IsDNS1123Subdomain(r.Spec.Targets[0]); len(errs) > 0 {
+			return fmt.Errorf("invalid hostname %q: %s", r.Spec.Targets[0], errs[0])
+		}
+	default:
+		return fmt.Errorf("unsupported type %q", r.Spec.Type)
+	}
+
+	if s := r.Spec.Source; s != nil {
+		if s.Kind != "Node" && s.Kind != "Pod" {
+			return fmt.Errorf("unsupported source kind %q", s.Kind)
+		}
+		if (s.Name == "") == (s.Selector == nil) {
+			return errors.New("a source has either a name or a selector")
+		}
+		if s.Selector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(s.Selector); err != nil {
+				return fmt.Errorf("invalid selector: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// casperRecordTargets returns the sorted static and dynamic targets of r.
+// Nodes and pods that are not eligible for publishing are not targets.
+func (c *Cluster) casperRecordTargets(ctx context.Context, r *CasperRecord) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, target := range r.Spec.Targets {
+		seen[target] = true
+	}
+
+	if s := r.Spec.Source; s != nil {
+		var nodeNames []string
+		opts := metav1.ListOptions{}
+		if s.Selector != nil {
+			selector, _ := metav1.LabelSelectorAsSelector(s.Selector)
+			opts.LabelSelector = selector.String()
+		}
+
+		if s.Kind == "Pod" {
+			var pods []v1.Pod
+			if s.Name != "" {
+				pod, err := c.Client.CoreV1().Pods(r.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					return nil, err
+				}
+				if err == nil {
+					pods = append(pods, *pod)
+				}
+			} else {
+				list, err := c.listPods(ctx, r.Namespace, opts)
+				if err != nil {
+					return nil, err
+				}
+				pods = list.Items
+			}
+			for _, pod := range pods {
+				if podExcluded(pod) == "" {
+					nodeNames = append(nodeNames, pod.Spec.NodeName)
+				}
+			}
+		} else {
+			nodeNames = []string{s.Name}
+			if s.Name == "" {
+				list, err := c.GetNodes(ctx, Selector{Labels: opts.LabelSelector})
+				if err != nil {
+					return nil, err
+				}
+				nodeNames = nil
+				for _, node := range list.Items {
+					nodeNames = append(nodeNames, node.Name)
+				}
+			}
+		}
+
+		for _, name := range nodeNames {
+			node, err := c.getNode(ctx, name)
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if ip := nodeAddress(node, cfg.AddressTypes); ip != "" && nodeExcluded(*node) == "" {
+				seen[ip] = true
+			}
+		}
+	}
+
+	targets := make([]string, 0, len(seen))
+	for target := range seen {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
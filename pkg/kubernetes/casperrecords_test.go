@@ -0,0 +1,134 @@
+package kubernetes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	common "github.com/gathertown/casper-3/pkg"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func casperRecord(t *testing.T, name string, created time.Time, spec CasperRecordSpec) runtime.Object {
+	t.Helper()
+	r := &CasperRecord{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "casper-3.gather.town/v1alpha1", Kind: "CasperRecord"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: metav1.NewTime(created)},
+		Spec:       spec,
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+func TestCasperRecords(t *testing.T) {
+	c := setupClusterWithPods(t)
+	node, _ := c.Client.CoreV1().Nodes().Get(context.TODO(), mockNodeOpts.nodeName, metav1.GetOptions{})
+	node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	_, _ = c.Client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+	now := time.Now()
+	other := casperRecord(t, "other", now, CasperRecordSpec{Name: "other", Type: "A", Targets: []string{"10.0.0.4"}})
+	other.(*unstructured.Unstructured).SetNamespace("other")
+	dynamic := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		casperRecord(t, "static", now, CasperRecordSpec{Name: "turn", Type: "A", Targets: []string{"10.0.0.2", "10.0.0.1"}, TTL: 60}),
+		casperRecord(t, "nodes", now, CasperRecordSpec{Name: "sfu", Type: "A", Source: &CasperRecordSource{
+			Kind:     "Node",
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{mockNodeOpts.labelKey: mockNodeOpts.labelValue}},
+		}}),
+		casperRecord(t, "duplicate", now.Add(time.Minute), CasperRecordSpec{Name: "turn", Type: "A", Targets: []string{"10.0.0.3"}}),
+		casperRecord(t, "invalid", now, CasperRecordSpec{Name: "www", Type: "CNAME", Targets: []string{"a.example.com", "b.example.com"}}),
+		casperRecord(t, "empty", now, CasperRecordSpec{Name: "empty", Type: "A", Source: &CasperRecordSource{Kind: "Node", Name: "gone"}}),
+		casperRecord(t, "taken", now, CasperRecordSpec{Name: "router", Type: "A", Targets: []string{"10.0.0.5"}}),
+		other,
+	)
+	c.Dynamic = dynamic
+
+	// CasperRecords of other namespaces are not published
+	defer func(namespaces []string) { cfg.SyncRecordNamespaces = namespaces }(cfg.SyncRecordNamespaces)
+	cfg.SyncRecordNamespaces = []string{"default"}
+
+	claims := Claims{"router": "service/default/router"}
+	records, err := c.CasperRecords(context.TODO(), claims)
+	if err != nil {
+		t.Fatalf("CasperRecords() returned error: %v", err)
+	}
+
+	want := []RecordSet{
+		{Name: "sfu", Type: "A", Targets: []string{mockNodeOpts.externalIP}, Owner: "record/default/nodes"},
+		{Name: "turn", Type: "A", Targets: []string{"10.0.0.1", "10.0.0.2"}, Owner: "record/default/static", TTL: 60},
+	}
+	got := RecordSets(records)
+	if len(got) == 2 && got[0].Name != "sfu" {
+		got[0], got[1] = got[1], got[0]
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting record sets %+v, got %+v", want, got)
+	}
+
+	if err := c.UpdateCasperRecordStatus(context.TODO(), records, &common.SyncError{Records: []string{"sfu"}}); err != nil {
+		t.Fatalf("UpdateCasperRecordStatus() returned error: %v", err)
+	}
+
+	if len(records) != 6 {
+		t.Errorf("Expecting 6 records, got %d", len(records))
+	}
+	if claims["turn"] != "record/default/static" {
+		t.Errorf("Expecting turn to be claimed by record/default/static, got %q", claims["turn"])
+	}
+
+	reasons := map[string]string{
+		"static":    ReasonSynced,
+		"nodes":     ReasonSyncError,
+		"duplicate": ReasonConflict,
+		"invalid":   ReasonInvalid,
+		"empty":     ReasonNoTargets,
+		"taken":     ReasonConflict,
+	}
+	for name, reason := range reasons {
+		u, err := c.Dynamic.Resource(CasperRecordResource).Namespace("default").Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get(%s) returned error: %v", name, err)
+		}
+		r := &CasperRecord{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), r); err != nil {
+			t.Fatal(err)
+		}
+		ready := meta.FindStatusCondition(r.Status.Conditions, ConditionReady)
+		if ready == nil || ready.Reason != reason {
+			t.Errorf("Expecting %s to be %s, got %+v", name, reason, ready)
+		}
+		if (reason == ReasonSynced) != meta.IsStatusConditionTrue(r.Status.Conditions, ConditionReady) {
+			t.Errorf("Expecting %s ready to be %v", name, reason == ReasonSynced)
+		}
+	}
+}
+
+func TestUpdateCasperRecordStatusUnchanged(t *testing.T) {
+	c := setupClusterWithPods(t)
+	dynamic := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		casperRecord(t, "static", time.Now(), CasperRecordSpec{Name: "turn", Type: "A", Targets: []string{"10.0.0.1"}}),
+	)
+	c.Dynamic = dynamic
+
+	for i, updates := range []int{1, 0} {
+		records, err := c.CasperRecords(context.TODO(), Claims{})
+		if err != nil {
+			t.Fatalf("CasperRecords() returned error: %v", err)
+		}
+		dynamic.ClearActions()
+		if err := c.UpdateCasperRecordStatus(context.TODO(), records, nil); err != nil {
+			t.Fatalf("UpdateCasperRecordStatus() returned error: %v", err)
+		}
+		if got := len(dynamic.Actions()); got != updates {
+			t.Errorf("Expecting %d status updates in reconcile %d, got %d", updates, i+1, got)
+		}
+	}
+}
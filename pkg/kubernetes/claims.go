@@ -0,0 +1,49 @@
+package kubernetes
+
+import "fmt"
+
+// Claims maps the record names published under the configured subdomain to
+// their owner, e.g. "node/sfu-8mh0d". A name is published for its first
+// claimant only.
+type Claims map[string]string
+
+// NewClaims returns the claims of the records of nodes and pods published
+// under the configured subdomain.
+func NewClaims(nodes []Node, pods []Pod) Claims {
+	c := make(Claims)
+	for _, node := range nodes {
+		if node.SubdomainOr(cfg.Subdomain) == cfg.Subdomain {
+			c.Claim(node.Hostname, fmt.Sprintf("node/%s", node.Name))
+		}
+	}
+	for _, pod := range pods {
+		if pod.SubdomainOr(cfg.Subdomain) == cfg.Subdomain {
+			c.Claim(pod.Hostname, fmt.Sprintf("pod/%s/%s", pod.Namespace, pod.Name))
+		}
+	}
+	return c
+}
+
+// Claim claims name for owner. It returns the owner of name and whether it is
+// owner.
+func (c Claims) Claim(name string, owner string) (string, bool) {
+	if claimant, found := c[name]; found {
+		return claimant, claimant == owner
+	}
+	c[name] = owner
+	return owner, true
+}
+
+// Filter claims the names of sets, in order, and returns the sets whose name
+// was not claimed by another owner.
+func (c Claims) Filter(sets []RecordSet) []RecordSet {
+	var filtered []RecordSet
+	for _, set := range sets {
+		if claimant, ok := c.Claim(set.Name, set.Owner); !ok {
+			logger.Warn("Record name is already published, skipping", "record", set.Name, "owner", set.Owner, "conflict", claimant)
+			continue
+		}
+		filtered = append(filtered, set)
+	}
+	return filtered
+}
@@ -0,0 +1,36 @@
+package kubernetes
+
+import (
+	"reflect"
+	"testing"
+
+	common "github.com/gathertown/casper-3/pkg"
+)
+
+func TestClaims(t *testing.T) {
+	nodes := []Node{
+		{Name: "sfu-8mh0d", RecordOptions: common.RecordOptions{Hostname: "sfu-8mh0d"}},
+		{Name: "sfu-8quob", RecordOptions: common.RecordOptions{Hostname: "router", Subdomain: "other"}},
+	}
+	pods := []Pod{
+		{Name: "engine-0", Namespace: "default", RecordOptions: common.RecordOptions{Hostname: "engine"}},
+	}
+	claims := NewClaims(nodes, pods)
+
+	sets := []RecordSet{
+		{Name: "sfu-8mh0d", Owner: "service/default/sfu-8mh0d"},
+		{Name: "engine", Owner: "service/default/engine"},
+		{Name: "router", Owner: "service/default/router"},
+		{Name: "router", Owner: "service/prod/router"},
+	}
+	got := claims.Filter(sets)
+	want := []RecordSet{{Name: "router", Owner: "service/default/router"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %v, got %v", want, got)
+	}
+
+	// A name claimed again by its owner is kept.
+	if got := claims.Filter(want); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %v, got %v", want, got)
+	}
+}
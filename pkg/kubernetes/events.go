@@ -0,0 +1,145 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	common "github.com/gathertown/casper-3/pkg"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+)
+
+// Reasons of the Events recorded for record operations.
+const (
+	ReasonCreated = "DNSRecordCreated"
+	ReasonUpdated = "DNSRecordUpdated"
+	ReasonDeleted = "DNSRecordDeleted"
+	ReasonFailed  = "DNSRecordFailed"
+	ReasonBlocked = "DNSSyncBlocked"
+)
+
+// Events records Kubernetes Events against the nodes and pods record
+// operations were made for, so that they show in `kubectl describe`.
+type Events struct {
+	Client   kubernetes.Interface
+	Recorder record.EventRecorder
+}
+
+// eventSource is the source of the Events of casper-3.
+var eventSource = v1.EventSource{Component: "casper-3"}
+
+// NewEvents returns Events sent to the API server through a broadcaster, and
+// a function shutting the broadcaster down. The broadcaster drops the Events
+// still queued on shutdown, so with synchronous set every Event is written
+// before Record returns instead, e.g. for a single reconcile.
+func NewEvents(client kubernetes.Interface, synchronous bool) (*Events, func()) {
+	if synchronous {
+		return &Events{Client: client, Recorder: &syncRecorder{client: client}}, func() {}
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, eventSource)
+	return &Events{Client: client, Recorder: recorder}, broadcaster.Shutdown
+}
+
+// Record records an Event per node and pod change. Changes of objects that
+// are gone, or of record sets, are skipped.
+func (e *Events) Record(ctx context.Context, changes []common.Change) {
+	for _, change := range changes {
+		if change.Kind != "node" && change.Kind != "pod" {
+			continue
+		}
+		object, err := e.object(ctx, change)
+		if err != nil {
+			logger.Debug("Object of change not found", "kind", change.Kind, "namespace", change.Namespace, "name", change.Name, "error", err.Error())
+			continue
+		}
+
+		switch {
+		case change.Action == "blocked":
+			e.Recorder.Eventf(object, v1.EventTypeWarning, ReasonBlocked, "Record %s not synced: %v", change.FQDN, change.Err)
+		case change.Err != nil:
+			e.Recorder.Eventf(object, v1.EventTypeWarning, ReasonFailed, "Failed to %s %s record %s: %v", change.Action, change.Type, change.FQDN, change.Err)
+		case change.Action == "create":
+			e.Recorder.Eventf(object, v1.EventTypeNormal, ReasonCreated, "Created %s record %s pointing to %s", change.Type, change.FQDN, change.Content)
+		case change.Action == "update":
+			e.Recorder.Eventf(object, v1.EventTypeNormal, ReasonUpdated, "Updated %s record %s", change.Type, change.FQDN)
+		case change.Action == "delete":
+			e.Recorder.Eventf(object, v1.EventTypeNormal, ReasonDeleted, "Deleted %s record %s", change.Type, change.FQDN)
+		}
+	}
+}
+
+// syncRecorder is a record.EventRecorder creating every Event right away.
+// Unlike the broadcaster it does not aggregate repeated Events.
+type syncRecorder struct {
+	client kubernetes.Interface
+}
+
+func (r *syncRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	ref, err := reference.GetReference(scheme.Scheme, object)
+	if err != nil {
+		logger.Error("Error occured while referencing object of event", "reason", reason, "error", err.Error())
+		return
+	}
+	// Events of cluster scoped objects, e.g. nodes, go to the default namespace
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()), Namespace: namespace},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        message,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventtype,
+		Source:         eventSource,
+	}
+	if _, err := r.client.CoreV1().Events(namespace).Create(context.TODO(), event, metav1.CreateOptions{}); err != nil {
+		logger.Error("Error occured while creating event", "kind", ref.Kind, "namespace", ref.Namespace, "name", ref.Name, "reason", reason, "error", err.Error())
+	}
+}
+
+func (r *syncRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *syncRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// object returns the node or pod of a change. Pods deleted by a sync are only
+// known by name, they are looked up in all namespaces.
+func (e *Events) object(ctx context.Context, change common.Change) (runtime.Object, error) {
+	if change.Kind == "node" {
+		return e.Client.CoreV1().Nodes().Get(ctx, change.Name, metav1.GetOptions{})
+	}
+	if change.Namespace != "" {
+		return e.Client.CoreV1().Pods(change.Namespace).Get(ctx, change.Name, metav1.GetOptions{})
+	}
+	opts := metav1.ListOptions{FieldSelector: "metadata.name=" + change.Name}
+	p, err := e.Client.CoreV1().Pods("").List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	var found []*v1.Pod
+	for i := range p.Items {
+		if p.Items[i].Name == change.Name {
+			found = append(found, &p.Items[i])
+		}
+	}
+	if len(found) != 1 {
+		return nil, fmt.Errorf("%d pods named %s", len(found), change.Name)
+	}
+	return found[0], nil
+}
@@ -0,0 +1,67 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	common "github.com/gathertown/casper-3/pkg"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEventsRecord(t *testing.T) {
+	c := setupClusterWithPods(t)
+	recorder := record.NewFakeRecorder(10)
+	e := &Events{Client: c.Client, Recorder: recorder}
+
+	changes := []common.Change{
+		{Action: "create", Kind: "node", Name: mockNodeOpts.nodeName, FQDN: "sfu-8mh0d.dev.k8s.gather.town", Type: "A", Content: mockNodeOpts.externalIP},
+		{Action: "create", Kind: "pod", Name: "router-0", FQDN: "router-0.dev.k8s.gather.town", Type: "A", Err: errors.New("rate limited")},
+		{Action: "delete", Kind: "pod", Name: "router-1", FQDN: "router-1.dev.k8s.gather.town", Type: "A"},
+		{Action: "blocked", Kind: "pod", Name: "router-0", FQDN: "router-0.dev.k8s.gather.town", Type: "A", Err: common.ErrNoAddress},
+		{Action: "delete", Kind: "pod", Name: "gone", FQDN: "gone.dev.k8s.gather.town", Type: "A"},
+		{Action: "create", Kind: "service", Name: "default/turn", FQDN: "turn.dev.k8s.gather.town", Type: "A"},
+	}
+	e.Record(context.TODO(), changes)
+	close(recorder.Events)
+
+	var got []string
+	for event := range recorder.Events {
+		got = append(got, event)
+	}
+	want := []string{
+		"Normal DNSRecordCreated Created A record sfu-8mh0d.dev.k8s.gather.town pointing to " + mockNodeOpts.externalIP,
+		"Warning DNSRecordFailed Failed to create A record router-0.dev.k8s.gather.town: rate limited",
+		"Normal DNSRecordDeleted Deleted A record router-1.dev.k8s.gather.town",
+		"Warning DNSSyncBlocked Record router-0.dev.k8s.gather.town not synced: no IP address found",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting events %q, got %q", want, got)
+	}
+}
+
+func TestEventsRecordSynchronous(t *testing.T) {
+	c := setupClusterWithPods(t)
+	e, stop := NewEvents(c.Client, true)
+
+	changes := []common.Change{
+		{Action: "create", Kind: "node", Name: mockNodeOpts.nodeName, FQDN: "sfu-8mh0d.dev.k8s.gather.town", Type: "A", Content: mockNodeOpts.externalIP},
+	}
+	e.Record(context.TODO(), changes)
+	stop()
+
+	list, err := c.Client.CoreV1().Events(metav1.NamespaceDefault).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("List() returned error: %v", err)
+	}
+	if len(list.Items) != 1 {
+		t.Fatalf("Expecting 1 event, got %d", len(list.Items))
+	}
+	event := list.Items[0]
+	if event.InvolvedObject.Kind != "Node" || event.InvolvedObject.Name != mockNodeOpts.nodeName || event.Type != v1.EventTypeNormal || event.Reason != ReasonCreated {
+		t.Errorf("Expecting a %s event of node %s, got %+v", ReasonCreated, mockNodeOpts.nodeName, event)
+	}
+}
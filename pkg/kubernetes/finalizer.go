@@ -0,0 +1,133 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gathertown/casper-3/internal/metrics"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodFinalizer keeps a pod from being removed before its records are deleted.
+const PodFinalizer = "casper-3.gather.town/dns-records"
+
+// FinalizePods adds PodFinalizer to the eligible pods of PodSelector, and
+// releases it from those that are terminating. With a cleanup, the finalizer
+// is released once cleanup removed the records of the pod, or regardless when
+// timeout has elapsed after the pod's deletion timestamp, so that a failing
+// provider cannot block pod deletion forever. Without one, it is released
+// right away.
+func (c *Cluster) FinalizePods(ctx context.Context, timeout time.Duration, cleanup func(context.Context, Pod) error) error {
+	var failed bool
+
+	p, err := c.GetPods(ctx, PodSelector())
+	if err != nil {
+		return err
+	}
+	for i := range p.Items {
+		pod := &p.Items[i]
+		if pod.DeletionTimestamp != nil {
+			if hasFinalizer(pod) && !c.release(ctx, pod, timeout, cleanup) {
+				failed = true
+			}
+			continue
+		}
+		if hasFinalizer(pod) || podExcluded(*pod) != "" {
+			continue
+		}
+		name := pod.Namespace + "/" + pod.Name
+		pod.Finalizers = append(pod.Finalizers, PodFinalizer)
+		if err := c.updatePod(ctx, pod); err != nil {
+			failed = true
+			logger.Error("Error occured while adding finalizer", "pod", name, "error", err.Error())
+			continue
+		}
+		logger.Debug("Added finalizer", "pod", name)
+	}
+
+	if failed {
+		return errFinalizer
+	}
+	return nil
+}
+
+// ReleasePods releases PodFinalizer from the terminating pods of all
+// namespaces like FinalizePods, including those that no longer match the
+// selector or were finalized before the finalizer or the pod sync was
+// disabled. It lists all pods of the cluster, so it is meant to run once.
+func (c *Cluster) ReleasePods(ctx context.Context, timeout time.Duration, cleanup func(context.Context, Pod) error) error {
+	var failed bool
+
+	p, err := c.listPods(ctx, metav1.NamespaceAll, metav1.ListOptions{})
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+	for i := range p.Items {
+		pod := &p.Items[i]
+		if pod.DeletionTimestamp == nil || !hasFinalizer(pod) {
+			continue
+		}
+		if !c.release(ctx, pod, timeout, cleanup) {
+			failed = true
+		}
+	}
+
+	if failed {
+		return errFinalizer
+	}
+	return nil
+}
+
+// release removes PodFinalizer from the terminating pod once cleanup succeeded
+// or timed out. It reports whether the pod was handled without error.
+func (c *Cluster) release(ctx context.Context, pod *v1.Pod, timeout time.Duration, cleanup func(context.Context, Pod) error) bool {
+	name := pod.Namespace + "/" + pod.Name
+
+	if cleanup != nil {
+		if expired := time.Since(pod.DeletionTimestamp.Time) > timeout; expired {
+			logger.Error("Releasing finalizer without cleanup, timeout exceeded", "pod", name, "timeout", timeout.String())
+		} else if err := cleanup(ctx, newPod(*pod, "")); err != nil {
+			logger.Error("Error occured while deleting records of terminating pod", "pod", name, "error", err.Error())
+			return false
+		}
+	}
+	removeFinalizer(pod)
+	if err := c.updatePod(ctx, pod); err != nil {
+		logger.Error("Error occured while releasing finalizer", "pod", name, "error", err.Error())
+		return false
+	}
+	logger.Info("Released finalizer", "pod", name)
+	return true
+}
+
+var errFinalizer = errors.New("one or more pod finalizers could not be handled")
+
+func (c *Cluster) updatePod(ctx context.Context, pod *v1.Pod) error {
+	_, err := c.Client.CoreV1().Pods(pod.Namespace).Update(ctx, pod, metav1.UpdateOptions{})
+	if err != nil {
+		metrics.ExecErrInc(err)
+	}
+	return err
+}
+
+func hasFinalizer(pod *v1.Pod) bool {
+	for _, f := range pod.Finalizers {
+		if f == PodFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFinalizer(pod *v1.Pod) {
+	var finalizers []string
+	for _, f := range pod.Finalizers {
+		if f != PodFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	pod.Finalizers = finalizers
+}
@@ -0,0 +1,94 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFinalizePods(t *testing.T) {
+	c := setupClusterWithPods(t)
+	labels := map[string]string{cfg.SyncPodLabelKey: cfg.SyncPodLabelValue}
+	ready := []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+	deleted := metav1.NewTime(time.Now().Add(-time.Minute))
+	expired := metav1.NewTime(time.Now().Add(-time.Hour))
+
+	pods := []*v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-running", Namespace: "default", Labels: labels}, Spec: v1.PodSpec{NodeName: mockNodeOpts.nodeName}, Status: v1.PodStatus{Phase: v1.PodRunning, Conditions: ready}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-deleted", Namespace: "default", Labels: labels, DeletionTimestamp: &deleted, Finalizers: []string{PodFinalizer}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-failing", Namespace: "default", Labels: labels, DeletionTimestamp: &deleted, Finalizers: []string{"other", PodFinalizer}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-expired", Namespace: "default", Labels: labels, DeletionTimestamp: &expired, Finalizers: []string{PodFinalizer}}},
+		// Not listed, it is left to ReleasePods
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-unlabelled", Namespace: "default", DeletionTimestamp: &deleted, Finalizers: []string{PodFinalizer}}},
+	}
+	for _, pod := range pods {
+		_, _ = c.Client.CoreV1().Pods("default").Create(context.TODO(), pod, metav1.CreateOptions{})
+	}
+
+	var cleaned []string
+	cleanup := func(ctx context.Context, pod Pod) error {
+		if pod.Name == "sfu-failing" {
+			return errors.New("provider unavailable")
+		}
+		cleaned = append(cleaned, pod.Name)
+		return nil
+	}
+
+	if err := c.FinalizePods(context.TODO(), 5*time.Minute, cleanup); err == nil {
+		t.Errorf("Expecting an error for the failing cleanup")
+	}
+
+	if len(cleaned) != 1 || cleaned[0] != "sfu-deleted" {
+		t.Errorf("Expecting the records of sfu-deleted to be cleaned up, got %v", cleaned)
+	}
+
+	want := map[string]bool{
+		"sfu-running":    true,  // eligible, finalizer added
+		"sfu-deleted":    false, // cleaned up, finalizer released
+		"sfu-failing":    true,  // cleanup failed, finalizer kept
+		"sfu-expired":    false, // timeout exceeded, finalizer released
+		"sfu-unlabelled": true,  // not matching the selector, finalizer kept
+	}
+	for name, finalizer := range want {
+		pod, err := c.Client.CoreV1().Pods("default").Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get(%s) returned error: %v", name, err)
+		}
+		if got := hasFinalizer(pod); got != finalizer {
+			t.Errorf("Expecting finalizer on %s to be %v, got %v", name, finalizer, got)
+		}
+	}
+}
+
+func TestReleasePods(t *testing.T) {
+	c := setupClusterWithPods(t)
+	labels := map[string]string{cfg.SyncPodLabelKey: cfg.SyncPodLabelValue}
+	deleted := metav1.NewTime(time.Now().Add(-time.Minute))
+
+	pods := []*v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-deleted", Namespace: "default", Labels: labels, DeletionTimestamp: &deleted, Finalizers: []string{PodFinalizer}}},
+		// The label was removed after the finalizer was added
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-unlabelled", Namespace: "other", DeletionTimestamp: &deleted, Finalizers: []string{PodFinalizer}}},
+	}
+	for _, pod := range pods {
+		_, _ = c.Client.CoreV1().Pods(pod.Namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+	}
+
+	if err := c.ReleasePods(context.TODO(), 5*time.Minute, nil); err != nil {
+		t.Errorf("Expecting no error, got %v", err)
+	}
+
+	for _, p := range pods {
+		pod, err := c.Client.CoreV1().Pods(p.Namespace).Get(context.TODO(), p.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get(%s) returned error: %v", p.Name, err)
+		}
+		if hasFinalizer(pod) {
+			t.Errorf("Expecting the finalizer of %s to be released", p.Name)
+		}
+	}
+}
@@ -3,28 +3,70 @@
 package kubernetes
 
 import (
+	"net/http"
+	"path/filepath"
+	"strings"
+
 	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-// Cluster API struct for a kubernetes clusters
+// Cluster API struct for a kubernetes clusters. It lists the nodes once and
+// resolves nodes from that listing from then on, so a Cluster is meant to be
+// used for a single reconcile.
 type Cluster struct {
-	Client kubernetes.Interface
+	Client  kubernetes.Interface
+	Dynamic dynamic.Interface // custom resources, e.g. CasperRecords
+
+	nodes  map[string]*v1.Node           // all nodes by name, listed once, see allNodes
+	listed map[string]CasperRecordStatus // CasperRecord statuses by namespace/name as listed, see CasperRecords
 }
 
-// New creates a new in-cluster kubernetes client
-func New() (*Cluster, error) {
-	config, err := rest.InClusterConfig()
+// New creates a new kubernetes client. When neither a kubeconfig nor a context
+// is given, the in-cluster configuration is used.
+func New(kubeconfig string, kubeContext string) (*Cluster, error) {
+	config, err := restConfig(kubeconfig, kubeContext)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return nil, err
 	}
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return metrics.InstrumentRoundTripper("kubernetes", tracing.Transport("kubernetes", rt))
+	}
 	// creates the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		metrics.ExecErrInc(err)
 		return nil, err
 	}
-	return &Cluster{Client: clientset}, nil
+	return &Cluster{Client: clientset, Dynamic: dynamicClient}, nil
+}
+
+// restConfig loads the client configuration from the given kubeconfig, which
+// may be a list of files like $KUBECONFIG, falling back to the in-cluster
+// configuration when running inside a pod.
+func restConfig(kubeconfig string, kubeContext string) (*rest.Config, error) {
+	if kubeconfig == "" && kubeContext == "" {
+		return rest.InClusterConfig()
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if strings.ContainsRune(kubeconfig, filepath.ListSeparator) {
+		rules.Precedence = filepath.SplitList(kubeconfig)
+	} else {
+		rules.ExplicitPath = kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
 }
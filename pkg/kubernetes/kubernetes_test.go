@@ -0,0 +1,81 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: casper
+- name: prod
+  context:
+    cluster: prod
+    user: casper
+current-context: dev
+users:
+- name: casper
+  user:
+    token: abcd123
+`
+
+func writeKubeconfig(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "casper-3")
+	if err != nil {
+		t.Fatalf("Failed creating temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatalf("Failed writing kubeconfig: %v", err)
+	}
+	return path
+}
+
+func TestRestConfig(t *testing.T) {
+	path := writeKubeconfig(t)
+
+	tests := []struct {
+		name        string
+		kubeconfig  string
+		kubeContext string
+		want        string
+	}{
+		{"current context", path, "", "https://dev.example.com"},
+		{"explicit context", path, "prod", "https://prod.example.com"},
+		{"kubeconfig list", path + string(filepath.ListSeparator) + path, "prod", "https://prod.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := restConfig(tt.kubeconfig, tt.kubeContext)
+			if err != nil {
+				t.Fatalf("restConfig(%q, %q) returned error: %v", tt.kubeconfig, tt.kubeContext, err)
+			}
+			if c.Host != tt.want {
+				t.Errorf("restConfig(%q, %q) host = %q; want %q", tt.kubeconfig, tt.kubeContext, c.Host, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestConfigUnknownContext(t *testing.T) {
+	path := writeKubeconfig(t)
+	if _, err := restConfig(path, "staging"); err == nil {
+		t.Errorf("Expecting error for unknown context, got none")
+	}
+}
@@ -2,75 +2,160 @@ package kubernetes
 
 import (
 	"context"
-	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/gathertown/casper-3/internal/config"
 	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
 	common "github.com/gathertown/casper-3/pkg"
 	"github.com/gathertown/casper-3/pkg/log"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type Node = common.Node
 
 var cfg = config.FromEnv()
-var logger = log.New(os.Stdout, cfg.LogLevel)
+var logger = log.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+
+// Returns []Node struct listing name, IPv4 address and record options
+func (c *Cluster) Nodes(ctx context.Context) (_ []Node, err error) {
+	ctx, span := tracing.Start(ctx, "kubernetes.Nodes")
+	defer func() { tracing.End(span, err) }()
 
-// Returns []Node struct listing hostname and IPv4 address
-func (c *Cluster) Nodes() ([]Node, error) {
 	var nodes []Node
 
-	n, err := c.GetNodes(cfg.LabelKey, cfg.LabelValues)
+	n, err := c.GetNodes(ctx, NodeSelector())
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return nil, err
 	}
 
 	for _, node := range n.Items {
-		foundIP := false
-		for _, addr := range node.Status.Addresses {
-			if addr.Type != "ExternalIP" {
-				// if `ExternalIP` not found hop to the next iteration
-				continue
-			}
-			nodeName := strings.Split(node.Name, ".")[0]
-			logger.Debug("IPv4 address found", "node", nodeName, "IPv4", addr.Address)
-			nodes = append(nodes, Node{nodeName, addr.Address})
-			foundIP = true
-			break
+		if reason := nodeExcluded(node); reason != "" {
+			logger.Info("Node not eligible for publishing", "node", node.Name, "reason", reason)
+			continue
 		}
-		if !foundIP {
-			logger.Info("No IPv4 address found", "node", node.Name)
+		address := nodeAddress(&node, cfg.AddressTypes)
+		if address == "" {
+			logger.Info("No IPv4 address found", "node", node.Name, "addressTypes", cfg.AddressTypes)
+			continue
 		}
+		opts := recordOptions("node/"+node.Name, strings.Split(node.Name, ".")[0], node.Annotations)
+		logger.Debug("IPv4 address found", "node", node.Name, "hostname", opts.Hostname, "IPv4", address)
+		nodes = append(nodes, Node{Name: node.Name, ExternalIP: address, Pool: node.Labels[cfg.LabelKey], RecordOptions: opts})
 	}
 
 	return nodes, nil
 }
 
-// GetNodes returns the list of cluster nodes
-func (c *Cluster) GetNodes(labelKey string, labelValues string) (*v1.NodeList, error) {
-	labelSelector := fmt.Sprintf("%s in (%s)", labelKey, labelValues)
-	opts := metav1.ListOptions{
-		LabelSelector: labelSelector,
-		Limit:         300,
+// nodeExcluded returns why a node must not be published, or an empty string
+// if it may be. A NotReady, cordoned or tainted node, e.g. one being drained
+// or scaled down, is excluded so that its record is withdrawn.
+func nodeExcluded(node v1.Node) string {
+	if requireReady, _ := strconv.ParseBool(cfg.NodeRequireReady); requireReady && !nodeReady(node) {
+		return "NotReady"
+	}
+	if excludeUnschedulable, _ := strconv.ParseBool(cfg.NodeExcludeUnschedulable); excludeUnschedulable && node.Spec.Unschedulable {
+		return "Unschedulable"
+	}
+	for _, taint := range node.Spec.Taints {
+		for _, t := range cfg.NodeExcludeTaints {
+			if taintMatches(taint, t) {
+				return "Taint " + taint.ToString()
+			}
+		}
+	}
+	return ""
+}
+
+func nodeReady(node v1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == v1.NodeReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// taintMatches reports whether taint matches t, given as key[=value][:effect].
+func taintMatches(taint v1.Taint, t string) bool {
+	if i := strings.LastIndex(t, ":"); i >= 0 {
+		if v1.TaintEffect(t[i+1:]) != taint.Effect {
+			return false
+		}
+		t = t[:i]
+	}
+	if i := strings.Index(t, "="); i >= 0 {
+		return t[:i] == taint.Key && t[i+1:] == taint.Value
+	}
+	return t == taint.Key
+}
+
+// GetNodes returns the list of cluster nodes matching s
+func (c *Cluster) GetNodes(ctx context.Context, s Selector) (*v1.NodeList, error) {
+	labelSelector, fieldSelector, err := s.nodeSelectors()
+	if err != nil {
+		return nil, err
 	}
-	n, err := c.Client.CoreV1().Nodes().List(context.TODO(), opts)
+	all, err := c.allNodes(ctx)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
 		return nil, err
 	}
+
+	n := &v1.NodeList{}
+	for _, node := range all {
+		if labelSelector.Matches(labels.Set(node.Labels)) && fieldSelector.Matches(nodeFields(node)) {
+			n.Items = append(n.Items, *node)
+		}
+	}
+	sort.Slice(n.Items, func(i, j int) bool { return n.Items[i].Name < n.Items[j].Name })
 	return n, nil
 }
 
-func (c *Cluster) getExternalIpByNodeName(nodeName string) (string, error) {
-	n, err := c.Client.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+// allNodes returns all nodes of the cluster by name. They are listed on the
+// first call only, so that the nodes of pods, services and records are
+// resolved without further API calls. A Cluster is created per reconcile.
+func (c *Cluster) allNodes(ctx context.Context) (map[string]*v1.Node, error) {
+	if c.nodes != nil {
+		return c.nodes, nil
+	}
+	n, err := c.listNodes(ctx, metav1.ListOptions{})
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return nil, err
+	}
+	c.nodes = make(map[string]*v1.Node, len(n.Items))
+	for i := range n.Items {
+		c.nodes[n.Items[i].Name] = &n.Items[i]
+	}
+	return c.nodes, nil
+}
+
+// getNode returns the node with the given name, or a NotFound error.
+func (c *Cluster) getNode(ctx context.Context, nodeName string) (*v1.Node, error) {
+	all, err := c.allNodes(ctx)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		return "", err
+		return nil, err
+	}
+	n, found := all[nodeName]
+	if !found {
+		return nil, apierrors.NewNotFound(v1.Resource("nodes"), nodeName)
+	}
+	return n, nil
+}
+
+// nodeFields returns the fields of a node that field selectors of nodes
+// support.
+func nodeFields(node *v1.Node) fields.Set {
+	return fields.Set{
+		"metadata.name":      node.Name,
+		"spec.unschedulable": strconv.FormatBool(node.Spec.Unschedulable),
 	}
-	ip := n.Status.Addresses[2].Address
-	return ip, nil
 }
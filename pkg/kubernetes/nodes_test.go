@@ -64,6 +64,7 @@ func setupCluster(t *testing.T) Cluster {
 				{Type: v1.NodeInternalIP, Address: tt.internalIP},
 				{Type: v1.NodeExternalIP, Address: tt.externalIP},
 			},
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
 		}
 
 		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: tt.nodeName, ClusterName: tt.clusterName, Labels: labels}, Status: nodeStatus}
@@ -101,7 +102,7 @@ func TestGetNodes(t *testing.T) {
 	c := setupCluster(t)
 	cfg := config.FromEnv()
 	nodes := 3
-	n, _ := c.GetNodes(cfg.LabelKey, cfg.LabelValues)
+	n, _ := c.GetNodes(context.TODO(), Selector{Labels: cfg.LabelKey + " in (" + cfg.LabelValues + ")"})
 
 	// test number of nodes with label
 	if len(n.Items) != nodes {
@@ -123,7 +124,7 @@ func TestGetNodes(t *testing.T) {
 func TestNoIPv4(t *testing.T) {
 	setenv(t, "LABEL_VALUES", "sfu")
 	c := setupClusterNoIPv4(t)
-	p, _ := c.Nodes()
+	p, _ := c.Nodes(context.TODO())
 	if len(p) > 0 {
 		t.Errorf("Found node with IPv4 address!")
 	}
@@ -134,7 +135,7 @@ func TestGetExternalIpByNode(t *testing.T) {
 	c := setupCluster(t)
 	cfg := config.FromEnv()
 	nodes := 2
-	n, _ := c.GetNodes(cfg.LabelKey, cfg.LabelValues)
+	n, _ := c.GetNodes(context.TODO(), Selector{Labels: cfg.LabelKey + " in (" + cfg.LabelValues + ")"})
 
 	// test number of nodes with label
 	if len(n.Items) != nodes {
@@ -145,9 +146,92 @@ func TestGetExternalIpByNode(t *testing.T) {
 
 	// fetch pod names
 	for _, node := range n.Items {
-		actualExternalIPAddress, _ := c.getExternalIpByNodeName(node.Name)
+		n, _ := c.getNode(context.TODO(), node.Name)
+		actualExternalIPAddress := nodeAddress(n, []string{AddressExternalIP})
 		if !contains(expectedExternalIPAddressList, actualExternalIPAddress) {
 			t.Errorf("Expecting one of the following IP Addresses(s) %v, got %v IP Address", expectedExternalIPAddressList, actualExternalIPAddress)
 		}
 	}
 }
+
+func TestNodesExcluded(t *testing.T) {
+	ready := []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	notReady := []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}
+	autoscaler := v1.Taint{Key: "ToBeDeletedByClusterAutoscaler", Value: "1617000000", Effect: v1.TaintEffectNoSchedule}
+	other := v1.Taint{Key: "dedicated", Value: "sfu", Effect: v1.TaintEffectNoSchedule}
+
+	tests := []struct {
+		name          string
+		conditions    []v1.NodeCondition
+		unschedulable bool
+		taints        []v1.Taint
+		excluded      bool
+	}{
+		{"sfu-ready", ready, false, nil, false},
+		{"sfu-notready", notReady, false, nil, true},
+		{"sfu-unknown", nil, false, nil, true},
+		{"sfu-cordoned", ready, true, nil, true},
+		{"sfu-scaledown", ready, false, []v1.Taint{autoscaler}, true},
+		{"sfu-dedicated", ready, false, []v1.Taint{other}, false},
+	}
+
+	for _, tt := range tests {
+		node := v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: tt.name},
+			Spec:       v1.NodeSpec{Unschedulable: tt.unschedulable, Taints: tt.taints},
+			Status:     v1.NodeStatus{Conditions: tt.conditions},
+		}
+		if got := nodeExcluded(node) != ""; got != tt.excluded {
+			t.Errorf("nodeExcluded(%s) = %v; want %v", tt.name, got, tt.excluded)
+		}
+	}
+}
+
+func TestTaintMatches(t *testing.T) {
+	taint := v1.Taint{Key: "dedicated", Value: "sfu", Effect: v1.TaintEffectNoExecute}
+
+	tests := []struct {
+		spec string
+		want bool
+	}{
+		{"dedicated", true},
+		{"dedicated=sfu", true},
+		{"dedicated=router", false},
+		{"dedicated:NoExecute", true},
+		{"dedicated=sfu:NoSchedule", false},
+		{"other", false},
+	}
+	for _, tt := range tests {
+		if got := taintMatches(taint, tt.spec); got != tt.want {
+			t.Errorf("taintMatches(%q) = %v; want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestNodes(t *testing.T) {
+	c := setupCluster(t)
+	nodes, err := c.Nodes(context.TODO())
+	if err != nil {
+		t.Fatalf("Nodes() returned error: %v", err)
+	}
+	if len(nodes) != 2 {
+		t.Errorf("Expecting 2 nodes, got %v", nodes)
+	}
+
+	// A cordoned node is withdrawn
+	n, _ := c.Client.CoreV1().Nodes().Get(context.TODO(), "sfu-8mh0d", metav1.GetOptions{})
+	n.Spec.Unschedulable = true
+	_, _ = c.Client.CoreV1().Nodes().Update(context.TODO(), n, metav1.UpdateOptions{})
+
+	// Nodes are listed once per Cluster, the next reconcile has a new one.
+	c = Cluster{Client: c.Client}
+	nodes, _ = c.Nodes(context.TODO())
+	for _, node := range nodes {
+		if node.Name == "sfu-8mh0d" {
+			t.Errorf("Expecting cordoned node sfu-8mh0d to be excluded")
+		}
+	}
+	if len(nodes) != 1 {
+		t.Errorf("Expecting 1 node, got %v", nodes)
+	}
+}
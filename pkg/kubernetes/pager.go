@@ -0,0 +1,88 @@
+package kubernetes
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/pager"
+)
+
+// pageSize is the number of objects requested per list call. The list
+// functions below follow the continue token until the last page, so that a
+// partial list never makes the records of the missing objects look stale. An
+// expired continue token fails the list as a whole.
+const pageSize = 300
+
+// eachListItem calls fn with every object listed by list, one page at a time.
+func eachListItem(ctx context.Context, opts metav1.ListOptions, list func(metav1.ListOptions) (runtime.Object, error), fn func(runtime.Object) error) error {
+	p := pager.New(pager.SimplePageFunc(list))
+	p.PageSize = pageSize
+	return p.EachListItem(ctx, opts, fn)
+}
+
+// listNodes returns all nodes matching opts.
+func (c *Cluster) listNodes(ctx context.Context, opts metav1.ListOptions) (*v1.NodeList, error) {
+	nodes := &v1.NodeList{}
+	err := eachListItem(ctx, opts, func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.Client.CoreV1().Nodes().List(ctx, opts)
+	}, func(obj runtime.Object) error {
+		nodes.Items = append(nodes.Items, *obj.(*v1.Node))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// listPods returns all pods of namespace, or of all namespaces if empty,
+// matching opts.
+func (c *Cluster) listPods(ctx context.Context, namespace string, opts metav1.ListOptions) (*v1.PodList, error) {
+	pods := &v1.PodList{}
+	err := eachListItem(ctx, opts, func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.Client.CoreV1().Pods(namespace).List(ctx, opts)
+	}, func(obj runtime.Object) error {
+		pods.Items = append(pods.Items, *obj.(*v1.Pod))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pods, nil
+}
+
+// listServices returns all services of namespace, or of all namespaces if
+// empty, matching opts.
+func (c *Cluster) listServices(ctx context.Context, namespace string, opts metav1.ListOptions) (*v1.ServiceList, error) {
+	services := &v1.ServiceList{}
+	err := eachListItem(ctx, opts, func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.Client.CoreV1().Services(namespace).List(ctx, opts)
+	}, func(obj runtime.Object) error {
+		services.Items = append(services.Items, *obj.(*v1.Service))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// listResource returns all custom resources of namespace, or of all namespaces
+// if empty, matching opts.
+func (c *Cluster) listResource(ctx context.Context, resource schema.GroupVersionResource, namespace string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list := &unstructured.UnstructuredList{}
+	err := eachListItem(ctx, opts, func(opts metav1.ListOptions) (runtime.Object, error) {
+		return c.Dynamic.Resource(resource).Namespace(namespace).List(ctx, opts)
+	}, func(obj runtime.Object) error {
+		list.Items = append(list.Items, *obj.(*unstructured.Unstructured))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,123 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	f "k8s.io/client-go/kubernetes/fake"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// pagedClientset serves node and pod lists in pages of opts.Limit objects, as
+// the fake clientset ignores Limit and Continue. The continue token is the
+// offset of the next page.
+type pagedClientset struct {
+	kubernetes.Interface
+	calls *int
+}
+
+func (c pagedClientset) CoreV1() typedcorev1.CoreV1Interface {
+	return pagedCoreV1{c.Interface.CoreV1(), c.calls}
+}
+
+type pagedCoreV1 struct {
+	typedcorev1.CoreV1Interface
+	calls *int
+}
+
+func (c pagedCoreV1) Nodes() typedcorev1.NodeInterface {
+	return pagedNodes{c.CoreV1Interface.Nodes(), c.calls}
+}
+
+func (c pagedCoreV1) Pods(namespace string) typedcorev1.PodInterface {
+	return pagedPods{c.CoreV1Interface.Pods(namespace), c.calls}
+}
+
+type pagedNodes struct {
+	typedcorev1.NodeInterface
+	calls *int
+}
+
+func (n pagedNodes) List(ctx context.Context, opts metav1.ListOptions) (*v1.NodeList, error) {
+	*n.calls++
+	all, err := n.NodeInterface.List(ctx, metav1.ListOptions{LabelSelector: opts.LabelSelector})
+	if err != nil {
+		return nil, err
+	}
+	start, end, next := page(len(all.Items), opts)
+	list := &v1.NodeList{Items: all.Items[start:end]}
+	list.Continue = next
+	return list, nil
+}
+
+type pagedPods struct {
+	typedcorev1.PodInterface
+	calls *int
+}
+
+func (p pagedPods) List(ctx context.Context, opts metav1.ListOptions) (*v1.PodList, error) {
+	*p.calls++
+	all, err := p.PodInterface.List(ctx, metav1.ListOptions{LabelSelector: opts.LabelSelector})
+	if err != nil {
+		return nil, err
+	}
+	start, end, next := page(len(all.Items), opts)
+	list := &v1.PodList{Items: all.Items[start:end]}
+	list.Continue = next
+	return list, nil
+}
+
+// page returns the bounds of the page requested by opts out of n objects, and
+// the continue token of the next page.
+func page(n int, opts metav1.ListOptions) (int, int, string) {
+	start, _ := strconv.Atoi(opts.Continue)
+	end := start + int(opts.Limit)
+	if opts.Limit == 0 || end >= n {
+		return start, n, ""
+	}
+	return start, end, strconv.Itoa(end)
+}
+
+func TestListPaginated(t *testing.T) {
+	const objects = 2*pageSize + 50
+	calls := 0
+	c := Cluster{Client: pagedClientset{f.NewSimpleClientset(), &calls}}
+
+	labels := map[string]string{"casper-3.gather.town/sync": "true"}
+	for i := 0; i < objects; i++ {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("sfu-%d", i), Labels: map[string]string{mockNodeOpts.labelKey: "sfu"}}}
+		_, _ = c.Client.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("sfu-%d", i), Namespace: "media", Labels: labels}}
+		_, _ = c.Client.CoreV1().Pods("media").Create(context.TODO(), pod, metav1.CreateOptions{})
+	}
+
+	n, err := c.GetNodes(context.TODO(), Selector{Labels: mockNodeOpts.labelKey + " in (sfu)"})
+	if err != nil {
+		t.Fatalf("GetNodes() returned error: %v", err)
+	}
+	if len(n.Items) != objects || calls != 3 {
+		t.Errorf("Expecting %d nodes in 3 pages, got %d nodes in %d pages", objects, len(n.Items), calls)
+	}
+
+	calls = 0
+	p, err := c.GetPods(context.TODO(), Selector{Labels: "casper-3.gather.town/sync=true"})
+	if err != nil {
+		t.Fatalf("GetPods() returned error: %v", err)
+	}
+	if len(p.Items) != objects || calls != 3 {
+		t.Errorf("Expecting %d pods in 3 pages, got %d pods in %d pages", objects, len(p.Items), calls)
+	}
+
+	seen := make(map[string]bool)
+	for _, pod := range p.Items {
+		if seen[pod.Name] {
+			t.Fatalf("Pod %s listed twice", pod.Name)
+		}
+		seen[pod.Name] = true
+	}
+}
@@ -2,50 +2,105 @@ package kubernetes
 
 import (
 	"context"
-	"fmt"
+	"strconv"
 
 	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
 	common "github.com/gathertown/casper-3/pkg"
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 type Pod = common.Pod
 
 // Returns []Pod struct listing pod name, assigned Node and podLabels
-func (c *Cluster) Pods() ([]Pod, error) {
+func (c *Cluster) Pods(ctx context.Context) (_ []Pod, err error) {
+	ctx, span := tracing.Start(ctx, "kubernetes.Pods")
+	defer func() { tracing.End(span, err) }()
+
 	var pods []Pod
 
-	p, err := c.GetPods(cfg.SyncPodLabelKey, cfg.SyncPodLabelValue)
+	p, err := c.GetPods(ctx, PodSelector())
 	if err != nil {
 		return nil, err
 	}
 
 	for _, pod := range p.Items {
-		externalIp, err := c.getExternalIpByNodeName(pod.Spec.NodeName)
+		if reason := podExcluded(pod); reason != "" {
+			logger.Info("Pod not eligible for publishing", "pod", pod.Namespace+"/"+pod.Name, "reason", reason)
+			continue
+		}
+		node, err := c.getNode(ctx, pod.Spec.NodeName)
+		if apierrors.IsNotFound(err) {
+			logger.Info("Node of pod not found", "pod", pod.Namespace+"/"+pod.Name, "node", pod.Spec.NodeName)
+			continue
+		}
 		if err != nil {
-			metrics.ExecErrInc(err.Error())
 			return nil, err
 		}
-		podLabels := make(map[string]string)
-		podLabels = pod.Labels
-		pods = append(pods, Pod{pod.Name, Node{pod.Spec.NodeName, externalIp}, podLabels})
+		pods = append(pods, newPod(pod, podAddress(pod, node, cfg.AddressTypes)))
 	}
 
 	return pods, nil
 }
 
-// GetPods returns the list of cluster pods with the label: casper-3.gather.town/sync=true
-func (c *Cluster) GetPods(labelKey string, labelValue string) (*v1.PodList, error) {
-	labelSelector := fmt.Sprintf("%s=%s", labelKey, labelValue)
-	opts := metav1.ListOptions{
-		LabelSelector: labelSelector,
-		Limit:         300,
+// newPod returns the Pod of pod, resolving to address.
+func newPod(pod v1.Pod, address string) Pod {
+	podLabels := make(map[string]string)
+	podLabels = pod.Labels
+	opts := recordOptions("pod/"+pod.Namespace+"/"+pod.Name, pod.Name, pod.Annotations)
+	return Pod{Name: pod.Name, Namespace: pod.Namespace, AssignedNode: Node{Name: pod.Spec.NodeName, ExternalIP: address}, Labels: podLabels, RecordOptions: opts}
+}
+
+// podExcluded returns why a pod must not be published, or an empty string if
+// it may be. Terminating pods are excluded so that their records are withdrawn
+// ahead of their deletion.
+func podExcluded(pod v1.Pod) string {
+	if pod.DeletionTimestamp != nil {
+		return "Terminating"
+	}
+	if pod.Spec.NodeName == "" {
+		return "Unscheduled"
+	}
+	if pod.Status.Phase != v1.PodRunning {
+		return "Not" + string(v1.PodRunning)
+	}
+	if requireReady, _ := strconv.ParseBool(cfg.PodRequireReady); !requireReady {
+		return ""
+	}
+	// The Ready condition includes the pod's readiness gates, ContainersReady does not.
+	condition := v1.PodReady
+	if ignoreGates, _ := strconv.ParseBool(cfg.PodIgnoreReadinessGates); ignoreGates {
+		condition = v1.ContainersReady
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == condition && c.Status == v1.ConditionTrue {
+			return ""
+		}
 	}
-	p, err := c.Client.CoreV1().Pods("").List(context.TODO(), opts)
+	return "Not" + string(condition)
+}
+
+// GetPods returns the pods matching s, listed from the namespaces of s.
+// Pods of excluded namespaces are left out.
+func (c *Cluster) GetPods(ctx context.Context, s Selector) (*v1.PodList, error) {
+	opts, err := s.ListOptions()
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
 		return nil, err
 	}
-	return p, nil
+
+	pods := &v1.PodList{}
+	for _, namespace := range s.namespaces() {
+		p, err := c.listPods(ctx, namespace, opts)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return nil, err
+		}
+		for _, pod := range p.Items {
+			if s.NamespaceAllowed(pod.Namespace) {
+				pods.Items = append(pods.Items, pod)
+			}
+		}
+	}
+	return pods, nil
 }
@@ -2,6 +2,8 @@ package kubernetes
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/gathertown/casper-3/internal/config"
@@ -67,7 +69,7 @@ func TestGetPods(t *testing.T) {
 	c := setupClusterWithPods(t)
 	cfg := config.FromEnv()
 	pods := 2
-	p, _ := c.GetPods(cfg.SyncPodLabelKey, cfg.SyncPodLabelValue)
+	p, _ := c.GetPods(context.TODO(), Selector{Labels: cfg.SyncPodLabelKey + "=" + cfg.SyncPodLabelValue})
 
 	// test number of pods with label
 	if len(p.Items) != pods {
@@ -83,3 +85,105 @@ func TestGetPods(t *testing.T) {
 		}
 	}
 }
+
+func TestPods(t *testing.T) {
+	c := setupClusterWithPods(t)
+	labels := map[string]string{cfg.SyncPodLabelKey: cfg.SyncPodLabelValue}
+	ready := []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}, {Type: v1.ContainersReady, Status: v1.ConditionTrue}}
+	now := metav1.Now()
+
+	pods := []*v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-running", Labels: labels}, Spec: v1.PodSpec{NodeName: mockNodeOpts.nodeName}, Status: v1.PodStatus{Phase: v1.PodRunning, Conditions: ready}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-pending", Labels: labels}, Status: v1.PodStatus{Phase: v1.PodPending}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-starting", Labels: labels}, Spec: v1.PodSpec{NodeName: mockNodeOpts.nodeName}, Status: v1.PodStatus{Phase: v1.PodRunning}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-terminating", Labels: labels, DeletionTimestamp: &now}, Spec: v1.PodSpec{NodeName: mockNodeOpts.nodeName}, Status: v1.PodStatus{Phase: v1.PodRunning, Conditions: ready}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "sfu-lost", Labels: labels}, Spec: v1.PodSpec{NodeName: "sfu-gone"}, Status: v1.PodStatus{Phase: v1.PodRunning, Conditions: ready}},
+	}
+	for _, pod := range pods {
+		_, _ = c.Client.CoreV1().Pods("").Create(context.TODO(), pod, metav1.CreateOptions{})
+	}
+
+	p, err := c.Pods(context.TODO())
+	if err != nil {
+		t.Fatalf("Pods() returned error: %v", err)
+	}
+	if len(p) != 1 || p[0].Name != "sfu-running" || p[0].AssignedNode.ExternalIP != mockNodeOpts.externalIP {
+		t.Errorf("Expecting only pod sfu-running on %v, got %v", mockNodeOpts.externalIP, p)
+	}
+}
+
+func TestPodExcludedReadinessGates(t *testing.T) {
+	// The readiness gate is not met yet, the containers are ready
+	pod := v1.Pod{
+		Spec: v1.PodSpec{NodeName: mockNodeOpts.nodeName, ReadinessGates: []v1.PodReadinessGate{{ConditionType: "casper-3.gather.town/dns"}}},
+		Status: v1.PodStatus{Phase: v1.PodRunning, Conditions: []v1.PodCondition{
+			{Type: v1.PodReady, Status: v1.ConditionFalse},
+			{Type: v1.ContainersReady, Status: v1.ConditionTrue},
+		}},
+	}
+
+	if reason := podExcluded(pod); reason != "NotReady" {
+		t.Errorf("Expecting pod to be excluded as NotReady, got %q", reason)
+	}
+
+	setenv(t, "POD_IGNORE_READINESS_GATES", "true")
+	defer unsetenv(t, "POD_IGNORE_READINESS_GATES")
+	defer func(c *config.Config) { cfg = c }(cfg)
+	cfg = config.FromEnv()
+
+	if reason := podExcluded(pod); reason != "" {
+		t.Errorf("Expecting pod to be eligible, got %q", reason)
+	}
+}
+
+func TestPodsNodeLookups(t *testing.T) {
+	client := f.NewSimpleClientset()
+	c := Cluster{Client: client}
+	ready := []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+	labels := map[string]string{cfg.SyncPodLabelKey: cfg.SyncPodLabelValue}
+
+	for i := 0; i < 3; i++ {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("sfu-%d", i), Labels: map[string]string{mockNodeOpts.labelKey: mockNodeOpts.labelValue}},
+			Status: v1.NodeStatus{
+				Addresses:  []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: fmt.Sprintf("1.1.1.%d", i)}},
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+			},
+		}
+		_, _ = client.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+	}
+	for i := 0; i < 30; i++ {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("router-%d", i), Namespace: "default", Labels: labels},
+			Spec:       v1.PodSpec{NodeName: fmt.Sprintf("sfu-%d", i%3)},
+			Status:     v1.PodStatus{Phase: v1.PodRunning, Conditions: ready},
+		}
+		_, _ = client.CoreV1().Pods("default").Create(context.TODO(), pod, metav1.CreateOptions{})
+	}
+	client.ClearActions()
+
+	if _, err := c.Nodes(context.TODO()); err != nil {
+		t.Fatalf("Nodes() returned error: %v", err)
+	}
+	p, err := c.Pods(context.TODO())
+	if err != nil {
+		t.Fatalf("Pods() returned error: %v", err)
+	}
+	if len(p) != 30 {
+		t.Errorf("Expecting 30 pods, got %d", len(p))
+	}
+	for _, pod := range p {
+		if want := "1.1.1." + pod.AssignedNode.Name[len("sfu-"):]; pod.AssignedNode.ExternalIP != want {
+			t.Errorf("Expecting pod %s to resolve to %s, got %s", pod.Name, want, pod.AssignedNode.ExternalIP)
+		}
+	}
+
+	// One node listing and one pod listing, regardless of the number of pods.
+	var calls []string
+	for _, action := range client.Actions() {
+		calls = append(calls, action.GetVerb()+" "+action.GetResource().Resource)
+	}
+	if want := []string{"list nodes", "list pods"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Expecting API calls %v, got %v", want, calls)
+	}
+}
@@ -0,0 +1,49 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Pools returns a multi-value A RecordSet per node pool, named after the
+// value of the node label, e.g. "sfu", resolving to the ExternalIPs of its
+// nodes. At most maxAddresses addresses, the lowest ones, are kept per pool.
+func Pools(nodes []Node, maxAddresses int) []RecordSet {
+	targets := make(map[string][]string)
+	for _, node := range nodes {
+		if node.Pool == "" || node.ExternalIP == "" {
+			continue
+		}
+		name := strings.ToLower(node.Pool)
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			logger.Info("Pool name is not a valid record name", "pool", node.Pool, "error", errs[0])
+			continue
+		}
+		targets[name] = append(targets[name], node.ExternalIP)
+	}
+
+	var sets []RecordSet
+	for name, ips := range targets {
+		sortAddresses(ips)
+		if maxAddresses > 0 && len(ips) > maxAddresses {
+			logger.Debug("Capping pool addresses", "pool", name, "addresses", len(ips), "max", maxAddresses)
+			ips = ips[:maxAddresses]
+		}
+		sets = append(sets, RecordSet{Name: name, Type: "A", Targets: ips, Owner: fmt.Sprintf("pool/%s", name)})
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Name < sets[j].Name })
+
+	return sets
+}
+
+// sortAddresses sorts IPv4 addresses numerically, e.g. 1.1.1.9 before 1.1.1.10.
+func sortAddresses(ips []string) {
+	sort.Slice(ips, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(ips[i]).To4(), net.ParseIP(ips[j]).To4()) < 0
+	})
+}
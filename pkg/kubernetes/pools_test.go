@@ -0,0 +1,35 @@
+package kubernetes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPools(t *testing.T) {
+	nodes := []Node{
+		{Name: "sfu-8quob", ExternalIP: "1.1.1.2", Pool: "sfu"},
+		{Name: "sfu-8mh0d", ExternalIP: "1.1.1.1", Pool: "sfu"},
+		{Name: "sfu-8zzzz", ExternalIP: "1.1.1.10", Pool: "sfu"},
+		{Name: "router-4quob", ExternalIP: "1.1.1.7", Pool: "router"},
+		{Name: "default-8quob", ExternalIP: "1.1.1.4"},
+		{Name: "odd-8quob", ExternalIP: "1.1.1.5", Pool: "odd_pool"},
+	}
+
+	got := Pools(nodes, 2)
+	want := []RecordSet{
+		{Name: "router", Type: "A", Targets: []string{"1.1.1.7"}, Owner: "pool/router"},
+		{Name: "sfu", Type: "A", Targets: []string{"1.1.1.1", "1.1.1.2"}, Owner: "pool/sfu"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %v, got %v", want, got)
+	}
+
+	if got := Pools(nodes, 0); len(got[1].Targets) != 3 {
+		t.Errorf("Expecting 3 targets without a maximum, got %v", got[1].Targets)
+	}
+
+	// Addresses are kept in numeric order.
+	if got := Pools(nodes, 0); got[1].Targets[2] != "1.1.1.10" {
+		t.Errorf("Expecting 1.1.1.10 last, got %v", got[1].Targets)
+	}
+}
@@ -0,0 +1,121 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Selector selects the nodes, pods or CasperRecords to publish.
+type Selector struct {
+	Labels            string   // label selector, e.g. "tier in (media),!canary"
+	Fields            string   // field selector, e.g. "spec.nodeName!=sfu-8mh0d"
+	Namespaces        []string // namespaces to list objects from, all if empty
+	ExcludeNamespaces []string // namespaces whose objects are never published
+}
+
+// NodeSelector returns the configured node selector. NODE_SELECTOR takes
+// precedence over LABEL_KEY and LABEL_VALUES.
+func NodeSelector() Selector {
+	s := Selector{Labels: fmt.Sprintf("%s in (%s)", cfg.LabelKey, cfg.LabelValues), Fields: cfg.NodeFieldSelector}
+	if cfg.NodeSelector != "" {
+		s.Labels = cfg.NodeSelector
+	}
+	return s
+}
+
+// PodSelector returns the configured pod selector. SYNC_POD_SELECTOR takes
+// precedence over SYNC_POD_LABEL_KEY and SYNC_POD_LABEL_VALUE.
+func PodSelector() Selector {
+	s := Selector{
+		Labels:            fmt.Sprintf("%s=%s", cfg.SyncPodLabelKey, cfg.SyncPodLabelValue),
+		Fields:            cfg.SyncPodFieldSelector,
+		Namespaces:        cfg.SyncNamespaces,
+		ExcludeNamespaces: cfg.SyncExcludeNamespaces,
+	}
+	if cfg.SyncPodSelector != "" {
+		s.Labels = cfg.SyncPodSelector
+	}
+	return s
+}
+
+// ServiceSelector returns the configured service selector, the sync label and
+// the namespaces of SYNC_NAMESPACES and SYNC_EXCLUDE_NAMESPACES.
+func ServiceSelector() Selector {
+	return Selector{
+		Labels:            fmt.Sprintf("%s=%s", cfg.SyncPodLabelKey, cfg.SyncPodLabelValue),
+		Namespaces:        cfg.SyncNamespaces,
+		ExcludeNamespaces: cfg.SyncExcludeNamespaces,
+	}
+}
+
+// RecordSelector returns the namespaces CasperRecords are published from,
+// SYNC_RECORD_NAMESPACES or else SYNC_NAMESPACES, less
+// SYNC_EXCLUDE_NAMESPACES.
+func RecordSelector() Selector {
+	s := Selector{Namespaces: cfg.SyncNamespaces, ExcludeNamespaces: cfg.SyncExcludeNamespaces}
+	if len(cfg.SyncRecordNamespaces) > 0 {
+		s.Namespaces = cfg.SyncRecordNamespaces
+	}
+	return s
+}
+
+// ListOptions returns the list options of s. Selectors that don't parse are
+// returned as an error, rather than listing more objects than intended.
+func (s Selector) ListOptions() (metav1.ListOptions, error) {
+	if _, err := labels.Parse(s.Labels); err != nil {
+		return metav1.ListOptions{}, fmt.Errorf("invalid label selector %q: %w", s.Labels, err)
+	}
+	if _, err := fields.ParseSelector(s.Fields); err != nil {
+		return metav1.ListOptions{}, fmt.Errorf("invalid field selector %q: %w", s.Fields, err)
+	}
+	return metav1.ListOptions{LabelSelector: s.Labels, FieldSelector: s.Fields}, nil
+}
+
+// nodeSelectors returns the parsed selectors of s, which select among the
+// cached nodes. Fields that nodes can't be selected by are an error.
+func (s Selector) nodeSelectors() (labels.Selector, fields.Selector, error) {
+	labelSelector, err := labels.Parse(s.Labels)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid label selector %q: %w", s.Labels, err)
+	}
+	fieldSelector, err := fields.ParseSelector(s.Fields)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid field selector %q: %w", s.Fields, err)
+	}
+	for _, r := range fieldSelector.Requirements() {
+		if _, found := nodeFields(&v1.Node{})[r.Field]; !found {
+			return nil, nil, fmt.Errorf("invalid field selector %q: nodes can't be selected by %s", s.Fields, r.Field)
+		}
+	}
+	return labelSelector, fieldSelector, nil
+}
+
+// NamespaceAllowed reports whether objects of namespace may be published.
+func (s Selector) NamespaceAllowed(namespace string) bool {
+	for _, ns := range s.ExcludeNamespaces {
+		if ns == namespace {
+			return false
+		}
+	}
+	if len(s.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range s.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaces returns the namespaces to list objects from, "" meaning all.
+func (s Selector) namespaces() []string {
+	if len(s.Namespaces) == 0 {
+		return []string{""}
+	}
+	return s.Namespaces
+}
@@ -0,0 +1,151 @@
+package kubernetes
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	f "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSelectorListOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       Selector
+		wantErr bool
+	}{
+		{"equality", Selector{Labels: "casper-3.gather.town/sync=true"}, false},
+		{"set based", Selector{Labels: "tier in (media,edge),env notin (test),!canary,team", Fields: "spec.nodeName!=sfu-8mh0d"}, false},
+		{"invalid labels", Selector{Labels: "tier in (media"}, true},
+		{"invalid fields", Selector{Fields: "spec.nodeName in (sfu)"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := tt.s.ListOptions()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expecting error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && (opts.LabelSelector != tt.s.Labels || opts.FieldSelector != tt.s.Fields) {
+				t.Errorf("Expecting selectors %q and %q, got %+v", tt.s.Labels, tt.s.Fields, opts)
+			}
+		})
+	}
+}
+
+func TestNamespaceAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		s         Selector
+		namespace string
+		want      bool
+	}{
+		{"all namespaces", Selector{}, "media", true},
+		{"allowed", Selector{Namespaces: []string{"media", "edge"}}, "edge", true},
+		{"not allowed", Selector{Namespaces: []string{"media"}}, "tenant-a", false},
+		{"excluded", Selector{ExcludeNamespaces: []string{"tenant-a"}}, "tenant-a", false},
+		{"allowed and excluded", Selector{Namespaces: []string{"media"}, ExcludeNamespaces: []string{"media"}}, "media", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.NamespaceAllowed(tt.namespace); got != tt.want {
+				t.Errorf("Expecting %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetPodsSelector(t *testing.T) {
+	c := Cluster{Client: f.NewSimpleClientset()}
+	pods := []struct {
+		namespace string
+		name      string
+		labels    map[string]string
+	}{
+		{"media", "sfu-0", map[string]string{"tier": "media"}},
+		{"media", "sfu-canary", map[string]string{"tier": "media", "canary": "true"}},
+		{"edge", "router-0", map[string]string{"tier": "edge"}},
+		{"tenant-a", "sfu-0", map[string]string{"tier": "media"}},
+		{"tenant-b", "sfu-0", map[string]string{"tier": "media"}},
+	}
+	for _, p := range pods {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: p.name, Namespace: p.namespace, Labels: p.labels}}
+		_, _ = c.Client.CoreV1().Pods(p.namespace).Create(context.TODO(), pod, metav1.CreateOptions{})
+	}
+
+	tests := []struct {
+		name string
+		s    Selector
+		want []string
+	}{
+		{"set based", Selector{Labels: "tier in (media,edge),!canary"}, []string{"edge/router-0", "media/sfu-0", "tenant-a/sfu-0", "tenant-b/sfu-0"}},
+		{"allowed namespaces", Selector{Labels: "tier", Namespaces: []string{"media", "tenant-a"}}, []string{"media/sfu-0", "media/sfu-canary", "tenant-a/sfu-0"}},
+		{"excluded namespaces", Selector{Labels: "tier!=edge", ExcludeNamespaces: []string{"tenant-a", "tenant-b"}}, []string{"media/sfu-0", "media/sfu-canary"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := c.GetPods(context.TODO(), tt.s)
+			if err != nil {
+				t.Fatalf("GetPods() returned error: %v", err)
+			}
+			var got []string
+			for _, pod := range p.Items {
+				got = append(got, pod.Namespace+"/"+pod.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expecting pods %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := c.GetPods(context.TODO(), Selector{Labels: "tier in (media"}); err == nil {
+		t.Errorf("Expecting an error for an invalid selector")
+	}
+}
+
+func TestGetServicesSelector(t *testing.T) {
+	c := Cluster{Client: f.NewSimpleClientset()}
+	labels := map[string]string{cfg.SyncPodLabelKey: cfg.SyncPodLabelValue}
+	for _, namespace := range []string{"media", "tenant-a", "tenant-b"} {
+		svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: namespace, Labels: labels}}
+		_, _ = c.Client.CoreV1().Services(namespace).Create(context.TODO(), svc, metav1.CreateOptions{})
+	}
+
+	defer func(namespaces, exclude []string) {
+		cfg.SyncNamespaces, cfg.SyncExcludeNamespaces = namespaces, exclude
+	}(cfg.SyncNamespaces, cfg.SyncExcludeNamespaces)
+	cfg.SyncNamespaces, cfg.SyncExcludeNamespaces = []string{"media", "tenant-a"}, []string{"tenant-a"}
+
+	s, err := c.GetServices(context.TODO(), ServiceSelector())
+	if err != nil {
+		t.Fatalf("GetServices() returned error: %v", err)
+	}
+	if len(s.Items) != 1 || s.Items[0].Namespace != "media" {
+		t.Errorf("Expecting the service of media only, got %v", s.Items)
+	}
+}
+
+func TestRecordSelector(t *testing.T) {
+	defer func(namespaces, exclude, records []string) {
+		cfg.SyncNamespaces, cfg.SyncExcludeNamespaces, cfg.SyncRecordNamespaces = namespaces, exclude, records
+	}(cfg.SyncNamespaces, cfg.SyncExcludeNamespaces, cfg.SyncRecordNamespaces)
+	cfg.SyncNamespaces, cfg.SyncExcludeNamespaces = []string{"media"}, []string{"tenant-a"}
+
+	cfg.SyncRecordNamespaces = nil
+	want := Selector{Namespaces: []string{"media"}, ExcludeNamespaces: []string{"tenant-a"}}
+	if got := RecordSelector(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %+v, got %+v", want, got)
+	}
+
+	cfg.SyncRecordNamespaces = []string{"infra"}
+	want = Selector{Namespaces: []string{"infra"}, ExcludeNamespaces: []string{"tenant-a"}}
+	if got := RecordSelector(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expecting %+v, got %+v", want, got)
+	}
+}
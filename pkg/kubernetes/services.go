@@ -0,0 +1,140 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
+	common "github.com/gathertown/casper-3/pkg"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type RecordSet = common.RecordSet
+
+// Returns a []RecordSet for the services with the sync label. LoadBalancer
+// services resolve to their ingress IPs, or to their ingress hostname as a
+// CNAME record. NodePort services resolve to the addresses of the nodes
+// running their endpoints. A record set is named after its service, the sets
+// are ordered by creation of their service, see Claims.
+func (c *Cluster) Services(ctx context.Context) (_ []RecordSet, err error) {
+	ctx, span := tracing.Start(ctx, "kubernetes.Services")
+	defer func() { tracing.End(span, err) }()
+
+	var sets []RecordSet
+
+	s, err := c.GetServices(ctx, ServiceSelector())
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(s.Items, func(i, j int) bool {
+		return s.Items[i].CreationTimestamp.Before(&s.Items[j].CreationTimestamp)
+	})
+
+	for _, svc := range s.Items {
+		set := RecordSet{Name: svc.Name, Owner: fmt.Sprintf("service/%s/%s", svc.Namespace, svc.Name)}
+		switch svc.Spec.Type {
+		case v1.ServiceTypeLoadBalancer:
+			set.Type, set.Targets = loadBalancerTargets(svc)
+		case v1.ServiceTypeNodePort:
+			set.Type = "A"
+			set.Targets, err = c.nodePortTargets(ctx, svc)
+			if err != nil {
+				metrics.ExecErrInc(err)
+				return nil, err
+			}
+		default:
+			logger.Info("Service type not supported", "service", set.Owner, "type", svc.Spec.Type)
+			continue
+		}
+
+		if len(set.Targets) == 0 {
+			logger.Info("No address found", "service", set.Owner)
+			continue
+		}
+		logger.Debug("Service address found", "service", set.Owner, "type", set.Type, "targets", set.Targets)
+		sets = append(sets, set)
+	}
+
+	return sets, nil
+}
+
+// GetServices returns the services selected by s, listed from its allowed
+// namespaces only.
+func (c *Cluster) GetServices(ctx context.Context, s Selector) (*v1.ServiceList, error) {
+	opts, err := s.ListOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	services := &v1.ServiceList{}
+	for _, namespace := range s.namespaces() {
+		l, err := c.listServices(ctx, namespace, opts)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return nil, err
+		}
+		for _, svc := range l.Items {
+			if s.NamespaceAllowed(svc.Namespace) {
+				services.Items = append(services.Items, svc)
+			}
+		}
+	}
+	return services, nil
+}
+
+// loadBalancerTargets returns the ingress IPs of a LoadBalancer service as an
+// A record, or its first ingress hostname as a CNAME record.
+func loadBalancerTargets(svc v1.Service) (string, []string) {
+	var ips, hostnames []string
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			ips = append(ips, ingress.IP)
+		}
+		if ingress.Hostname != "" {
+			hostnames = append(hostnames, ingress.Hostname)
+		}
+	}
+	if len(ips) > 0 {
+		sort.Strings(ips)
+		return "A", ips
+	}
+	if len(hostnames) > 0 {
+		return "CNAME", hostnames[:1]
+	}
+	return "A", nil
+}
+
+// nodePortTargets returns the addresses of the nodes running the ready
+// endpoints of a NodePort service, see ADDRESS_TYPES.
+func (c *Cluster) nodePortTargets(ctx context.Context, svc v1.Service) ([]string, error) {
+	var ips []string
+
+	e, err := c.Client.CoreV1().Endpoints(svc.Namespace).Get(ctx, svc.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, subset := range e.Subsets {
+		for _, addr := range subset.Addresses {
+			if addr.NodeName == nil || seen[*addr.NodeName] {
+				continue
+			}
+			seen[*addr.NodeName] = true
+
+			n, err := c.getNode(ctx, *addr.NodeName)
+			if err != nil {
+				return nil, err
+			}
+			if ip := nodeAddress(n, cfg.AddressTypes); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	sort.Strings(ips)
+
+	return ips, nil
+}
@@ -0,0 +1,81 @@
+package kubernetes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var mockServicesOpts = []struct {
+	serviceName string
+	serviceType v1.ServiceType
+	labelKey    string
+	labelValue  string
+	ingress     []v1.LoadBalancerIngress
+}{
+	{"router", v1.ServiceTypeLoadBalancer, cfg.SyncPodLabelKey, cfg.SyncPodLabelValue, []v1.LoadBalancerIngress{{IP: "2.2.2.2"}, {IP: "2.2.2.1"}}},
+	{"gateway", v1.ServiceTypeLoadBalancer, cfg.SyncPodLabelKey, cfg.SyncPodLabelValue, []v1.LoadBalancerIngress{{Hostname: "lb-123.elb.amazonaws.com"}}},
+	{"pending", v1.ServiceTypeLoadBalancer, cfg.SyncPodLabelKey, cfg.SyncPodLabelValue, nil},
+	{"engine", v1.ServiceTypeNodePort, cfg.SyncPodLabelKey, cfg.SyncPodLabelValue, nil},
+	{"internal", v1.ServiceTypeClusterIP, cfg.SyncPodLabelKey, cfg.SyncPodLabelValue, nil},
+	{"unlabelled", v1.ServiceTypeLoadBalancer, "casper-3.gather.town/donothing", "nil", []v1.LoadBalancerIngress{{IP: "2.2.2.3"}}},
+}
+
+func setupClusterWithServices(t *testing.T) Cluster {
+	t.Helper()
+	c := setupCluster(t)
+	opts := metav1.CreateOptions{}
+
+	for _, s := range mockServicesOpts {
+		labels := map[string]string{
+			s.labelKey: s.labelValue,
+		}
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: s.serviceName, Namespace: "default", Labels: labels},
+			Spec:       v1.ServiceSpec{Type: s.serviceType},
+			Status:     v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: s.ingress}},
+		}
+		_, _ = c.Client.CoreV1().Services("default").Create(context.TODO(), svc, opts)
+	}
+
+	// The endpoints of the NodePort service run on two nodes, one of them twice.
+	nodeNames := []string{"sfu-8mh0d", "sfu-8quob", "sfu-8quob"}
+	var addresses []v1.EndpointAddress
+	for i := range nodeNames {
+		addresses = append(addresses, v1.EndpointAddress{IP: "10.1.0.1", NodeName: &nodeNames[i]})
+	}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "engine", Namespace: "default"},
+		Subsets:    []v1.EndpointSubset{{Addresses: addresses}},
+	}
+	_, _ = c.Client.CoreV1().Endpoints("default").Create(context.TODO(), endpoints, opts)
+
+	return c
+}
+
+func TestServices(t *testing.T) {
+	c := setupClusterWithServices(t)
+
+	sets, err := c.Services(context.TODO())
+	if err != nil {
+		t.Fatalf("Services() returned error: %v", err)
+	}
+
+	want := map[string]RecordSet{
+		"router":  {Name: "router", Type: "A", Targets: []string{"2.2.2.1", "2.2.2.2"}, Owner: "service/default/router"},
+		"gateway": {Name: "gateway", Type: "CNAME", Targets: []string{"lb-123.elb.amazonaws.com"}, Owner: "service/default/gateway"},
+		"engine":  {Name: "engine", Type: "A", Targets: []string{"1.1.1.1", "1.1.1.2"}, Owner: "service/default/engine"},
+	}
+
+	if len(sets) != len(want) {
+		t.Errorf("Expecting %v record sets, got %v record sets", len(want), len(sets))
+	}
+	for _, set := range sets {
+		if !reflect.DeepEqual(set, want[set.Name]) {
+			t.Errorf("Expecting record set %v, got %v", want[set.Name], set)
+		}
+	}
+}
@@ -1,8 +1,7 @@
 // Package log provides structured logging for the service. It follows the
-// philosophy that only actionable events should be logged and thus it provides
-// an Info level but no Error level. Beyond the info level, a Debug level is
-// also provided to aid during development. No other log levels are provided
-// till there is proof that they are needed.
+// philosophy that only actionable events should be logged. The Debug, Info,
+// Warn and Error levels are provided, and the minimum level is configured
+// with LOGLEVEL, e.g. "warn" to only log what needs attention.
 //
 // See: https://dave.cheney.net/2015/11/05/lets-talk-about-logging
 //
@@ -14,54 +13,146 @@
 // allows a message and an optional number of key value pairs which will be
 // translated to structured fields. If a value is not provided, the key will be
 // ignored.
+//
+// The following field names are used for the same things across packages:
+// "provider", "zone", "record" (the FQDN or name of a record), "type" (of a
+// record) and "action" ("create", "update", "delete" or "blocked"). Every line
+// logged during a reconcile carries its "reconcileID", see SetReconcileID.
 package log
 
 import (
 	"fmt"
 	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 )
 
+// Log formats of New.
+const (
+	FormatText = "text" // logfmt, the default
+	FormatJSON = "json"
+)
+
 // Logger is a structured logger.
 type Logger struct {
 	logger *logrus.Logger
+	fields logrus.Fields // added to every line, see With
 }
 
-// New creates a new structured logger. When develop is true, it provides the
-// output in logfmt format. Otherwise the output is JSON.
-func New(out io.Writer, level string) *Logger {
+// New creates a new structured logger logging at level and above, "debug",
+// "info", "warn" or "error", and "info" if the level is unknown. The output is
+// JSON if format is FormatJSON, logfmt otherwise. Lines for os.Stdout follow
+// SetOutput.
+func New(out io.Writer, level string, format string) *Logger {
 	var log = logrus.New()
+	if out == os.Stdout {
+		out = stdout
+	}
 	log.SetOutput(out)
 
-	// 	log.Formatter = &logrus.JSONFormatter{}
-	log.Formatter = &logrus.TextFormatter{}
-	if level == "debug" {
-		log.SetLevel(logrus.DebugLevel)
+	if strings.ToLower(format) == FormatJSON {
+		log.Formatter = &logrus.JSONFormatter{}
+	} else {
+		log.Formatter = &logrus.TextFormatter{}
 	}
+	if l, err := logrus.ParseLevel(level); err == nil {
+		log.SetLevel(l)
+	}
+	log.AddHook(reconcileHook{})
+
+	return &Logger{logger: log, fields: logrus.Fields{}}
+}
 
-	// Add aditional fields here
-	log.WithFields(logrus.Fields{})
+// stdout is the output of the loggers created for os.Stdout, see SetOutput.
+var stdout = &switchWriter{out: os.Stdout}
 
-	return &Logger{logger: log}
+// switchWriter writes to an output that can be changed while it is used.
+type switchWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *switchWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Write(p)
+}
+
+// SetOutput redirects the lines of the loggers created for os.Stdout to out,
+// e.g. to os.Stderr while a command writes its result to os.Stdout.
+func SetOutput(out io.Writer) {
+	stdout.mu.Lock()
+	defer stdout.mu.Unlock()
+	stdout.out = out
+}
+
+// With returns a logger adding the given key value pairs to every line, e.g.
+// the provider of a package.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make(logrus.Fields, len(l.fields)+len(keyvals)/2)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for k, v := range toMap(keyvals...) {
+		fields[k] = v
+	}
+	return &Logger{logger: l.logger, fields: fields}
 }
 
 // Info logs at info log level. For each key, a value should also be provided. If
 // a value is not provided, the key will be ignored.
 func (l *Logger) Info(message string, keyvals ...interface{}) {
-	l.logger.WithFields(toMap(keyvals...)).Info(message)
+	l.entry(keyvals).Info(message)
 }
 
 // Debug logs at debug log level. For each key, a value should also be provided. If
 // a value is not provided, the key will be ignored.
 func (l *Logger) Debug(message string, keyvals ...interface{}) {
-	l.logger.WithFields(toMap(keyvals...)).Debug(message)
+	l.entry(keyvals).Debug(message)
+}
+
+// Warn logs at warn log level. For each key, a value should also be provided. If
+// a value is not provided, the key will be ignored.
+func (l *Logger) Warn(message string, keyvals ...interface{}) {
+	l.entry(keyvals).Warn(message)
 }
 
 // Error logs at error log level. For each key, a value should also be provided. If
 // a value is not provided, the key will be ignored.
 func (l *Logger) Error(message string, keyvals ...interface{}) {
-	l.logger.WithFields(toMap(keyvals...)).Error(message)
+	l.entry(keyvals).Error(message)
+}
+
+func (l *Logger) entry(keyvals []interface{}) *logrus.Entry {
+	return l.logger.WithFields(l.fields).WithFields(toMap(keyvals...))
+}
+
+// reconcileID is the ID of the running reconcile, see SetReconcileID.
+var reconcileID atomic.Value
+
+// SetReconcileID adds id as the "reconcileID" field to the lines of all
+// loggers from then on, or stops adding it if id is empty. Reconciles run one
+// at a time, so a single ID correlates the lines of one.
+func SetReconcileID(id string) {
+	reconcileID.Store(id)
+}
+
+// reconcileHook adds the reconcileID field.
+type reconcileHook struct{}
+
+func (reconcileHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (reconcileHook) Fire(entry *logrus.Entry) error {
+	if id, _ := reconcileID.Load().(string); id != "" {
+		entry.Data["reconcileID"] = id
+	}
+	return nil
 }
 
 func toMap(keyvals ...interface{}) map[string]interface{} {
@@ -2,7 +2,9 @@ package log
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
@@ -10,7 +12,7 @@ import (
 
 func TestNew(t *testing.T) {
 	var buf bytes.Buffer
-	logger := New(&buf, "debug")
+	logger := New(&buf, "debug", FormatText)
 
 	logger.Debug("foo")
 	if got, want := buf.String(), "level=debug msg=foo"; !strings.Contains(got, want) {
@@ -24,6 +26,40 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "warn", FormatText)
+
+	logger.Info("bar")
+	if got := buf.String(); got != "" {
+		t.Errorf("expected no info message at warn level, got %q", got)
+	}
+
+	logger.Warn("baz")
+	if got, want := buf.String(), "level=warning msg=baz"; !strings.Contains(got, want) {
+		t.Errorf("expected logging message %q to contain %q", got, want)
+	}
+}
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "info", FormatJSON).With("provider", "cloudflare")
+
+	SetReconcileID("4f2a9c1e")
+	defer SetReconcileID("")
+	logger.Info("Added record", "record", "sfu-8mh0d.dev.k8s.gather.town", "action", "create")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	for k, want := range map[string]string{"msg": "Added record", "provider": "cloudflare", "action": "create", "reconcileID": "4f2a9c1e"} {
+		if got := line[k]; got != want {
+			t.Errorf("expected field %q of %q to be %q, got %v", k, buf.String(), want, got)
+		}
+	}
+}
+
 func TestToMap(t *testing.T) {
 	tests := []struct {
 		name string
@@ -98,3 +134,20 @@ func BenchmarkToMap(b *testing.B) {
 		toMap(keyvals...)
 	}
 }
+
+func TestSetOutput(t *testing.T) {
+	var other, stderr bytes.Buffer
+	logger := New(os.Stdout, "info", FormatText)
+	otherLogger := New(&other, "info", FormatText)
+
+	SetOutput(&stderr)
+	t.Cleanup(func() { SetOutput(os.Stdout) })
+	logger.Info("bar")
+	otherLogger.Info("baz")
+	if !strings.Contains(stderr.String(), "msg=bar") {
+		t.Errorf("expected logging message on the new output, got %q", stderr.String())
+	}
+	if strings.Contains(stderr.String(), "msg=baz") || !strings.Contains(other.String(), "msg=baz") {
+		t.Errorf("expected logging message on the own output, got %q and %q", stderr.String(), other.String())
+	}
+}
@@ -0,0 +1,337 @@
+// Package notify posts the record operations of the reconciles to webhooks,
+// e.g. Slack incoming webhooks or an alert router.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gathertown/casper-3/internal/config"
+	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/log"
+)
+
+var cfg = config.FromEnv()
+var logger = log.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+
+// TemplateSlack is the name of the built-in template of Slack-compatible
+// bodies.
+const TemplateSlack = "slack"
+
+const slackTemplate = `{"text": {{ json .Text }}}`
+
+// queueSize is the number of payloads waiting to be posted before new ones
+// are dropped.
+const queueSize = 100
+
+// Notification is a record operation.
+type Notification struct {
+	Action      string    `json:"action"` // "create", "update", "delete" or "blocked"
+	Kind        string    `json:"kind"`   // "node", "pod", the kind of a record set or empty
+	Namespace   string    `json:"namespace,omitempty"`
+	Name        string    `json:"name"` // of the object
+	Record      string    `json:"record"`
+	Type        string    `json:"type"`
+	IP          string    `json:"ip,omitempty"`
+	Provider    string    `json:"provider"`
+	Zone        string    `json:"zone"`
+	Environment string    `json:"environment"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// Payload is the data of a webhook body: the notifications of a reconcile,
+// or a single one if not batched.
+type Payload struct {
+	ReconcileID   string         `json:"reconcileID"`
+	Provider      string         `json:"provider"`
+	Zone          string         `json:"zone"`
+	Environment   string         `json:"environment"`
+	Notifications []Notification `json:"notifications"`
+}
+
+// Text returns a line per notification, for chat messages.
+func (p Payload) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "casper-3 %s (%s/%s):", p.Environment, p.Provider, p.Zone)
+	for _, n := range p.Notifications {
+		b.WriteString("\n")
+		switch {
+		case n.Action == "blocked":
+			fmt.Fprintf(&b, "blocked %s: %s", n.Record, n.Error)
+		case n.Error != "":
+			fmt.Fprintf(&b, "failed to %s %s %s: %s", n.Action, n.Type, n.Record, n.Error)
+		case n.IP != "":
+			fmt.Fprintf(&b, "%s %s %s -> %s", n.Action, n.Type, n.Record, n.IP)
+		default:
+			fmt.Fprintf(&b, "%s %s %s", n.Action, n.Type, n.Record)
+		}
+	}
+	return b.String()
+}
+
+// Notifier posts payloads to webhooks in the background, so that a slow
+// endpoint doesn't hold up the reconciles. A failed or blocked operation is
+// notified once, until it succeeds or fails with another error.
+type Notifier struct {
+	URLs    []string
+	Batch   bool     // one payload per reconcile rather than per change
+	Actions []string // actions to notify, all if empty
+	Retries int      // attempts after the first one of a failed post
+	Client  *http.Client
+
+	template *template.Template // nil for JSON payloads
+	backoff  time.Duration      // before the first retry, doubled for each one
+	queue    chan Payload
+	wg       sync.WaitGroup
+	ctx      context.Context // canceled once the queue is not posted within the grace period
+	cancel   context.CancelFunc
+
+	mu      sync.Mutex
+	failing map[string]string // errors of the last reconcile by action and record
+}
+
+// New returns a notifier posting to urls with bodies rendered by tmpl, a Go
+// text/template of a Payload, TemplateSlack, or the JSON payload if empty,
+// and a function posting the queued payloads and stopping it. The posts still
+// running after grace are canceled and the payloads left are dropped.
+func New(urls []string, tmpl string, batch bool, actions []string, retries int, grace time.Duration) (*Notifier, func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{
+		URLs:    urls,
+		Batch:   batch,
+		Actions: actions,
+		Retries: retries,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		backoff: time.Second,
+		queue:   make(chan Payload, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		failing: make(map[string]string),
+	}
+	for _, webhook := range urls {
+		if u, err := url.Parse(webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, nil, fmt.Errorf("invalid webhook URL %s", redact(webhook))
+		}
+	}
+	if tmpl == TemplateSlack {
+		tmpl = slackTemplate
+	}
+	if tmpl != "" {
+		t, err := template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(tmpl)
+		if err != nil {
+			return nil, nil, err
+		}
+		n.template = t
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for payload := range n.queue {
+			// Dropped once the grace period is over, see stop
+			if n.ctx.Err() == nil {
+				n.post(payload)
+			}
+		}
+	}()
+	stop := func() {
+		close(n.queue)
+		done := make(chan struct{})
+		go func() {
+			n.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(grace):
+			logger.Warn("Webhook posts did not complete within the shutdown timeout, dropping notifications", "notifications", len(n.queue))
+			cancel()
+			<-done
+		}
+		cancel()
+	}
+	return n, stop, nil
+}
+
+// Notify queues the changes of the reconcile id. The payloads are dropped if
+// too many are waiting already.
+func (n *Notifier) Notify(id string, changes []common.Change) {
+	for _, payload := range n.payloads(id, changes) {
+		select {
+		case n.queue <- payload:
+		default:
+			logger.Warn("Webhook queue full, dropping notifications", "notifications", len(payload.Notifications))
+		}
+	}
+}
+
+// payloads returns the payloads of the changes to notify. The failed and
+// blocked changes that failed with the same error in the last reconcile are
+// not notified again.
+func (n *Notifier) payloads(id string, changes []common.Change) []Payload {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var notifications []Notification
+	failing := make(map[string]string)
+	for _, c := range changes {
+		if !n.notifies(c.Action) {
+			continue
+		}
+		if c.Err != nil {
+			key := c.Action + " " + c.FQDN
+			failing[key] = c.Err.Error()
+			if n.failing[key] == c.Err.Error() {
+				continue
+			}
+		}
+		notification := Notification{
+			Action:      c.Action,
+			Kind:        c.Kind,
+			Namespace:   c.Namespace,
+			Name:        c.Name,
+			Record:      c.FQDN,
+			Type:        c.Type,
+			IP:          c.Content,
+			Provider:    cfg.Provider,
+			Zone:        cfg.Zone,
+			Environment: cfg.Env,
+			Time:        c.Time,
+		}
+		if c.Err != nil {
+			notification.Error = c.Err.Error()
+		}
+		notifications = append(notifications, notification)
+	}
+	n.failing = failing
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	payload := func(notifications ...Notification) Payload {
+		return Payload{ReconcileID: id, Provider: cfg.Provider, Zone: cfg.Zone, Environment: cfg.Env, Notifications: notifications}
+	}
+	if n.Batch {
+		return []Payload{payload(notifications...)}
+	}
+	payloads := make([]Payload, 0, len(notifications))
+	for _, notification := range notifications {
+		payloads = append(payloads, payload(notification))
+	}
+	return payloads
+}
+
+func (n *Notifier) notifies(action string) bool {
+	if len(n.Actions) == 0 {
+		return true
+	}
+	for _, a := range n.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// post posts payload to every webhook, retrying failed posts.
+func (n *Notifier) post(payload Payload) {
+	body, err := n.render(payload)
+	if err != nil {
+		logger.Error("Error occured while rendering webhook body", "reconcileID", payload.ReconcileID, "error", err.Error())
+		return
+	}
+	for _, webhook := range n.URLs {
+		backoff := n.backoff
+		for attempt := 0; ; attempt++ {
+			err = n.send(webhook, body)
+			if err == nil || attempt >= n.Retries || !retryable(err) || n.ctx.Err() != nil {
+				break
+			}
+			select {
+			case <-time.After(backoff):
+			case <-n.ctx.Done():
+			}
+			backoff *= 2
+		}
+		if err != nil {
+			// The URL may carry a secret, e.g. of a Slack webhook.
+			logger.Error("Error occured while posting to webhook", "webhook", redact(webhook), "reconcileID", payload.ReconcileID, "error", err.Error())
+		}
+	}
+}
+
+func (n *Notifier) render(payload Payload) ([]byte, error) {
+	if n.template == nil {
+		return json.Marshal(payload)
+	}
+	var b bytes.Buffer
+	err := n.template.Execute(&b, payload)
+	return b.Bytes(), err
+}
+
+// statusError is the status of a post that didn't succeed.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("webhook responded %d %s", e.code, http.StatusText(e.code))
+}
+
+// retryable returns whether a post failing with err may succeed later:
+// network errors, rate limits and server errors.
+func retryable(err error) bool {
+	if s, ok := err.(*statusError); ok {
+		return s.code == http.StatusTooManyRequests || s.code >= 500
+	}
+	return true
+}
+
+func (n *Notifier) send(webhook string, body []byte) error {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if uerr, ok := err.(*url.Error); ok {
+		// Without the URL, see redact.
+		return uerr.Err
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{code: resp.StatusCode}
+	}
+	return nil
+}
+
+// redact returns webhook without its path and query, which often hold the
+// token of the webhook.
+func redact(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "invalid URL"
+	}
+	return u.Scheme + "://" + u.Host + "/..."
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,180 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	common "github.com/gathertown/casper-3/pkg"
+)
+
+var changes = []common.Change{
+	{Action: "create", Kind: "node", Name: "sfu-a", FQDN: "sfu-a.dev.k8s.gather.town", Type: "A", Content: "1.2.3.4"},
+	{Action: "delete", Kind: "node", Name: "sfu-b", FQDN: "sfu-b.dev.k8s.gather.town", Type: "A", Err: errors.New("rate limited")},
+	{Action: "blocked", Kind: "node", Name: "sfu-c", FQDN: "sfu-c.dev.k8s.gather.town", Type: "A", Err: common.ErrNoAddress},
+}
+
+// webhook records the bodies posted to it, failing the first failures posts
+// with status.
+type webhook struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	bodies   []string
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		rw.WriteHeader(w.status)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	w.bodies = append(w.bodies, string(body))
+}
+
+func TestNotifyBatch(t *testing.T) {
+	hook := &webhook{failures: 2, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	n, stop, err := New([]string{server.URL + "/hooks/secret"}, "", true, nil, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	n.backoff = 0
+	n.Notify("4f2a9c1e", changes)
+	stop()
+
+	if len(hook.bodies) != 1 {
+		t.Fatalf("Expecting 1 post after 2 retries, got %d", len(hook.bodies))
+	}
+	var payload Payload
+	if err := json.Unmarshal([]byte(hook.bodies[0]), &payload); err != nil {
+		t.Fatalf("Decoding payload returned error: %v", err)
+	}
+	if payload.ReconcileID != "4f2a9c1e" || len(payload.Notifications) != 3 {
+		t.Fatalf("Expecting the 3 changes of reconcile 4f2a9c1e, got %+v", payload)
+	}
+	if got := payload.Notifications[0]; got.Action != "create" || got.Record != "sfu-a.dev.k8s.gather.town" || got.IP != "1.2.3.4" {
+		t.Errorf("Expecting the create of sfu-a, got %+v", got)
+	}
+	if got := payload.Notifications[2].Error; got != common.ErrNoAddress.Error() {
+		t.Errorf("Expecting error %q, got %q", common.ErrNoAddress.Error(), got)
+	}
+}
+
+func TestNotifyTemplate(t *testing.T) {
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	n, stop, err := New([]string{server.URL}, TemplateSlack, false, []string{"create", "blocked"}, 0, time.Minute)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	n.Notify("4f2a9c1e", changes)
+	stop()
+
+	if len(hook.bodies) != 2 {
+		t.Fatalf("Expecting 2 posts, one per notified change, got %d", len(hook.bodies))
+	}
+	var body struct{ Text string }
+	if err := json.Unmarshal([]byte(hook.bodies[1]), &body); err != nil {
+		t.Fatalf("Decoding body %q returned error: %v", hook.bodies[1], err)
+	}
+	if want := "blocked sfu-c.dev.k8s.gather.town: no IP address found"; !strings.HasSuffix(body.Text, want) {
+		t.Errorf("Expecting text ending in %q, got %q", want, body.Text)
+	}
+}
+
+func TestNotifyNoRetry(t *testing.T) {
+	hook := &webhook{failures: 1, status: http.StatusBadRequest}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	n, stop, err := New([]string{server.URL}, "", true, nil, 3, time.Minute)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	n.backoff = 0
+	n.Notify("4f2a9c1e", changes)
+	stop()
+
+	if len(hook.bodies) != 0 {
+		t.Errorf("Expecting no posts as client errors are not retried, got %d", len(hook.bodies))
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, _, err := New([]string{"hooks.slack.com/services/secret"}, "", true, nil, 0, time.Minute); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Expecting an error without the path for a URL without scheme, got %v", err)
+	}
+	if _, _, err := New([]string{"https://hooks.slack.com/services/secret"}, "{{ .Unknown", true, nil, 0, time.Minute); err == nil {
+		t.Error("Expecting an error for an invalid template")
+	}
+}
+
+func TestNotifyRepeatedFailures(t *testing.T) {
+	n := &Notifier{failing: make(map[string]string)}
+	notified := func(changes ...common.Change) []string {
+		var records []string
+		for _, payload := range n.payloads("4f2a9c1e", changes) {
+			for _, notification := range payload.Notifications {
+				records = append(records, notification.Action+" "+notification.Record)
+			}
+		}
+		return records
+	}
+
+	for i, tt := range []struct {
+		changes []common.Change
+		want    []string
+	}{
+		{changes, []string{"create sfu-a.dev.k8s.gather.town", "delete sfu-b.dev.k8s.gather.town", "blocked sfu-c.dev.k8s.gather.town"}},
+		// The same failures are not notified again
+		{changes, []string{"create sfu-a.dev.k8s.gather.town"}},
+		// but once they fail with another error
+		{[]common.Change{changes[1], {Action: "blocked", FQDN: "sfu-c.dev.k8s.gather.town", Err: common.ErrPrefixGuard}}, []string{"blocked sfu-c.dev.k8s.gather.town"}},
+		// or succeed
+		{[]common.Change{{Action: "delete", FQDN: "sfu-b.dev.k8s.gather.town"}}, []string{"delete sfu-b.dev.k8s.gather.town"}},
+		// and fail again
+		{[]common.Change{changes[1]}, []string{"delete sfu-b.dev.k8s.gather.town"}},
+	} {
+		if got := notified(tt.changes...); strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+			t.Errorf("Expecting %q in reconcile %d, got %q", tt.want, i+1, got)
+		}
+	}
+}
+
+func TestStopGrace(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	n, stop, err := New([]string{server.URL}, "", false, nil, 3, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	n.Notify("4f2a9c1e", changes)
+
+	start := time.Now()
+	stop()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expecting stop to return after the grace period, got %s", elapsed)
+	}
+}
@@ -2,24 +2,64 @@ package cloudflare
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	cloudflare "github.com/cloudflare/cloudflare-go"
 	"github.com/gathertown/casper-3/internal/config"
 	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
 	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/audit"
 	"github.com/gathertown/casper-3/pkg/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var cfg = config.FromEnv()
-var logger = log.New(os.Stdout, cfg.LogLevel)
+var logger = log.New(os.Stdout, cfg.LogLevel, cfg.LogFormat).With("provider", cfg.Provider)
 var label = fmt.Sprintf("heritage=casper-3,environment=%s", cfg.Env)
 
+// nodeLabel returns the content of the TXT record of a node record pair. The
+// records of older releases carry label only.
+func nodeLabel(nodeName string) string {
+	return label + ",nodeName=" + nodeName
+}
+
+// podPrefix starts the content of the TXT records of pod-sync record pairs.
+var podPrefix = fmt.Sprintf("heritage=casper-3,pod-sync=true,environment=%s,", cfg.Env)
+
+// podLabel returns the content of the TXT record of a pod-sync record pair.
+func podLabel(namespace string, podName string, assignedNode string, addressIPv4 string) string {
+	return podPrefix + fmt.Sprintf("namespace=%s,podName=%s,assignedNode=%s,addressIPv4=%s", namespace, podName, assignedNode, addressIPv4)
+}
+
+// defaultTTL is the TTL of records without a TTL annotation or setting.
+const defaultTTL = 1800
+
+// rollbackTimeout bounds the removal of a half-created record pair.
+const rollbackTimeout = 10 * time.Second
+
 type Node = common.Node
 type Pod = common.Pod
-type CloudFlareDNS struct{}
+
+// CloudFlareDNS syncs cluster nodes and pods to DNS records.
+type CloudFlareDNS struct {
+	// ShutdownTimeout is how long an in-flight record pair may take to
+	// complete once the sync context is cancelled.
+	ShutdownTimeout time.Duration
+	// Changes, if set, collects the record operations for reporting.
+	Changes *common.Changes
+	// Observed, if set, collects the owned records found in the zone.
+	Observed *common.Observed
+}
+
+// clientOptions are appended to the options of the clients, e.g. by tests to
+// use a fake API.
+var clientOptions []cloudflare.Option
 
 func NewCFClient() *cloudflare.API {
 	// If we have debug mode enabled, pass that over to the CF client as well
@@ -27,16 +67,41 @@ func NewCFClient() *cloudflare.API {
 	if strings.ToLower(cfg.LogLevel) == "debug" {
 		debug = true
 	}
-	api, err := cloudflare.NewWithAPIToken(cfg.Token, cloudflare.Debug(debug))
+	options := append([]cloudflare.Option{cloudflare.Debug(debug),
+		cloudflare.HTTPClient(&http.Client{Transport: metrics.InstrumentRoundTripper("cloudflare", tracing.Transport("cloudflare", nil))})}, clientOptions...)
+	api, err := cloudflare.NewWithAPIToken(cfg.Token, options...)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		logger.Error("Error while creating client", "provider", cfg.Provider, "zone", cfg.Zone, "error", err.Error())
+		metrics.ExecErrInc(err)
+		logger.Error("Error while creating client", "zone", cfg.Zone, "error", err.Error())
 	}
 	return api
 }
 
-func (d CloudFlareDNS) Sync(nodes []Node) {
-	var nodeHostnames, dnsRecords []string
+// zoneIDByName is client.ZoneIDByName with a context, so that the lookup is
+// cancelled and traced along with the sync.
+func zoneIDByName(ctx context.Context, client *cloudflare.API, zone string) (zoneID string, err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.ZoneIDByName", attribute.String("zone", zone))
+	defer func() { tracing.End(span, err) }()
+
+	res, err := client.ListZonesContext(ctx, cloudflare.WithZoneFilters(zone, client.AccountID, ""))
+	if err != nil {
+		return "", fmt.Errorf("ListZonesContext command failed: %w", err)
+	}
+	switch len(res.Result) {
+	case 0:
+		return "", errors.New("zone could not be found")
+	case 1:
+		return res.Result[0].ID, nil
+	default:
+		return "", errors.New("ambiguous zone name; an account ID might help")
+	}
+}
+
+func (d CloudFlareDNS) Sync(ctx context.Context, nodes []Node) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.Sync")
+	defer func() { tracing.End(span, err) }()
+
+	var nodeHostnames, nodeNames, dnsRecords, failed []string
 
 	// Setup the client
 	client := NewCFClient()
@@ -47,61 +112,105 @@ func (d CloudFlareDNS) Sync(nodes []Node) {
 	// Count all records in the zone. Useful for alerting purposes.
 	// This call is expensive. Takes up to ~50s for 3k records. Run in a Goroutine.
 	go func() {
-		allRecords, err := getAllRecords(context.TODO(), client, cfg.Zone)
+		allRecords, err := getAllRecords(ctx, client, cfg.Zone)
 		if err != nil {
-			metrics.ExecErrInc(err.Error())
-			logger.Error("Error occured while fetching all records", "provider", cfg.Provider, "zone", cfg.Zone, "error", err.Error())
+			metrics.ExecErrInc(err)
+			logger.Error("Error occured while fetching all records", "zone", cfg.Zone, "error", err.Error())
 		} else {
 			metrics.DNSRecordsTotal(cfg.Provider, allRecords)
 		}
 	}()
 
 	// Fetch all TXT DNS that contain cluster's label
-	txtRecords, err := getRecordsPerTypePerContent(context.TODO(), client, cfg.Zone, recordType, label)
+	txtRecords, err := getRecordsPerTypePerContent(ctx, client, cfg.Zone, recordType, label)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		logger.Error("Error occured while fetching records", "provider", cfg.Provider, "zone", cfg.Zone, "error", err.Error())
-		return
+		metrics.ExecErrInc(err)
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "error", err.Error())
+		return err
 	}
 
-	// Generate arrays
+	// Generate arrays. The FQDNs are kept as the subdomain may be overridden per node.
+	fqdns := make(map[string][]string)
+	// The names of the nodes of the records, if their label carries one
+	objects := make(map[string]string)
+	var owned []common.Record
 	for _, record := range txtRecords {
 		// convert "sfu-v81hha.dev" to "sfu-v81hha" to allow comparison with hostnames
 		cName := strings.Split(record.Name, ".")
 		dnsRecords = append(dnsRecords, cName[0])
+		fqdns[cName[0]] = append(fqdns[cName[0]], record.Name)
+		if nodeName := common.ParseLabel(record.Content)["nodeName"]; nodeName != "" {
+			objects[cName[0]] = nodeName
+		}
+		if common.IsOwned(record.Content, cfg.Env) && common.LabelKind(record.Content) == "node" {
+			owned = append(owned, common.Record{Kind: "node", FQDN: record.Name, Type: record.Type, Content: record.Content})
+		}
+	}
+	zoneID, addresses, err := getAddressRecords(ctx, client)
+	if err != nil {
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", "A", "error", err.Error())
+		return err
 	}
+	d.observe("node", owned, addresses)
+	metrics.DNSOwnedRecords(cfg.Provider, "node", float64(len(owned)))
 	logger.Debug("DNS records found", "records", dnsRecords)
 
+	desired := make(map[string]Node)
 	for _, node := range nodes {
-		// convert "ip-1-2-3-4.ec.internal" to "ip-1-2-3-4" to avoid DNS A record setup failure on Cloudflare
-		nodeHostnames = append(nodeHostnames, node.Name)
+		// the hostname is "ip-1-2-3-4" for "ip-1-2-3-4.ec.internal", unless overridden, to avoid DNS A record setup failure on Cloudflare
+		nodeHostnames = append(nodeHostnames, node.Hostname)
+		nodeNames = append(nodeNames, node.Name)
+		desired[node.Hostname] = node
 	}
 	logger.Debug("SFU nodes found", "nodes", nodeHostnames)
 
+	// Records are safe for deletion if they match the node pools, by name or hostname.
+	nodePrefixes := append(nodeNames, nodeHostnames...)
+
 	// Find new entries
 	addEntries := common.Compare(nodeHostnames, dnsRecords)
+	// Find entries whose subdomain changed
+	for _, name := range nodeHostnames {
+		if f, found := fqdns[name]; found && !contains(f, optionsFQDN(desired[name].RecordOptions)) {
+			addEntries = append(addEntries, name)
+		}
+	}
 	if len(addEntries) > 0 {
 		logger.Info("Entries to be added", "entries", addEntries)
 		for _, name := range addEntries {
-			addressIPv4 := ""
-			// this loop seems a bit inefficient at first glance
-			// entries are bellow 1k, so shouldn't really matter.
-			for _, entry := range nodes {
-				logger.Debug("entries", "name", name, "zone", cfg.Zone, "entries", entry.ExternalIP)
-				if entry.Name == name {
-					addressIPv4 = entry.ExternalIP
-				}
+			// Stop before starting a new record pair when shutting down
+			if err := ctx.Err(); err != nil {
+				return err
 			}
+			node := desired[name]
 			// Does this check make sense?
-			if addressIPv4 == "" {
-				logger.Info("IP address not found for entry", "name", name, "zone", cfg.Zone)
-			} else {
-				_, err := addRecord(context.TODO(), client, cfg.Zone, cfg.Subdomain, name, addressIPv4, "", "", cfg.Env)
-				if err != nil {
-					metrics.ExecErrInc(err.Error())
-					logger.Error("Error occured while adding record", "provider", cfg.Provider, "zone", cfg.Zone, "name", name, "error", err.Error())
+			if node.ExternalIP == "" {
+				logger.Warn("IP address not found for entry", "record", name, "zone", cfg.Zone, "action", "blocked")
+				d.Changes.Add(common.Change{Action: "blocked", Kind: "node", Name: node.Name, FQDN: optionsFQDN(node.RecordOptions), Type: "A", Err: common.ErrNoAddress})
+				continue
+			}
+			var ids []string
+			// The error of this node only, the nodes that failed are reported by SyncErr
+			var err error
+			pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+			pctx = audit.WithObject(pctx, "node", "", node.Name)
+			// Remove the records of a previous subdomain first
+			for _, fqdn := range fqdns[name] {
+				logger.Debug("Launching deletion", "record", fqdn)
+				if _, err = deleteRecord(pctx, client, cfg.Zone, fqdn); err != nil {
+					break
 				}
 			}
+			if err == nil {
+				ids, err = addRecord(pctx, client, cfg.Zone, optionsFQDN(node.RecordOptions), node.ExternalIP, nodeLabel(node.Name), node.TTLOr(defaultTTL), node.ProxiedOr(isProxied(node.Name)))
+			}
+			cancel()
+			d.Changes.Add(common.Change{Action: "create", Kind: "node", Name: node.Name, FQDN: optionsFQDN(node.RecordOptions), Type: "A", Content: node.ExternalIP, RecordIDs: ids, Err: err})
+			if err != nil {
+				metrics.ExecErrInc(err)
+				failed = append(failed, name)
+				logger.Error("Error occured while adding record", "zone", cfg.Zone, "record", optionsFQDN(node.RecordOptions), "type", "A", "error", err.Error())
+			}
 		}
 	}
 
@@ -110,30 +219,69 @@ func (d CloudFlareDNS) Sync(nodes []Node) {
 	if len(deleteEntries) > 0 {
 		logger.Info("Entries to be deleted", "entries", deleteEntries)
 		for _, name := range deleteEntries {
-			// The 'Name' entry is the FQDN
-			cName := fmt.Sprintf("%s.%s", name, cfg.Zone)
-			if cfg.Subdomain != "" {
-				cName = fmt.Sprintf("%s.%s.%s", name, cfg.Subdomain, cfg.Zone)
-			}
-			if isRecordSafeForDeletion := common.RecordPrefixMatchesNodePrefixes(cName, nodeHostnames); !isRecordSafeForDeletion {
-				logger.Info("Casper-3 wants to delete this record", "record", cName, "Skipping..")
-				continue
+			// The node may still exist, e.g. cordoned, the record name is reported otherwise
+			object := name
+			if nodeName, found := objects[name]; found {
+				object = nodeName
 			}
-			logger.Debug("Launching deletion", "record", cName)
-			_, err := deleteRecord(context.TODO(), client, cfg.Zone, cName)
-			if err != nil {
-				metrics.ExecErrInc(err.Error())
-				logger.Error("Error occured while launching deletion", "provider", cfg.Provider, "zone", cfg.Zone, "error", err.Error())
+			// The 'Name' entry is the FQDN
+			for _, cName := range fqdns[name] {
+				// Stop before starting a new record pair when shutting down
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if isRecordSafeForDeletion := common.RecordPrefixMatchesNodePrefixes(cName, nodePrefixes); !isRecordSafeForDeletion {
+					logger.Warn("Skipping deletion of record not matching the prefix of any node", "record", cName, "action", "blocked")
+					d.Changes.Add(common.Change{Action: "blocked", Kind: "node", Name: object, FQDN: cName, Type: "A", Err: common.ErrPrefixGuard})
+					continue
+				}
+				logger.Debug("Launching deletion", "record", cName)
+				pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+				pctx = audit.WithObject(pctx, "node", "", object)
+				_, err := deleteRecord(pctx, client, cfg.Zone, cName)
+				cancel()
+				d.Changes.Add(common.Change{Action: "delete", Kind: "node", Name: object, FQDN: cName, Type: "A", Err: err})
+				if err != nil {
+					metrics.ExecErrInc(err)
+					failed = append(failed, name)
+					logger.Error("Error occured while launching deletion", "zone", cfg.Zone, "record", cName, "type", "A", "error", err.Error())
+				}
 			}
 		}
 	}
 
+	// Apply changed TTL and proxied annotations to the records of the other nodes
+	for _, name := range nodeHostnames {
+		if contains(addEntries, name) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		node := desired[name]
+		fqdn := optionsFQDN(node.RecordOptions)
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		pctx = audit.WithObject(pctx, "node", "", node.Name)
+		updated, err := updateRecordOptions(pctx, client, zoneID, addresses[fqdn], node.TTLOr(defaultTTL), node.ProxiedOr(isProxied(node.Name)))
+		cancel()
+		if updated || err != nil {
+			d.Changes.Add(common.Change{Action: "update", Kind: "node", Name: node.Name, FQDN: fqdn, Type: "A", Content: node.ExternalIP, Err: err})
+		}
+		if err != nil {
+			failed = append(failed, name)
+			logger.Error("Error occured while updating record", "zone", cfg.Zone, "record", fqdn, "type", "A", "error", err.Error())
+		}
+	}
+
 	// Find kubernetes nodes to register
-	return
+	return common.SyncErr(failed)
 }
 
-func (c CloudFlareDNS) SyncPods(pods []Pod) {
-	var names, dnsRecords []string
+func (c CloudFlareDNS) SyncPods(ctx context.Context, pods []Pod) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.SyncPods")
+	defer func() { tracing.End(span, err) }()
+
+	var names, dnsRecords, failed []string
 	var txtRecordsFromPods []cloudflare.DNSRecord
 
 	// Setup the client
@@ -142,19 +290,19 @@ func (c CloudFlareDNS) SyncPods(pods []Pod) {
 	// The source of truth are the TXT records as they are created and deleted alongside 'A' records.
 	// The logical flow is the following:
 	// fetch txtRecords that have been created from a pod-sync operation --> indicator for this, is the existence of the `pod-sync=true` string on the txt data.
-	// save pod names that have the `casper-3.gather.town/sync: "true"` label.
-	// compare pod names with cNames --> if diff, then create dns records.
-	// compare cNames with pod names --> if diff, then delete the stale resources.
-	// compare pod names with existing txt records that have been created from a pod-sync operation --> if cname is equal to pod name, but the txtLabel has different assignedNode in comparison with the current pod assignedNode, then delete the outdated records and recreate them with proper configuration
+	// save pod hostnames of the pods that have the `casper-3.gather.town/sync: "true"` label.
+	// compare pod hostnames with cNames --> if diff, then create dns records.
+	// compare cNames with pod hostnames --> if diff, then delete the stale resources.
+	// compare pod hostnames with existing txt records that have been created from a pod-sync operation --> if cname is equal to pod hostname, but the txtLabel has different assignedNode in comparison with the current pod assignedNode, or the FQDN changed, then delete the outdated records and recreate them with proper configuration
 
 	recordType := "TXT"
 
-	// Fetch all TXT DNS that contain cluster's label
-	txtRecords, err := getRecordsPerTypePerContent(context.TODO(), client, cfg.Zone, recordType, label)
+	// Fetch all TXT DNS of the cluster's pods, their content doesn't contain the node label
+	txtRecords, err := getRecordsPerTypePerContent(ctx, client, cfg.Zone, recordType, podPrefix)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		logger.Info("Error occured while fetching records", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain)
-		return
+		metrics.ExecErrInc(err)
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", recordType, "error", err.Error())
+		return err
 	}
 
 	// Generate arrays
@@ -167,9 +315,22 @@ func (c CloudFlareDNS) SyncPods(pods []Pod) {
 			txtRecordsFromPods = append(txtRecordsFromPods, txtRecord)
 		}
 	}
+	owned := make([]common.Record, 0, len(txtRecordsFromPods))
+	for _, txt := range txtRecordsFromPods {
+		owned = append(owned, common.Record{Kind: "pod", FQDN: txt.Name, Type: txt.Type, Content: txt.Content})
+	}
+	zoneID, addresses, err := getAddressRecords(ctx, client)
+	if err != nil {
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", "A", "error", err.Error())
+		return err
+	}
+	c.observe("pod", owned, addresses)
+	metrics.DNSOwnedRecords(cfg.Provider, "pod", float64(len(txtRecordsFromPods)))
 
+	desired := make(map[string]Pod)
 	for _, pod := range pods {
-		names = append(names, pod.Name)
+		names = append(names, pod.Hostname)
+		desired[pod.Hostname] = pod
 	}
 	logger.Debug("Pods found", "pods", names)
 
@@ -178,27 +339,27 @@ func (c CloudFlareDNS) SyncPods(pods []Pod) {
 	if len(addEntries) > 0 {
 		logger.Info("Entries to be added", "entries", addEntries)
 		for _, name := range addEntries {
-			podName := ""
-			assignedNode := ""
-			addressIPv4 := ""
-
-			for _, pod := range pods {
-				if pod.Name == name {
-					podName = pod.Name
-					assignedNode = pod.AssignedNode.Name
-					addressIPv4 = pod.AssignedNode.ExternalIP
-				}
+			// Stop before starting a new record pair when shutting down
+			if err := ctx.Err(); err != nil {
+				return err
 			}
+			pod := desired[name]
+			addressIPv4 := pod.AssignedNode.ExternalIP
 
 			if addressIPv4 == "" {
-				logger.Info("IP address not found for entry", "name", name, "zone", cfg.Zone, "subdomain", cfg.Subdomain)
+				logger.Warn("IP address not found for entry", "record", name, "zone", cfg.Zone, "subdomain", pod.SubdomainOr(cfg.Subdomain), "action", "blocked")
+				c.Changes.Add(common.Change{Action: "blocked", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: optionsFQDN(pod.RecordOptions), Type: "A", Err: common.ErrNoAddress})
 			} else {
-				txtLabel := fmt.Sprintf("heritage=casper-3,pod-sync=true,environment=%s,podName=%s,assignedNode=%s,addressIPv4=%s", cfg.Env, podName, assignedNode, addressIPv4)
-				txtRecordName := podName
-				_, err := addRecord(context.TODO(), client, cfg.Zone, cfg.Subdomain, podName, addressIPv4, txtRecordName, txtLabel, cfg.Env)
+				txtLabel := podLabel(pod.Namespace, pod.Name, pod.AssignedNode.Name, addressIPv4)
+				pctx, cancel := common.WithGrace(ctx, c.ShutdownTimeout)
+				pctx = audit.WithObject(pctx, "pod", pod.Namespace, pod.Name)
+				ids, err := addRecord(pctx, client, cfg.Zone, optionsFQDN(pod.RecordOptions), addressIPv4, txtLabel, pod.TTLOr(defaultTTL), pod.ProxiedOr(isProxied(pod.Name)))
+				cancel()
+				c.Changes.Add(common.Change{Action: "create", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: optionsFQDN(pod.RecordOptions), Type: "A", Content: addressIPv4, RecordIDs: ids, Err: err})
 				if err != nil {
-					metrics.ExecErrInc(err.Error())
-					logger.Error("Error occured while adding records", "provider", cfg.Provider, "zone", cfg.Zone, "error", err.Error())
+					metrics.ExecErrInc(err)
+					failed = append(failed, name)
+					logger.Error("Error occured while adding records", "zone", cfg.Zone, "record", optionsFQDN(pod.RecordOptions), "type", "A", "error", err.Error())
 				}
 			}
 		}
@@ -208,61 +369,222 @@ func (c CloudFlareDNS) SyncPods(pods []Pod) {
 	deleteEntries := common.Compare(dnsRecords, names)
 	if len(deleteEntries) > 0 {
 		logger.Info("Entries to be deleted", "entries", deleteEntries)
-		for _, name := range deleteEntries {
+		for _, txt := range txtRecordsFromPods {
 			// The 'Name' entry is the FQDN
-			cName := fmt.Sprintf("%s.%s", name, cfg.Zone)
-			if cfg.Subdomain != "" {
-				cName = fmt.Sprintf("%s.%s.%s", name, cfg.Subdomain, cfg.Zone)
+			cName := txt.Name
+			if !contains(deleteEntries, strings.Split(cName, ".")[0]) {
+				continue
+			}
+			// Stop before starting a new record pair when shutting down
+			if err := ctx.Err(); err != nil {
+				return err
 			}
 			logger.Debug("Launching deletion", "record", cName)
-			_, err := deleteRecord(context.TODO(), client, cfg.Zone, cName)
+			pctx, cancel := common.WithGrace(ctx, c.ShutdownTimeout)
+			fields := common.ParseLabel(txt.Content)
+			pctx = audit.WithObject(pctx, "pod", fields["namespace"], fields["podName"])
+			_, err := deleteRecord(pctx, client, cfg.Zone, cName)
+			cancel()
+			c.Changes.Add(common.Change{Action: "delete", Kind: "pod", Namespace: fields["namespace"], Name: fields["podName"], FQDN: cName, Type: "A", Err: err})
 			if err != nil {
-				metrics.ExecErrInc(err.Error())
-				logger.Error("Error occured while fetching records", "provider", cfg.Provider, "zone", cfg.Zone, "error", err.Error())
+				metrics.ExecErrInc(err)
+				failed = append(failed, cName)
+				logger.Error("Error occured while fetching records", "zone", cfg.Zone, "error", err.Error())
 			}
 		}
 	}
 
-	// Detect if an already registered pod has been rescheduled on a different node and update records accordingly
+	// Detect if an already registered pod has been rescheduled on a different node, or moved to
+	// a different subdomain, and update records accordingly
+	moved := make(map[string]bool)
 	for _, pod := range pods {
-		podName := pod.Name
 		assignedNode := pod.AssignedNode.Name
 		addressIPv4 := pod.AssignedNode.ExternalIP
-		txtLabel := fmt.Sprintf("heritage=casper-3,pod-sync=true,environment=%s,podName=%s,assignedNode=%s,addressIPv4=%s", cfg.Env, podName, assignedNode, addressIPv4)
+		txtLabel := podLabel(pod.Namespace, pod.Name, assignedNode, addressIPv4)
+		fqdn := optionsFQDN(pod.RecordOptions)
 		for _, txt := range txtRecordsFromPods {
 			cName := strings.Split(txt.Name, ".")
 			txtData := fmt.Sprintf("%v", txt.Content) // convert interface{} to string
-			if cName[0] == podName && !strings.Contains(txtData, assignedNode) {
-				// then delete existing record and recreate new ones
-				logger.Debug("Found a pod with that might got rescheduled on a different node", podName)
-				cName := fmt.Sprintf("%s.%s", podName, cfg.Zone)
-				if cfg.Subdomain != "" {
-					cName = fmt.Sprintf("%s.%s.%s", podName, cfg.Subdomain, cfg.Zone)
+			if cName[0] == pod.Hostname && (!strings.Contains(txtData, assignedNode) || txt.Name != fqdn) {
+				// Stop before starting a new record pair when shutting down
+				if err := ctx.Err(); err != nil {
+					return err
 				}
-				logger.Debug("Launching deletion", "record", cName)
-				_, err := deleteRecord(context.TODO(), client, cfg.Zone, cName)
+				// then delete existing record and recreate new ones
+				moved[pod.Hostname] = true
+				logger.Debug("Found a pod that might have been rescheduled on a different node", "pod", pod.Name)
+				logger.Debug("Launching deletion", "record", txt.Name)
+				pctx, cancel := common.WithGrace(ctx, c.ShutdownTimeout)
+				pctx = audit.WithObject(pctx, "pod", pod.Namespace, pod.Name)
+				_, err := deleteRecord(pctx, client, cfg.Zone, txt.Name)
+				c.Changes.Add(common.Change{Action: "delete", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: txt.Name, Type: "A", Err: err})
 				if err != nil {
-					metrics.ExecErrInc(err.Error())
-					logger.Error("Error occured while deleting record", "provider", cfg.Provider, "zone", cfg.Zone, "error", err.Error())
+					metrics.ExecErrInc(err)
+					failed = append(failed, pod.Hostname)
+					logger.Error("Error occured while deleting record", "zone", cfg.Zone, "record", txt.Name, "type", "A", "error", err.Error())
+					// The move is retried by the next reconcile, a new pair would duplicate the old one
+					cancel()
+					continue
 				}
-				_, _err := addRecord(context.TODO(), client, cfg.Zone, cfg.Subdomain, podName, addressIPv4, podName, txtLabel, cfg.Env)
+				ids, _err := addRecord(pctx, client, cfg.Zone, fqdn, addressIPv4, txtLabel, pod.TTLOr(defaultTTL), pod.ProxiedOr(isProxied(pod.Name)))
+				cancel()
+				c.Changes.Add(common.Change{Action: "create", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: fqdn, Type: "A", Content: addressIPv4, RecordIDs: ids, Err: _err})
 				if _err != nil {
-					metrics.ExecErrInc(err.Error())
-					logger.Error("Error occured while adding record", "provider", cfg.Provider, "zone", cfg.Zone, "error", err.Error())
+					metrics.ExecErrInc(_err)
+					failed = append(failed, pod.Hostname)
+					logger.Error("Error occured while adding record", "zone", cfg.Zone, "record", fqdn, "type", "A", "error", _err.Error())
 				}
 			}
 		}
 	}
+	// Apply changed TTL and proxied annotations to the records of the other pods
+	for _, pod := range pods {
+		if contains(addEntries, pod.Hostname) || moved[pod.Hostname] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fqdn := optionsFQDN(pod.RecordOptions)
+		pctx, cancel := common.WithGrace(ctx, c.ShutdownTimeout)
+		pctx = audit.WithObject(pctx, "pod", pod.Namespace, pod.Name)
+		updated, err := updateRecordOptions(pctx, client, zoneID, addresses[fqdn], pod.TTLOr(defaultTTL), pod.ProxiedOr(isProxied(pod.Name)))
+		cancel()
+		if updated || err != nil {
+			c.Changes.Add(common.Change{Action: "update", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: fqdn, Type: "A", Content: pod.AssignedNode.ExternalIP, Err: err})
+		}
+		if err != nil {
+			failed = append(failed, pod.Hostname)
+			logger.Error("Error occured while updating record", "zone", cfg.Zone, "record", fqdn, "type", "A", "error", err.Error())
+		}
+	}
+
 	// Find kubernetes pods to register
-	return
+	return common.SyncErr(failed)
+}
+
+// Verify checks that the token can access the zone.
+func (d CloudFlareDNS) Verify(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.Verify")
+	defer func() { tracing.End(span, err) }()
+
+	client := NewCFClient()
+	if client == nil {
+		return errors.New("no Cloudflare client, see the previous errors")
+	}
+	_, err = zoneIDByName(ctx, client, cfg.Zone)
+	return err
 }
 
-func getRecordsPerTypePerContent(ctx context.Context, client *cloudflare.API, zone string, recordType string, contentLabel string) ([]cloudflare.DNSRecord, error) {
+// DeletePod removes the record pair of a terminating pod, if its TXT record
+// marks it as created for that pod.
+func (d CloudFlareDNS) DeletePod(ctx context.Context, pod Pod) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.DeletePod")
+	defer func() { tracing.End(span, err) }()
+
+	// Setup the client
+	client := NewCFClient()
+
+	zoneID, err := zoneIDByName(ctx, client, cfg.Zone)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+
+	fqdn := optionsFQDN(pod.RecordOptions)
+	txtRecords, err := client.DNSRecords(ctx, zoneID, cloudflare.DNSRecord{Name: fqdn, Type: "TXT"})
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+
+	for _, txt := range txtRecords {
+		fields := common.ParseLabel(txt.Content)
+		// The records of older releases carry no namespace
+		if !common.IsOwned(txt.Content, cfg.Env) || common.LabelKind(txt.Content) != "pod" || fields["podName"] != pod.Name || (fields["namespace"] != "" && fields["namespace"] != pod.Namespace) {
+			continue
+		}
+		logger.Info("Deleting records of terminating pod", "zone", cfg.Zone, "record", fqdn, "pod", pod.Namespace+"/"+pod.Name, "action", "delete")
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		pctx = audit.WithObject(pctx, "pod", pod.Namespace, pod.Name)
+		defer cancel()
+		_, err := deleteRecord(pctx, client, cfg.Zone, fqdn)
+		d.Changes.Add(common.Change{Action: "delete", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: fqdn, Type: "A", Err: err})
+		return err
+	}
+	logger.Debug("No records found for terminating pod", "record", fqdn, "pod", pod.Namespace+"/"+pod.Name)
+	return nil
+}
+
+// observe sets the owned TXT records of kind, and the A records of their names
+// among addresses, on d.Observed.
+func (d CloudFlareDNS) observe(kind string, owned []common.Record, addresses map[string][]cloudflare.DNSRecord) {
+	if d.Observed == nil {
+		return
+	}
+	names := make([]string, 0, len(owned))
+	for _, record := range owned {
+		names = append(names, record.FQDN)
+	}
+	for _, name := range names {
+		for _, record := range addresses[name] {
+			owned = append(owned, common.Record{Kind: kind, FQDN: record.Name, Type: record.Type, Content: record.Content})
+		}
+	}
+	d.Observed.Set(kind, owned)
+}
+
+// getAddressRecords returns the ID of the zone and its A records by name.
+func getAddressRecords(ctx context.Context, client *cloudflare.API) (string, map[string][]cloudflare.DNSRecord, error) {
+	zoneID, err := zoneIDByName(ctx, client, cfg.Zone)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return "", nil, err
+	}
+	records, err := getRecordsPerType(ctx, client, zoneID, "A")
+	if err != nil {
+		return "", nil, err
+	}
+	addresses := make(map[string][]cloudflare.DNSRecord)
+	for _, record := range records {
+		addresses[record.Name] = append(addresses[record.Name], record)
+	}
+	return zoneID, addresses, nil
+}
+
+// updateRecordOptions sets the TTL and proxy status of records, the A records
+// of a node or pod, if they differ. It reports whether records were updated.
+func updateRecordOptions(ctx context.Context, client *cloudflare.API, zoneID string, records []cloudflare.DNSRecord, ttl int, proxied bool) (updated bool, err error) {
+	for _, record := range records {
+		// Cloudflare sets the TTL of proxied records to 1, i.e. automatic
+		wasProxied := record.Proxied != nil && *record.Proxied
+		if wasProxied == proxied && (proxied || record.TTL == ttl) {
+			continue
+		}
+		before := audit.Value{Content: record.Content, TTL: record.TTL}
+		record.TTL = ttl
+		record.Proxied = &proxied
+		err := client.UpdateDNSRecord(ctx, zoneID, record.ID, record)
+		metrics.RecordOperation(cfg.Provider, record.Type, "update", err)
+		audit.Record(ctx, audit.Entry{Action: "update", Record: record.Name, Type: record.Type, Before: &before, After: &audit.Value{Content: record.Content, TTL: ttl}}, err)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return updated, err
+		}
+		logger.Info("Updated record", "zone", cfg.Zone, "record", record.Name, "type", record.Type, "content", record.Content, "proxied", proxied, "ttl", ttl, "action", "update")
+		updated = true
+	}
+	return updated, nil
+}
+
+func getRecordsPerTypePerContent(ctx context.Context, client *cloudflare.API, zone string, recordType string, contentLabel string) (_ []cloudflare.DNSRecord, err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.DNSRecords")
+	defer func() { tracing.End(span, err) }()
 
 	// Get ZoneID
-	zoneID, err := client.ZoneIDByName(zone)
+	zoneID, err := zoneIDByName(ctx, client, zone)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return nil, err
 	}
 
@@ -271,7 +593,7 @@ func getRecordsPerTypePerContent(ctx context.Context, client *cloudflare.API, zo
 	record := cloudflare.DNSRecord{Type: recordType, Content: "contains:" + contentLabel}
 	records, err := client.DNSRecords(ctx, zoneID, record)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return nil, err
 	}
 
@@ -279,27 +601,30 @@ func getRecordsPerTypePerContent(ctx context.Context, client *cloudflare.API, zo
 	return records, err
 }
 
-func deleteRecord(ctx context.Context, client *cloudflare.API, zone string, fqdn string) (bool, error) {
+func deleteRecord(ctx context.Context, client *cloudflare.API, zone string, fqdn string) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.deleteRecord", attribute.String("record", fqdn))
+	defer func() { tracing.End(span, err) }()
+
 	// Get ZoneID
-	zoneID, err := client.ZoneIDByName(zone)
+	zoneID, err := zoneIDByName(ctx, client, zone)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return false, err
 	}
 
-	logger.Debug("Deleting", "FQDN", fqdn)
+	logger.Debug("Deleting", "record", fqdn)
 
 	txtRecord := cloudflare.DNSRecord{Name: fqdn, Type: "TXT"}
 	txtRecords, err := client.DNSRecords(ctx, zoneID, txtRecord)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return false, err
 	}
 
 	aRecord := cloudflare.DNSRecord{Name: fqdn, Type: "A"}
 	aRecords, err := client.DNSRecords(ctx, zoneID, aRecord)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return false, err
 	}
 
@@ -309,11 +634,13 @@ func deleteRecord(ctx context.Context, client *cloudflare.API, zone string, fqdn
 		// validate record to be deleted. Only records with name same as the fqdn input and type `TXT` or `A` are allowed to be deleted
 		if record.Name == fqdn && (record.Type == "TXT" || record.Type == "A") {
 			err := client.DeleteDNSRecord(ctx, zoneID, record.ID)
+			metrics.RecordOperation(cfg.Provider, record.Type, "delete", err)
+			audit.Record(ctx, audit.Entry{Action: "delete", Record: record.Name, Type: record.Type, Before: &audit.Value{Content: record.Content, TTL: record.TTL}}, err)
 			if err != nil {
-				metrics.ExecErrInc(err.Error())
+				metrics.ExecErrInc(err)
 				return false, err
 			}
-			logger.Info("Deleted DNS record", "zone", zone, "record", record.Name, "type", record.Type)
+			logger.Info("Deleted DNS record", "zone", zone, "record", record.Name, "type", record.Type, "action", "delete")
 		} else {
 			err := fmt.Errorf("deleteRecord() wants to delete wrong record. Record Name: %v Record Type: %v", record.Name, record.Type)
 			return false, err
@@ -322,86 +649,97 @@ func deleteRecord(ctx context.Context, client *cloudflare.API, zone string, fqdn
 	return true, nil
 }
 
-func addRecord(ctx context.Context, client *cloudflare.API, zone string, subdomain string, name string, addressIPv4 string, txtRecordName string, txtLabel string, env string) (bool, error) {
-	// Construct FQDN by populating 'name' field: sfu-123 vs sfu-123.region-a.env.cloud
-	sName := name
-
-	if txtRecordName == "" {
-		txtRecordName = name
-	}
+func addRecord(ctx context.Context, client *cloudflare.API, zone string, fqdn string, addressIPv4 string, txtLabel string, ttl int, proxied bool) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.addRecord", attribute.String("record", fqdn))
+	defer func() { tracing.End(span, err) }()
 
 	if txtLabel == "" {
 		txtLabel = label
 	}
 
-	if subdomain != "" {
-		sName = fmt.Sprintf("%s.%s", name, subdomain)
-		txtRecordName = fmt.Sprintf("%s.%s", txtRecordName, subdomain)
-	}
-
 	// Get ZoneID
-	zoneID, err := client.ZoneIDByName(zone)
+	zoneID, err := zoneIDByName(ctx, client, zone)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		return false, err
+		metrics.ExecErrInc(err)
+		return nil, err
 	}
 
 	txtRecordRequest := cloudflare.DNSRecord{
 		Type:    "TXT",
-		Name:    txtRecordName,
+		Name:    fqdn,
 		Content: txtLabel,
-		TTL:     1800,
+		TTL:     ttl,
 	}
 
-	logger.Info("trying to add record", "zone", zone, "name", txtRecordName, "type", "TXT")
+	logger.Info("trying to add record", "zone", zone, "record", fqdn, "type", "TXT", "action", "create")
 	txtRecord, err := client.CreateDNSRecord(ctx, zoneID, txtRecordRequest)
+	metrics.RecordOperation(cfg.Provider, "TXT", "create", err)
+	audit.Record(ctx, audit.Entry{Action: "create", Record: fqdn, Type: "TXT", After: &audit.Value{Content: txtLabel, TTL: ttl}}, err)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		return false, err
-	}
-
-	proxied := false
-	for _, p := range cfg.CloudflareProxiedNodePools {
-		if strings.HasPrefix(name, p) {
-			proxied = true
-			break
-		}
+		metrics.ExecErrInc(err)
+		return nil, err
 	}
 
-	logger.Info("Added DNS record", "zone", zone, "name", sName, "type", "TXT", "success", txtRecord.Success)
+	logger.Info("Added DNS record", "zone", zone, "record", fqdn, "type", "TXT", "success", txtRecord.Success, "action", "create")
 
 	aRecordRequest := cloudflare.DNSRecord{
 		Type:    "A",
-		Name:    sName,
+		Name:    fqdn,
 		Content: addressIPv4,
-		TTL:     1800,
+		TTL:     ttl,
 		Proxied: &proxied,
 	}
 
-	logger.Info("trying to add record", "zone", zone, "name", sName, "type", "A")
+	logger.Info("trying to add record", "zone", zone, "record", fqdn, "type", "A", "action", "create")
 	aRecord, err := client.CreateDNSRecord(ctx, zoneID, aRecordRequest)
+	metrics.RecordOperation(cfg.Provider, "A", "create", err)
+	audit.Record(ctx, audit.Entry{Action: "create", Record: fqdn, Type: "A", After: &audit.Value{Content: addressIPv4, TTL: ttl}}, err)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		return false, err
+		metrics.ExecErrInc(err)
+		// Roll back the TXT record so that the name is not considered published.
+		// ctx may already be cancelled, hence the separate context.
+		rctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+		defer cancel()
+		rerr := client.DeleteDNSRecord(rctx, zoneID, txtRecord.Result.ID)
+		metrics.RecordOperation(cfg.Provider, "TXT", "delete", rerr)
+		audit.Record(ctx, audit.Entry{Action: "delete", Record: fqdn, Type: "TXT", Before: &audit.Value{Content: txtLabel, TTL: ttl}}, rerr)
+		if rerr != nil {
+			metrics.ExecErrInc(rerr)
+			logger.Error("Error occured while rolling back record", "zone", zone, "record", fqdn, "type", "TXT", "error", rerr.Error())
+		}
+		return nil, err
 	}
-	logger.Info("Added record", "zone", zone, "name", sName, "type", "A", "success", aRecord.Success, "content", addressIPv4, "proxied", proxied)
+	logger.Info("Added record", "zone", zone, "record", fqdn, "type", "A", "success", aRecord.Success, "content", addressIPv4, "proxied", proxied, "ttl", ttl, "action", "create")
+
+	return []string{txtRecord.Result.ID, aRecord.Result.ID}, nil
+}
 
-	return true, err
+// isProxied reports whether the A record of name belongs to a node pool that
+// is proxied through Cloudflare.
+func isProxied(name string) bool {
+	for _, p := range cfg.CloudflareProxiedNodePools {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
 }
 
-func getAllRecords(ctx context.Context, client *cloudflare.API, zone string) (float64, error) {
+func getAllRecords(ctx context.Context, client *cloudflare.API, zone string) (_ float64, err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.getAllRecords")
+	defer func() { tracing.End(span, err) }()
 
 	// Get ZoneID
-	zoneID, err := client.ZoneIDByName(zone)
+	zoneID, err := zoneIDByName(ctx, client, zone)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return 0.0, err
 	}
 
 	record := cloudflare.DNSRecord{}
 	records, err := client.DNSRecords(ctx, zoneID, record)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return 0.0, err
 	}
 	return float64(len(records)), err
@@ -0,0 +1,317 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	common "github.com/gathertown/casper-3/pkg"
+)
+
+const fakeZoneID = "023e105f4ecef8ad9ca31a8372d0c353"
+
+// fakeAPI is an in-memory Cloudflare API serving the records of cfg.Zone.
+type fakeAPI struct {
+	mu      sync.Mutex
+	records []cloudflare.DNSRecord
+	nextID  int
+	// fail, if set, fails the creates of the records it returns true for.
+	fail func(cloudflare.DNSRecord) bool
+	// failDelete, if set, fails the deletes of the records it returns true for.
+	failDelete func(cloudflare.DNSRecord) bool
+}
+
+// newFakeAPI returns a fake API with records, used by the clients until the
+// test ends.
+func newFakeAPI(t *testing.T, records ...cloudflare.DNSRecord) *fakeAPI {
+	t.Helper()
+	f := &fakeAPI{}
+	for _, r := range records {
+		f.create(r)
+	}
+	server := httptest.NewServer(f)
+	clientOptions = []cloudflare.Option{cloudflare.BaseURL(server.URL), cloudflare.UsingRateLimit(1000)}
+	t.Cleanup(func() {
+		clientOptions = nil
+		server.Close()
+	})
+	return f
+}
+
+func (f *fakeAPI) create(r cloudflare.DNSRecord) cloudflare.DNSRecord {
+	f.nextID++
+	r.ID = fmt.Sprintf("%d", f.nextID)
+	f.records = append(f.records, r)
+	return r
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/zones":
+		respond(w, http.StatusOK, []cloudflare.Zone{{ID: fakeZoneID, Name: cfg.Zone}})
+	case len(parts) == 3 && parts[1] == fakeZoneID && parts[2] == "dns_records":
+		switch r.Method {
+		case http.MethodGet:
+			respond(w, http.StatusOK, f.list(r.URL.Query()))
+		case http.MethodPost:
+			var record cloudflare.DNSRecord
+			_ = json.NewDecoder(r.Body).Decode(&record)
+			if f.fail != nil && f.fail(record) {
+				respond(w, http.StatusBadRequest, nil)
+				return
+			}
+			respond(w, http.StatusOK, f.create(record))
+		}
+	case len(parts) == 4 && parts[1] == fakeZoneID && parts[2] == "dns_records":
+		for i, record := range f.records {
+			if record.ID != parts[3] {
+				continue
+			}
+			switch r.Method {
+			case http.MethodDelete:
+				if f.failDelete != nil && f.failDelete(record) {
+					respond(w, http.StatusBadRequest, nil)
+					return
+				}
+				f.records = append(f.records[:i], f.records[i+1:]...)
+			case http.MethodPatch:
+				_ = json.NewDecoder(r.Body).Decode(&f.records[i])
+				f.records[i].ID = record.ID
+			}
+			respond(w, http.StatusOK, record)
+			return
+		}
+		respond(w, http.StatusNotFound, nil)
+	default:
+		respond(w, http.StatusNotFound, nil)
+	}
+}
+
+// list returns the records matching the name, type and content of query.
+func (f *fakeAPI) list(query map[string][]string) []cloudflare.DNSRecord {
+	get := func(key string) string {
+		if v := query[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	records := []cloudflare.DNSRecord{}
+	for _, record := range f.records {
+		if name := get("name"); name != "" && name != record.Name {
+			continue
+		}
+		if recordType := get("type"); recordType != "" && recordType != record.Type {
+			continue
+		}
+		if content := get("content"); content != "" {
+			if !strings.HasPrefix(content, "contains:") && content != record.Content {
+				continue
+			}
+			if !strings.Contains(record.Content, strings.TrimPrefix(content, "contains:")) {
+				continue
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// zone returns the records as sorted "name type content" lines.
+func (f *fakeAPI) zone() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var lines []string
+	for _, r := range f.records {
+		lines = append(lines, fmt.Sprintf("%s %s %s", r.Name, r.Type, r.Content))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func respond(w http.ResponseWriter, code int, result interface{}) {
+	body := map[string]interface{}{"success": code == http.StatusOK, "errors": []interface{}{}, "messages": []interface{}{}, "result": result}
+	if code != http.StatusOK {
+		body["errors"] = []interface{}{map[string]interface{}{"code": 1004, "message": http.StatusText(code)}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func node(name string, ip string) Node {
+	return Node{Name: name, ExternalIP: ip, RecordOptions: common.RecordOptions{Hostname: name}}
+}
+
+func TestSync(t *testing.T) {
+	f := newFakeAPI(t,
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("sfu-8quob"), Content: label},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("sfu-8quob"), Content: "1.1.1.2"},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("www"), Content: "1.1.1.9"},
+	)
+
+	changes := &common.Changes{}
+	d := CloudFlareDNS{Changes: changes, Observed: &common.Observed{}}
+	if err := d.Sync(context.TODO(), []Node{node("sfu-8mh0d", "1.1.1.1")}); err != nil {
+		t.Fatalf("Sync() returned error: %v", err)
+	}
+
+	want := []string{
+		recordFQDN("sfu-8mh0d") + " A 1.1.1.1",
+		recordFQDN("sfu-8mh0d") + " TXT " + nodeLabel("sfu-8mh0d"),
+		recordFQDN("www") + " A 1.1.1.9",
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+	if got := changes.Drain(); len(got) != 2 || got[0].Action != "create" || got[1].Action != "delete" {
+		t.Errorf("Expecting a create and a delete, got %+v", got)
+	}
+	// The A records of the owned names are observed, as found before the sync
+	observed := d.Observed.Drain()["node"]
+	if len(observed) != 2 || observed[1].Type != "A" || observed[1].Content != "1.1.1.2" {
+		t.Errorf("Expecting the TXT and A record of sfu-8quob, got %+v", observed)
+	}
+}
+
+func TestSyncContinuesAfterFailure(t *testing.T) {
+	f := newFakeAPI(t)
+	f.fail = func(r cloudflare.DNSRecord) bool { return r.Name == recordFQDN("sfu-8mh0d") }
+
+	d := CloudFlareDNS{Changes: &common.Changes{}}
+	err := d.Sync(context.TODO(), []Node{node("sfu-8mh0d", "1.1.1.1"), node("sfu-8quob", "1.1.1.2")})
+
+	var se *common.SyncError
+	if !errors.As(err, &se) || len(se.Records) != 1 || se.Records[0] != "sfu-8mh0d" {
+		t.Errorf("Expecting sfu-8mh0d to fail, got %v", err)
+	}
+	want := []string{
+		recordFQDN("sfu-8quob") + " A 1.1.1.2",
+		recordFQDN("sfu-8quob") + " TXT " + nodeLabel("sfu-8quob"),
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+}
+
+func TestSyncPodsRetriesMove(t *testing.T) {
+	txt := podLabel("default", "router-0", "node-a", "1.1.1.1")
+	f := newFakeAPI(t,
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("router-0"), Content: txt},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("router-0"), Content: "1.1.1.1"},
+	)
+	f.failDelete = func(r cloudflare.DNSRecord) bool { return true }
+
+	pod := Pod{Name: "router-0", Namespace: "default", AssignedNode: node("node-b", "1.1.1.2"), RecordOptions: common.RecordOptions{Hostname: "router-0"}}
+	d := CloudFlareDNS{Changes: &common.Changes{}}
+	if err := d.SyncPods(context.TODO(), []Pod{pod}); err == nil {
+		t.Errorf("Expecting the move of router-0 to fail")
+	}
+	// The old pair is kept until a later reconcile deletes it, without a second pair
+	want := []string{
+		recordFQDN("router-0") + " A 1.1.1.1",
+		recordFQDN("router-0") + " TXT " + txt,
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+
+	f.failDelete = nil
+	if err := d.SyncPods(context.TODO(), []Pod{pod}); err != nil {
+		t.Fatalf("SyncPods() returned error: %v", err)
+	}
+	want = []string{
+		recordFQDN("router-0") + " A 1.1.1.2",
+		recordFQDN("router-0") + " TXT " + podLabel("default", "router-0", "node-b", "1.1.1.2"),
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+}
+
+func TestSyncUpdatesRecordOptions(t *testing.T) {
+	f := newFakeAPI(t,
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("sfu-8mh0d"), Content: label},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("sfu-8mh0d"), Content: "1.1.1.1", TTL: defaultTTL},
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("router-0"), Content: podLabel("default", "router-0", "sfu-8mh0d", "1.1.1.1")},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("router-0"), Content: "1.1.1.1", TTL: defaultTTL},
+	)
+
+	changes := &common.Changes{}
+	d := CloudFlareDNS{Changes: changes}
+	n := node("sfu-8mh0d", "1.1.1.1")
+	n.TTL = 60
+	if err := d.Sync(context.TODO(), []Node{n}); err != nil {
+		t.Fatalf("Sync() returned error: %v", err)
+	}
+	proxied := true
+	pod := Pod{Name: "router-0", Namespace: "default", AssignedNode: node("sfu-8mh0d", "1.1.1.1"), RecordOptions: common.RecordOptions{Hostname: "router-0", Proxied: &proxied}}
+	if err := d.SyncPods(context.TODO(), []Pod{pod}); err != nil {
+		t.Fatalf("SyncPods() returned error: %v", err)
+	}
+
+	for _, record := range f.records {
+		switch {
+		case record.Type != "A":
+		case record.Name == recordFQDN("sfu-8mh0d") && record.TTL != 60:
+			t.Errorf("Expecting TTL 60 for %s, got %d", record.Name, record.TTL)
+		case record.Name == recordFQDN("router-0") && (record.Proxied == nil || !*record.Proxied):
+			t.Errorf("Expecting %s to be proxied", record.Name)
+		}
+	}
+	if got := changes.Drain(); len(got) != 2 || got[0].Action != "update" || got[1].Action != "update" {
+		t.Errorf("Expecting two updates, got %+v", got)
+	}
+
+	// Records that match their annotations are left alone
+	if err := d.Sync(context.TODO(), []Node{n}); err != nil {
+		t.Fatalf("Sync() returned error: %v", err)
+	}
+	if err := d.SyncPods(context.TODO(), []Pod{pod}); err != nil {
+		t.Fatalf("SyncPods() returned error: %v", err)
+	}
+	if got := changes.Drain(); len(got) != 0 {
+		t.Errorf("Expecting no changes, got %+v", got)
+	}
+}
+
+func TestSyncReportsObjects(t *testing.T) {
+	newFakeAPI(t,
+		// A cordoned node, its record name is not the name of the node
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("ip-10-0-0-1"), Content: nodeLabel("ip-10-0-0-1.ec2.internal")},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("ip-10-0-0-1"), Content: "1.1.1.1"},
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("router-1"), Content: podLabel("media", "router-1", "ip-10-0-0-1.ec2.internal", "1.1.1.1")},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("router-1"), Content: "1.1.1.1"},
+	)
+
+	changes := &common.Changes{}
+	d := CloudFlareDNS{Changes: changes}
+	if err := d.Sync(context.TODO(), []Node{node("ip-10-0-0-2", "1.1.1.2")}); err != nil {
+		t.Fatalf("Sync() returned error: %v", err)
+	}
+	if err := d.SyncPods(context.TODO(), nil); err != nil {
+		t.Fatalf("SyncPods() returned error: %v", err)
+	}
+
+	var deleted []string
+	for _, c := range changes.Drain() {
+		if c.Action == "delete" {
+			deleted = append(deleted, c.Kind+" "+c.Namespace+"/"+c.Name)
+		}
+	}
+	want := []string{"node /ip-10-0-0-1.ec2.internal", "pod media/router-1"}
+	if strings.Join(deleted, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting deletes of %q, got %q", want, deleted)
+	}
+}
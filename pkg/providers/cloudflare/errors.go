@@ -0,0 +1,40 @@
+package cloudflare
+
+import (
+	"errors"
+	"net/http"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/gathertown/casper-3/internal/metrics"
+)
+
+func init() {
+	metrics.RegisterStatusCode(statusCode)
+}
+
+// statusCode returns the HTTP status code of an error of the Cloudflare API,
+// or 0 if unknown. The errors of cloudflare-go carry their kind rather than
+// the code.
+func statusCode(err error) int {
+	var authorization *cloudflare.AuthorizationError
+	var authentication *cloudflare.AuthenticationError
+	var notFound *cloudflare.NotFoundError
+	var rateLimit *cloudflare.RatelimitError
+	var service *cloudflare.ServiceError
+	var request *cloudflare.RequestError
+	switch {
+	case errors.As(err, &authorization):
+		return http.StatusUnauthorized
+	case errors.As(err, &authentication):
+		return http.StatusForbidden
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.As(err, &rateLimit):
+		return http.StatusTooManyRequests
+	case errors.As(err, &service):
+		return http.StatusInternalServerError
+	case errors.As(err, &request):
+		return http.StatusBadRequest
+	}
+	return 0
+}
@@ -0,0 +1,31 @@
+package cloudflare
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/gathertown/casper-3/internal/metrics"
+)
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"unauthorized", &cloudflare.AuthorizationError{}, metrics.CategoryUnauthorized},
+		{"rate limited", &cloudflare.RatelimitError{}, metrics.CategoryRateLimited},
+		{"unavailable", fmt.Errorf("adding record: %w", &cloudflare.ServiceError{}), metrics.CategoryUnavailable},
+		{"not found", &cloudflare.NotFoundError{}, metrics.CategoryNotFound},
+		{"other", errors.New("deleteRecord() wants to delete wrong record"), metrics.CategoryOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metrics.ErrorCategory(tt.err); got != tt.want {
+				t.Errorf("Expecting category %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
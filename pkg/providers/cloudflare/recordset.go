@@ -0,0 +1,299 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
+	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/audit"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type RecordSet = common.RecordSet
+
+// SyncRecordSets publishes the record sets of the given kind, e.g. "service",
+// and removes the owned record sets of that kind that are no longer wanted.
+// The targets of a record set are compared with the records in the zone, so
+// that targets joining and leaving are updated in place. The records of kind
+// are set on d.Observed as they are once synced.
+func (d CloudFlareDNS) SyncRecordSets(ctx context.Context, kind string, sets []RecordSet) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.SyncRecordSets")
+	defer func() { tracing.End(span, err) }()
+
+	var failed []string
+	var observed []common.Record
+
+	// Setup the client
+	client := NewCFClient()
+
+	zoneID, err := zoneIDByName(ctx, client, cfg.Zone)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		logger.Error("Error occured while fetching zone", "zone", cfg.Zone, "error", err.Error())
+		return err
+	}
+
+	// The source of truth are the TXT records of the record sets of this kind.
+	txtRecords, err := getRecordsPerType(ctx, client, zoneID, "TXT")
+	if err != nil {
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", "TXT", "error", err.Error())
+		return err
+	}
+
+	owned := make(map[string]cloudflare.DNSRecord)
+	for _, record := range txtRecords {
+		if common.IsOwned(record.Content, cfg.Env) && common.LabelKind(record.Content) == kind {
+			owned[record.Name] = record
+		}
+	}
+
+	desired := make(map[string]RecordSet)
+	for _, set := range sets {
+		desired[recordFQDN(set.TXTName())] = set
+	}
+	metrics.DNSOwnedRecords(cfg.Provider, kind, float64(len(owned)))
+	logger.Debug("Record sets found", "kind", kind, "sets", len(sets), "owned", len(owned))
+
+	// Remove stale record sets first, a CNAME record may replace the A records of a name.
+	for txtName, txt := range owned {
+		if _, found := desired[txtName]; found {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		recordType := common.ParseLabel(txt.Content)["type"]
+		name := common.RecordSetName(strings.TrimSuffix(txtName, recordFQDN("")), recordType)
+		logger.Info("Deleting record set", "zone", cfg.Zone, "kind", kind, "record", recordFQDN(name), "type", recordType, "action", "delete")
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		pctx = audit.WithObject(pctx, "", "", common.ParseLabel(txt.Content)["owner"])
+		err := deleteRecordSet(pctx, client, zoneID, recordFQDN(name), recordType, txt)
+		cancel()
+		if err != nil {
+			metrics.ExecErrInc(err)
+			failed = append(failed, name)
+			logger.Error("Error occured while deleting record set", "zone", cfg.Zone, "kind", kind, "record", recordFQDN(name), "error", err.Error())
+			observed = append(observed, common.Record{Kind: kind, FQDN: txt.Name, Type: txt.Type, Content: txt.Content})
+		}
+	}
+
+	for _, txtName := range sortedKeys(desired) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		set := desired[txtName]
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		pctx = audit.WithObject(pctx, "", "", set.Owner)
+		txt, found := owned[txtName]
+		err := syncRecordSet(pctx, client, zoneID, kind, set, txt, found)
+		cancel()
+		if err != nil {
+			metrics.ExecErrInc(err)
+			failed = append(failed, set.Name)
+			logger.Error("Error occured while syncing record set", "zone", cfg.Zone, "kind", kind, "record", recordFQDN(set.Name), "error", err.Error())
+		}
+		if d.Observed != nil {
+			observed = append(observed, recordSetRecords(ctx, client, zoneID, kind, set, err == nil)...)
+		}
+	}
+	d.Observed.Set(kind, observed)
+
+	return common.SyncErr(failed)
+}
+
+// syncRecordSet creates the ownership TXT record of set, if it is not found
+// and the name is free, and then adds, updates and removes records so that the
+// zone matches its targets and TTL.
+func syncRecordSet(ctx context.Context, client *cloudflare.API, zoneID string, kind string, set RecordSet, txt cloudflare.DNSRecord, found bool) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.syncRecordSet", attribute.String("record", set.Name), attribute.String("type", set.Type))
+	defer func() { tracing.End(span, err) }()
+
+	fqdn := recordFQDN(set.Name)
+	txtLabel := common.RecordSetLabel(kind, cfg.Env, set)
+
+	// The TXT record goes first, so that records are never created without an owner.
+	if !found {
+		if err := checkNameFree(ctx, client, zoneID, fqdn); err != nil {
+			return err
+		}
+		if err := createRecord(ctx, client, zoneID, "TXT", recordFQDN(set.TXTName()), txtLabel, defaultTTL, false); err != nil {
+			return err
+		}
+	} else if txt.Content != txtLabel {
+		before := audit.Value{Content: txt.Content, TTL: txt.TTL}
+		txt.Content = txtLabel
+		err := client.UpdateDNSRecord(ctx, zoneID, txt.ID, txt)
+		metrics.RecordOperation(cfg.Provider, "TXT", "update", err)
+		audit.Record(ctx, audit.Entry{Action: "update", Record: txt.Name, Type: "TXT", Before: &before, After: &audit.Value{Content: txt.Content, TTL: txt.TTL}}, err)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return err
+		}
+		logger.Info("Updated record", "zone", cfg.Zone, "record", txt.Name, "type", "TXT", "content", txtLabel, "action", "update")
+	}
+
+	existing, err := client.DNSRecords(ctx, zoneID, cloudflare.DNSRecord{Name: fqdn, Type: set.Type})
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+
+	ttl := set.TTLOr(defaultTTL)
+	current := make(map[string]bool)
+	for _, record := range existing {
+		current[record.Content] = true
+	}
+	var missing []string
+	for _, target := range set.Targets {
+		if !current[target] {
+			missing = append(missing, target)
+		}
+	}
+	for _, record := range existing {
+		before := audit.Value{Content: record.Content, TTL: record.TTL}
+		if !contains(set.Targets, record.Content) {
+			// Point a stale record to a missing target rather than adding one,
+			// a name can't have two CNAME records, not even for a moment
+			if len(missing) == 0 {
+				continue
+			}
+			record.Content, missing = missing[0], missing[1:]
+		} else if record.TTL == ttl {
+			continue
+		}
+		record.TTL = ttl
+		err := client.UpdateDNSRecord(ctx, zoneID, record.ID, record)
+		metrics.RecordOperation(cfg.Provider, record.Type, "update", err)
+		audit.Record(ctx, audit.Entry{Action: "update", Record: record.Name, Type: record.Type, Before: &before, After: &audit.Value{Content: record.Content, TTL: ttl}}, err)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return err
+		}
+		logger.Info("Updated record", "zone", cfg.Zone, "record", record.Name, "type", record.Type, "content", record.Content, "ttl", ttl, "action", "update")
+	}
+	for _, target := range missing {
+		if err := createRecord(ctx, client, zoneID, set.Type, fqdn, target, ttl, false); err != nil {
+			return err
+		}
+	}
+
+	return deleteRecordsPerType(ctx, client, zoneID, fqdn, set.Type, set.Targets)
+}
+
+// recordSetRecords returns the records of set in the zone once synced. They
+// are fetched if the sync failed.
+func recordSetRecords(ctx context.Context, client *cloudflare.API, zoneID string, kind string, set RecordSet, synced bool) []common.Record {
+	fqdn := recordFQDN(set.Name)
+	if synced {
+		records := []common.Record{{Kind: kind, FQDN: recordFQDN(set.TXTName()), Type: "TXT", Content: common.RecordSetLabel(kind, cfg.Env, set)}}
+		for _, target := range set.Targets {
+			records = append(records, common.Record{Kind: kind, FQDN: fqdn, Type: set.Type, Content: target})
+		}
+		return records
+	}
+
+	var records []common.Record
+	for _, lookup := range []cloudflare.DNSRecord{{Name: recordFQDN(set.TXTName()), Type: "TXT"}, {Name: fqdn, Type: set.Type}} {
+		existing, err := client.DNSRecords(ctx, zoneID, lookup)
+		if err != nil {
+			logger.Warn("Error occured while fetching records", "zone", cfg.Zone, "record", lookup.Name, "type", lookup.Type, "error", err.Error())
+			continue
+		}
+		for _, record := range existing {
+			if record.Type == "TXT" && !(common.IsOwned(record.Content, cfg.Env) && common.LabelKind(record.Content) == kind) {
+				continue
+			}
+			records = append(records, common.Record{Kind: kind, FQDN: record.Name, Type: record.Type, Content: record.Content})
+		}
+	}
+	return records
+}
+
+// checkNameFree returns common.ErrNameTaken if fqdn has A or CNAME records,
+// e.g. added by hand or published for a node, that a new record set would
+// take over.
+func checkNameFree(ctx context.Context, client *cloudflare.API, zoneID string, fqdn string) error {
+	for _, recordType := range []string{"A", "CNAME"} {
+		records, err := client.DNSRecords(ctx, zoneID, cloudflare.DNSRecord{Name: fqdn, Type: recordType})
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return err
+		}
+		if len(records) > 0 {
+			return fmt.Errorf("%s %s: %w", fqdn, recordType, common.ErrNameTaken)
+		}
+	}
+	return nil
+}
+
+// deleteRecordSet removes all records of a record set and then its TXT record.
+func deleteRecordSet(ctx context.Context, client *cloudflare.API, zoneID string, fqdn string, recordType string, txt cloudflare.DNSRecord) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.deleteRecordSet", attribute.String("record", fqdn), attribute.String("type", recordType))
+	defer func() { tracing.End(span, err) }()
+
+	if err := deleteRecordsPerType(ctx, client, zoneID, fqdn, recordType, nil); err != nil {
+		return err
+	}
+	err = client.DeleteDNSRecord(ctx, zoneID, txt.ID)
+	metrics.RecordOperation(cfg.Provider, "TXT", "delete", err)
+	audit.Record(ctx, audit.Entry{Action: "delete", Record: txt.Name, Type: "TXT", Before: &audit.Value{Content: txt.Content, TTL: txt.TTL}}, err)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+	logger.Info("Deleted DNS record", "zone", cfg.Zone, "record", txt.Name, "type", "TXT", "action", "delete")
+	return nil
+}
+
+// deleteRecordsPerType removes the records of fqdn of the given type whose
+// content is not one of keep.
+func deleteRecordsPerType(ctx context.Context, client *cloudflare.API, zoneID string, fqdn string, recordType string, keep []string) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.deleteRecordsPerType")
+	defer func() { tracing.End(span, err) }()
+
+	if recordType != "A" && recordType != "CNAME" {
+		return nil
+	}
+	records, err := client.DNSRecords(ctx, zoneID, cloudflare.DNSRecord{Name: fqdn, Type: recordType})
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+	for _, record := range records {
+		if contains(keep, record.Content) {
+			continue
+		}
+		err := client.DeleteDNSRecord(ctx, zoneID, record.ID)
+		metrics.RecordOperation(cfg.Provider, record.Type, "delete", err)
+		audit.Record(ctx, audit.Entry{Action: "delete", Record: record.Name, Type: record.Type, Before: &audit.Value{Content: record.Content, TTL: record.TTL}}, err)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return err
+		}
+		logger.Info("Deleted DNS record", "zone", cfg.Zone, "record", record.Name, "type", record.Type, "content", record.Content, "action", "delete")
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]RecordSet) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package cloudflare
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	common "github.com/gathertown/casper-3/pkg"
+)
+
+func TestSyncRecordSets(t *testing.T) {
+	turn := RecordSet{Name: "turn", Type: "A", Targets: []string{"1.1.1.1", "1.1.1.2"}, Owner: "record/default/turn", TTL: 60}
+	www := RecordSet{Name: "www", Type: "A", Targets: []string{"1.1.1.5"}, Owner: "record/default/www"}
+	f := newFakeAPI(t,
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("turn"), Content: common.RecordSetLabel("record", cfg.Env, turn)},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("turn"), Content: "1.1.1.1", TTL: defaultTTL},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("turn"), Content: "1.1.1.3", TTL: defaultTTL},
+		// Added by hand, not owned by a record set
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("www"), Content: "1.1.1.9", TTL: defaultTTL},
+	)
+
+	d := CloudFlareDNS{Observed: &common.Observed{}}
+	err := d.SyncRecordSets(context.TODO(), "record", []RecordSet{turn, www})
+	if err == nil || !strings.Contains(err.Error(), "www") {
+		t.Errorf("Expecting www to fail, got %v", err)
+	}
+
+	want := []string{
+		recordFQDN("turn") + " A 1.1.1.1",
+		recordFQDN("turn") + " A 1.1.1.2",
+		recordFQDN("turn") + " TXT " + common.RecordSetLabel("record", cfg.Env, turn),
+		recordFQDN("www") + " A 1.1.1.9",
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+
+	// The records of www are fetched as its sync failed
+	var observed []string
+	for _, record := range d.Observed.Drain()["record"] {
+		observed = append(observed, record.FQDN+" "+record.Type+" "+record.Content)
+	}
+	wantObserved := []string{
+		recordFQDN("turn") + " TXT " + common.RecordSetLabel("record", cfg.Env, turn),
+		recordFQDN("turn") + " A 1.1.1.1",
+		recordFQDN("turn") + " A 1.1.1.2",
+		recordFQDN("www") + " A 1.1.1.9",
+	}
+	if strings.Join(observed, "\n") != strings.Join(wantObserved, "\n") {
+		t.Errorf("Expecting observed records %q, got %q", wantObserved, observed)
+	}
+	for _, record := range f.records {
+		if record.Name == recordFQDN("turn") && record.Type == "A" && record.TTL != 60 {
+			t.Errorf("Expecting TTL 60 for %s, got %d", record.Content, record.TTL)
+		}
+	}
+}
+
+func TestSyncRecordSetsRetargetsCNAME(t *testing.T) {
+	app := RecordSet{Name: "app", Type: "CNAME", Targets: []string{"lb-2.example.com"}, Owner: "service/default/app"}
+	f := newFakeAPI(t,
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN(app.TXTName()), Content: common.RecordSetLabel("service", cfg.Env, app)},
+		cloudflare.DNSRecord{Type: "CNAME", Name: recordFQDN("app"), Content: "lb-1.example.com", TTL: defaultTTL},
+	)
+	// Like Cloudflare, reject a second CNAME record for the name
+	f.fail = func(r cloudflare.DNSRecord) bool { return r.Type == "CNAME" }
+
+	d := CloudFlareDNS{}
+	if err := d.SyncRecordSets(context.TODO(), "service", []RecordSet{app}); err != nil {
+		t.Fatalf("SyncRecordSets() returned error: %v", err)
+	}
+
+	want := []string{
+		recordFQDN("app") + " CNAME lb-2.example.com",
+		recordFQDN(app.TXTName()) + " TXT " + common.RecordSetLabel("service", cfg.Env, app),
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,201 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
+	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/audit"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// pair is the desired content of an A/TXT record pair.
+type pair struct {
+	addressIPv4 string
+	ttl         int
+	proxied     bool
+	label       string
+}
+
+// Repair completes or removes owned record pairs of which only the TXT record
+// exists, e.g. after a failure between the two creates of addRecord. An A
+// record is only marked as owned by its TXT record, so A records without one
+// may have been added by hand. Their TXT record is only added when they point
+// to the address of the node or pod of that name, the others are reported.
+func (d CloudFlareDNS) Repair(ctx context.Context, nodes []Node, pods []Pod) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.Repair")
+	defer func() { tracing.End(span, err) }()
+
+	var failed []string
+
+	// Setup the client
+	client := NewCFClient()
+
+	zoneID, err := zoneIDByName(ctx, client, cfg.Zone)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		logger.Error("Error occured while fetching zone", "zone", cfg.Zone, "error", err.Error())
+		return err
+	}
+
+	txtRecords, err := getRecordsPerType(ctx, client, zoneID, "TXT")
+	if err != nil {
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", "TXT", "error", err.Error())
+		return err
+	}
+
+	aRecords, err := getRecordsPerType(ctx, client, zoneID, "A")
+	if err != nil {
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", "A", "error", err.Error())
+		return err
+	}
+
+	desired := make(map[string]pair)
+	for _, node := range nodes {
+		desired[optionsFQDN(node.RecordOptions)] = pair{node.ExternalIP, node.TTLOr(defaultTTL), node.ProxiedOr(isProxied(node.Name)), nodeLabel(node.Name)}
+	}
+	for _, pod := range pods {
+		desired[optionsFQDN(pod.RecordOptions)] = pair{pod.AssignedNode.ExternalIP, pod.TTLOr(defaultTTL), pod.ProxiedOr(isProxied(pod.Name)), podLabel(pod.Namespace, pod.Name, pod.AssignedNode.Name, pod.AssignedNode.ExternalIP)}
+	}
+
+	owned := make(map[string]cloudflare.DNSRecord)
+	for _, record := range txtRecords {
+		// Record sets are kept consistent by SyncRecordSets
+		kind := common.LabelKind(record.Content)
+		if common.IsOwned(record.Content, cfg.Env) && (kind == "node" || kind == "pod") {
+			owned[record.Name] = record
+		}
+	}
+
+	addresses := make(map[string]cloudflare.DNSRecord)
+	for _, record := range aRecords {
+		addresses[record.Name] = record
+	}
+
+	var txtOnly, aOnly []string
+	for fqdn := range owned {
+		if _, found := addresses[fqdn]; !found {
+			txtOnly = append(txtOnly, fqdn)
+		}
+	}
+	for fqdn := range desired {
+		_, hasA := addresses[fqdn]
+		_, hasTXT := owned[fqdn]
+		if hasA && !hasTXT {
+			aOnly = append(aOnly, fqdn)
+		}
+	}
+	sort.Strings(txtOnly)
+	sort.Strings(aOnly)
+
+	metrics.DNSInconsistentRecords(cfg.Provider, "txt_without_a", float64(len(txtOnly)))
+	metrics.DNSInconsistentRecords(cfg.Provider, "a_without_txt", float64(len(aOnly)))
+
+	// A TXT record without its A record makes the name look published. Create the
+	// A record if the name is still wanted, otherwise remove the TXT record.
+	for _, fqdn := range txtOnly {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		if p, found := desired[fqdn]; found && p.addressIPv4 != "" {
+			logger.Info("Completing record pair", "zone", cfg.Zone, "record", fqdn, "type", "A", "content", p.addressIPv4, "action", "create")
+			err = createRecord(pctx, client, zoneID, "A", fqdn, p.addressIPv4, p.ttl, p.proxied)
+		} else {
+			logger.Info("Removing orphaned record", "zone", cfg.Zone, "record", fqdn, "type", "TXT", "action", "delete")
+			err = client.DeleteDNSRecord(pctx, zoneID, owned[fqdn].ID)
+			metrics.RecordOperation(cfg.Provider, "TXT", "delete", err)
+			audit.Record(pctx, audit.Entry{Action: "delete", Record: fqdn, Type: "TXT", Before: &audit.Value{Content: owned[fqdn].Content, TTL: owned[fqdn].TTL}}, err)
+		}
+		cancel()
+		if err != nil {
+			metrics.ExecErrInc(err)
+			failed = append(failed, fqdn)
+			logger.Error("Error occured while repairing record", "zone", cfg.Zone, "record", fqdn, "error", err.Error())
+		}
+	}
+
+	// An A record without its TXT record is never seen by Sync, but it may as
+	// well be managed by hand. Take it over only if it already points where
+	// casper-3 would point it, e.g. after a failure in the middle of a move.
+	for _, fqdn := range aOnly {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p := desired[fqdn]
+		if p.addressIPv4 == "" || addresses[fqdn].Content != p.addressIPv4 {
+			logger.Warn("Found A record without ownership record, leaving it alone", "zone", cfg.Zone, "record", fqdn, "type", "A", "content", addresses[fqdn].Content)
+			continue
+		}
+		logger.Info("Completing record pair", "zone", cfg.Zone, "record", fqdn, "type", "TXT", "action", "create")
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		err := createRecord(pctx, client, zoneID, "TXT", fqdn, p.label, p.ttl, false)
+		cancel()
+		if err != nil {
+			metrics.ExecErrInc(err)
+			failed = append(failed, fqdn)
+			logger.Error("Error occured while repairing record", "zone", cfg.Zone, "record", fqdn, "error", err.Error())
+		}
+	}
+
+	return common.SyncErr(failed)
+}
+
+// recordFQDN returns the FQDN of the records of name, as reported by Cloudflare.
+func recordFQDN(name string) string {
+	return subdomainFQDN(name, cfg.Subdomain)
+}
+
+// optionsFQDN returns the FQDN of the records published with the record options o.
+func optionsFQDN(o common.RecordOptions) string {
+	return subdomainFQDN(o.Hostname, o.SubdomainOr(cfg.Subdomain))
+}
+
+func subdomainFQDN(name string, subdomain string) string {
+	if subdomain != "" {
+		return fmt.Sprintf("%s.%s.%s", name, subdomain, cfg.Zone)
+	}
+	return fmt.Sprintf("%s.%s", name, cfg.Zone)
+}
+
+func getRecordsPerType(ctx context.Context, client *cloudflare.API, zoneID string, recordType string) (_ []cloudflare.DNSRecord, err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.DNSRecordsByType", attribute.String("type", recordType))
+	defer func() { tracing.End(span, err) }()
+
+	records, err := client.DNSRecords(ctx, zoneID, cloudflare.DNSRecord{Type: recordType})
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return nil, err
+	}
+	logger.Debug("Fetched DNS records", "type", recordType, "count", len(records))
+	return records, nil
+}
+
+func createRecord(ctx context.Context, client *cloudflare.API, zoneID string, recordType string, name string, content string, ttl int, proxied bool) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.createRecord", attribute.String("record", name), attribute.String("type", recordType))
+	defer func() { tracing.End(span, err) }()
+
+	request := cloudflare.DNSRecord{
+		Type:    recordType,
+		Name:    name,
+		Content: content,
+		TTL:     ttl,
+	}
+	if recordType == "A" {
+		request.Proxied = &proxied
+	}
+
+	response, err := client.CreateDNSRecord(ctx, zoneID, request)
+	metrics.RecordOperation(cfg.Provider, recordType, "create", err)
+	audit.Record(ctx, audit.Entry{Action: "create", Record: name, Type: recordType, After: &audit.Value{Content: content, TTL: ttl}}, err)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+	logger.Info("Added record", "zone", cfg.Zone, "record", name, "type", recordType, "success", response.Success, "action", "create")
+	return nil
+}
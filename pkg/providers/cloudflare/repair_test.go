@@ -0,0 +1,40 @@
+package cloudflare
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+)
+
+func TestRepair(t *testing.T) {
+	f := newFakeAPI(t,
+		// A TXT record of a node without its A record
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("sfu-8mh0d"), Content: label},
+		// A TXT record of a node that is gone
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("sfu-8quob"), Content: label},
+		// An A record of a node without its TXT record, e.g. added by hand
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("sfu-9cnbs"), Content: "1.1.1.9"},
+		// An A record of a node without its TXT record, pointing to the node
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("sfu-7kd2x"), Content: "1.1.1.4"},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("www"), Content: "1.1.1.8"},
+	)
+
+	d := CloudFlareDNS{}
+	if err := d.Repair(context.TODO(), []Node{node("sfu-8mh0d", "1.1.1.1"), node("sfu-9cnbs", "1.1.1.3"), node("sfu-7kd2x", "1.1.1.4")}, nil); err != nil {
+		t.Fatalf("Repair() returned error: %v", err)
+	}
+
+	want := []string{
+		recordFQDN("sfu-7kd2x") + " A 1.1.1.4",
+		recordFQDN("sfu-7kd2x") + " TXT " + nodeLabel("sfu-7kd2x"),
+		recordFQDN("sfu-8mh0d") + " A 1.1.1.1",
+		recordFQDN("sfu-8mh0d") + " TXT " + label,
+		recordFQDN("sfu-9cnbs") + " A 1.1.1.9",
+		recordFQDN("www") + " A 1.1.1.8",
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+}
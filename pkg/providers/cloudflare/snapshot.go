@@ -0,0 +1,102 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
+	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/snapshot"
+)
+
+// Records returns the A, AAAA and TXT records of the zone.
+func (d CloudFlareDNS) Records(ctx context.Context) (_ []snapshot.Record, err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.ZoneRecords")
+	defer func() { tracing.End(span, err) }()
+
+	client := NewCFClient()
+	if client == nil {
+		return nil, errors.New("no Cloudflare client, see the previous errors")
+	}
+	zoneID, err := zoneIDByName(ctx, client, cfg.Zone)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return nil, err
+	}
+	return zoneRecords(ctx, client, zoneID)
+}
+
+// zoneRecords returns the A, AAAA and TXT records of the zone zoneID.
+func zoneRecords(ctx context.Context, client *cloudflare.API, zoneID string) ([]snapshot.Record, error) {
+	var records []snapshot.Record
+	for _, recordType := range snapshot.Types {
+		rs, err := getRecordsPerType(ctx, client, zoneID, recordType)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rs {
+			records = append(records, snapshot.Record{Name: r.Name, Type: r.Type, Content: r.Content, TTL: r.TTL, Proxied: r.Proxied != nil && *r.Proxied})
+		}
+	}
+	return records, nil
+}
+
+// Restore creates the records missing from the zone, leaving the existing
+// ones alone. The record pairs of nodes and pods are created as by Sync, the
+// other records one by one. With dryRun, the records are only logged.
+func (d CloudFlareDNS) Restore(ctx context.Context, records []snapshot.Record, dryRun bool) (err error) {
+	ctx, span := tracing.Start(ctx, "cloudflare.Restore")
+	defer func() { tracing.End(span, err) }()
+
+	client := NewCFClient()
+	if client == nil {
+		return errors.New("no Cloudflare client, see the previous errors")
+	}
+	zoneID, err := zoneIDByName(ctx, client, cfg.Zone)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+	existing, err := zoneRecords(ctx, client, zoneID)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	pairs, others := snapshot.Pairs(snapshot.Missing(records, existing))
+	for _, p := range pairs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if dryRun {
+			logger.Info("Would restore record pair", "zone", cfg.Zone, "record", p.A.Name, "type", "A", "content", p.A.Content, "action", "create")
+			continue
+		}
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		_, err := addRecord(pctx, client, cfg.Zone, p.A.Name, p.A.Content, p.TXT.Content, p.A.TTL, p.A.Proxied)
+		cancel()
+		if err != nil {
+			failed = append(failed, p.A.Name)
+			logger.Error("Error occured while restoring record pair", "zone", cfg.Zone, "record", p.A.Name, "error", err.Error())
+		}
+	}
+	for _, r := range others {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if dryRun {
+			logger.Info("Would restore record", "zone", cfg.Zone, "record", r.Name, "type", r.Type, "content", r.Content, "action", "create")
+			continue
+		}
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		err := createRecord(pctx, client, zoneID, r.Type, r.Name, r.Content, r.TTL, r.Proxied)
+		cancel()
+		if err != nil {
+			failed = append(failed, r.Name)
+			logger.Error("Error occured while restoring record", "zone", cfg.Zone, "record", r.Name, "type", r.Type, "error", err.Error())
+		}
+	}
+	return common.SyncErr(failed)
+}
@@ -0,0 +1,64 @@
+package cloudflare
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	cloudflare "github.com/cloudflare/cloudflare-go"
+	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/snapshot"
+)
+
+func TestRestore(t *testing.T) {
+	turn := common.RecordSetLabel("service", cfg.Env, RecordSet{Name: "turn", Type: "A", Owner: "service/infra/turn"})
+	f := newFakeAPI(t,
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("sfu-b"), Content: label},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("sfu-b"), Content: "1.1.1.9"},
+		cloudflare.DNSRecord{Type: "TXT", Name: recordFQDN("turn"), Content: turn},
+		cloudflare.DNSRecord{Type: "A", Name: recordFQDN("turn"), Content: "1.1.1.3"},
+	)
+	records := []snapshot.Record{
+		{Name: recordFQDN("sfu-a"), Type: "A", Content: "1.1.1.1", TTL: 60},
+		{Name: recordFQDN("sfu-a"), Type: "TXT", Content: label, TTL: 60},
+		// The address of sfu-b changed since
+		{Name: recordFQDN("sfu-b"), Type: "A", Content: "1.1.1.2", TTL: 60},
+		{Name: recordFQDN("sfu-b"), Type: "TXT", Content: label, TTL: 60},
+		{Name: recordFQDN("turn"), Type: "A", Content: "1.1.1.3", TTL: 60},
+		{Name: recordFQDN("turn"), Type: "A", Content: "1.1.1.4", TTL: 60},
+		{Name: recordFQDN("turn"), Type: "TXT", Content: turn, TTL: 60},
+	}
+
+	d := CloudFlareDNS{}
+	existing, err := d.Records(context.TODO())
+	if err != nil {
+		t.Fatalf("Records() returned error: %v", err)
+	}
+	if len(existing) != 4 {
+		t.Errorf("Expecting the 4 records of the zone, got %+v", existing)
+	}
+
+	before := f.zone()
+	if err := d.Restore(context.TODO(), records, true); err != nil {
+		t.Fatalf("Restore() of a dry run returned error: %v", err)
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(before, "\n") {
+		t.Errorf("Expecting records %q after a dry run, got %q", before, got)
+	}
+
+	if err := d.Restore(context.TODO(), records, false); err != nil {
+		t.Fatalf("Restore() returned error: %v", err)
+	}
+	want := []string{
+		recordFQDN("sfu-a") + " A 1.1.1.1",
+		recordFQDN("sfu-a") + " TXT " + label,
+		recordFQDN("sfu-b") + " A 1.1.1.9",
+		recordFQDN("sfu-b") + " TXT " + label,
+		recordFQDN("turn") + " A 1.1.1.3",
+		recordFQDN("turn") + " A 1.1.1.4",
+		recordFQDN("turn") + " TXT " + turn,
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+}
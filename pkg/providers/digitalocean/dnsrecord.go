@@ -3,30 +3,79 @@ package digitalocean
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/digitalocean/godo"
 	"github.com/gathertown/casper-3/internal/config"
 	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
 	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/audit"
 	"github.com/gathertown/casper-3/pkg/log"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/oauth2"
 )
 
 var cfg = config.FromEnv()
-var logger = log.New(os.Stdout, cfg.LogLevel)
+var logger = log.New(os.Stdout, cfg.LogLevel, cfg.LogFormat).With("provider", cfg.Provider)
 var label = fmt.Sprintf("heritage=casper-3,environment=%s", cfg.Env)
 
+// nodeLabel returns the content of the TXT record of a node record pair. The
+// records of older releases carry label only.
+func nodeLabel(nodeName string) string {
+	return label + ",nodeName=" + nodeName
+}
+
+// podLabel returns the content of the TXT record of a pod-sync record pair.
+func podLabel(namespace string, podName string, assignedNode string, addressIPv4 string) string {
+	return fmt.Sprintf("heritage=casper-3,pod-sync=true,environment=%s,namespace=%s,podName=%s,assignedNode=%s,addressIPv4=%s", cfg.Env, namespace, podName, assignedNode, addressIPv4)
+}
+
+// defaultTTL is the TTL of records without a TTL annotation or setting.
+const defaultTTL = 1800
+
+// rollbackTimeout bounds the removal of a half-created record pair.
+const rollbackTimeout = 10 * time.Second
+
 type Node = common.Node
 type Pod = common.Pod
-type DigitalOceanDNS struct{}
+
+// DigitalOceanDNS syncs cluster nodes and pods to DNS records.
+type DigitalOceanDNS struct {
+	// ShutdownTimeout is how long an in-flight record pair may take to
+	// complete once the sync context is cancelled.
+	ShutdownTimeout time.Duration
+	// Changes, if set, collects the record operations for reporting.
+	Changes *common.Changes
+	// Observed, if set, collects the owned records found in the zone.
+	Observed *common.Observed
+}
+
+// baseURL is the URL of the API, the default of godo if empty. Tests point it
+// at a fake API.
+var baseURL string
 
 func NewDOClient() *godo.Client {
-	return godo.NewFromToken(cfg.Token)
+	// As godo.NewFromToken, with the requests instrumented.
+	token := strings.Trim(strings.TrimSpace(cfg.Token), "'")
+	client := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	client.Transport = metrics.InstrumentRoundTripper("digitalocean", tracing.Transport("digitalocean", client.Transport))
+	c := godo.NewClient(client)
+	if baseURL != "" {
+		c.BaseURL, _ = url.Parse(baseURL)
+	}
+	return c
 }
 
-func (d DigitalOceanDNS) Sync(nodes []Node) {
-	var nodeHostnames, dnsRecords []string
+func (d DigitalOceanDNS) Sync(ctx context.Context, nodes []Node) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.Sync")
+	defer func() { tracing.End(span, err) }()
+
+	var nodeHostnames, nodeNames, dnsRecords, failed []string
 
 	// Setup the client
 	client := NewDOClient()
@@ -35,49 +84,92 @@ func (d DigitalOceanDNS) Sync(nodes []Node) {
 	recordType := "TXT"
 
 	// Fetch all TXT DNS
-	txtRecords, err := getRecords(context.TODO(), client, cfg.Zone, recordType)
+	txtRecords, err := getRecords(ctx, client, cfg.Zone, recordType)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		logger.Error("Error occured while fetching records", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
-		return
+		metrics.ExecErrInc(err)
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", recordType, "error", err.Error())
+		return err
 	}
 
-	// Generate arrays
+	// Generate arrays. The names are kept as the subdomain may be overridden per node.
+	recordNames := make(map[string][]string)
+	// The names of the nodes of the records, if their label carries one
+	objects := make(map[string]string)
+	var owned []common.Record
 	for _, record := range txtRecords {
-		if record.Data == label {
+		if common.IsOwned(record.Data, cfg.Env) && common.LabelKind(record.Data) == "node" {
 			cName := strings.Split(record.Name, ".") // e.g. convert "sfu-v81hha.dev" to "sfu-v81hha" to allow comparison with hostnames
 			dnsRecords = append(dnsRecords, cName[0])
+			recordNames[cName[0]] = append(recordNames[cName[0]], record.Name)
+			if nodeName := common.ParseLabel(record.Data)["nodeName"]; nodeName != "" {
+				objects[cName[0]] = nodeName
+			}
+			owned = append(owned, common.Record{Kind: "node", FQDN: recordFQDN(record.Name), Type: record.Type, Content: record.Data})
 		}
 	}
+	addresses, err := getAddressRecords(ctx, client)
+	if err != nil {
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", "A", "error", err.Error())
+		return err
+	}
+	d.observe("node", owned, addresses)
+	metrics.DNSOwnedRecords(cfg.Provider, "node", float64(len(owned)))
 
+	desired := make(map[string]Node)
 	for _, node := range nodes {
-		nodeHostnames = append(nodeHostnames, node.Name)
+		nodeHostnames = append(nodeHostnames, node.Hostname)
+		nodeNames = append(nodeNames, node.Name)
+		desired[node.Hostname] = node
 	}
 	logger.Debug("SFU nodes found", "nodes", nodeHostnames)
 
+	// Records are safe for deletion if they match the node pools, by name or hostname.
+	nodePrefixes := append(nodeNames, nodeHostnames...)
+
 	// Find new entries
 	addEntries := common.Compare(nodeHostnames, dnsRecords)
+	// Find entries whose subdomain changed
+	for _, name := range nodeHostnames {
+		if n, found := recordNames[name]; found && !contains(n, optionsName(desired[name].RecordOptions)) {
+			addEntries = append(addEntries, name)
+		}
+	}
 	if len(addEntries) > 0 {
 		logger.Info("Entries to be added", "entries", addEntries)
 		for _, name := range addEntries {
-			addressIPv4 := ""
-			// this loop seems a bit inefficient at first glance
-			// entries are bellow 1k, so shouldn't really matter.
-			for _, entry := range nodes {
-				if entry.Name == name {
-					addressIPv4 = entry.ExternalIP
-				}
+			// Stop before starting a new record pair when shutting down
+			if err := ctx.Err(); err != nil {
+				return err
 			}
+			node := desired[name]
 			// Does this check make sense?
-			if addressIPv4 == "" {
-				logger.Info("IP address not found for entry", "name", name, "zone", cfg.Zone, "subdomain", cfg.Subdomain)
-			} else {
-				_, err := addRecord(context.TODO(), client, cfg.Zone, name, cfg.Subdomain, addressIPv4, "", "", cfg.Env)
-				if err != nil {
-					metrics.ExecErrInc(err.Error())
-					logger.Error("Error occured while adding records", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
+			if node.ExternalIP == "" {
+				logger.Warn("IP address not found for entry", "record", name, "zone", cfg.Zone, "subdomain", node.SubdomainOr(cfg.Subdomain), "action", "blocked")
+				d.Changes.Add(common.Change{Action: "blocked", Kind: "node", Name: node.Name, FQDN: recordFQDN(optionsName(node.RecordOptions)), Type: "A", Err: common.ErrNoAddress})
+				continue
+			}
+			var ids []string
+			// The error of this node only, the nodes that failed are reported by SyncErr
+			var err error
+			pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+			pctx = audit.WithObject(pctx, "node", "", node.Name)
+			// Remove the records of a previous subdomain first
+			for _, n := range recordNames[name] {
+				logger.Debug("Launching deletion", "record", n)
+				if _, err = deleteRecord(pctx, client, cfg.Zone, recordFQDN(n)); err != nil {
+					break
 				}
 			}
+			if err == nil {
+				ids, err = addRecord(pctx, client, cfg.Zone, optionsName(node.RecordOptions), node.ExternalIP, nodeLabel(node.Name), node.TTLOr(defaultTTL))
+			}
+			cancel()
+			d.Changes.Add(common.Change{Action: "create", Kind: "node", Name: node.Name, FQDN: recordFQDN(optionsName(node.RecordOptions)), Type: "A", Content: node.ExternalIP, RecordIDs: ids, Err: err})
+			if err != nil {
+				metrics.ExecErrInc(err)
+				failed = append(failed, name)
+				logger.Error("Error occured while adding records", "zone", cfg.Zone, "record", recordFQDN(optionsName(node.RecordOptions)), "type", "A", "error", err.Error())
+			}
 		}
 	}
 
@@ -85,26 +177,70 @@ func (d DigitalOceanDNS) Sync(nodes []Node) {
 	deleteEntries := common.Compare(dnsRecords, nodeHostnames)
 	if len(deleteEntries) > 0 {
 		for _, name := range deleteEntries {
-			// The 'Name' entry is the FQDN
-			cName := fmt.Sprintf("%s.%s.%s", name, cfg.Subdomain, cfg.Zone)
-			if isRecordSafeForDeletion := common.RecordPrefixMatchesNodePrefixes(cName, nodeHostnames); !isRecordSafeForDeletion {
-				logger.Info("Casper-3 wants to delete this record", "record", cName, "Skipping..")
-				continue
+			// The node may still exist, e.g. cordoned, the record name is reported otherwise
+			object := name
+			if nodeName, found := objects[name]; found {
+				object = nodeName
 			}
-			logger.Debug("Launching deletion", "record", cName)
-			_, err := deleteRecord(context.TODO(), client, cfg.Zone, cName)
-			if err != nil {
-				metrics.ExecErrInc(err.Error())
-				logger.Error("Error occured while deleting records", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
+			for _, n := range recordNames[name] {
+				// Stop before starting a new record pair when shutting down
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				// The 'Name' entry is the FQDN
+				cName := recordFQDN(n)
+				if isRecordSafeForDeletion := common.RecordPrefixMatchesNodePrefixes(cName, nodePrefixes); !isRecordSafeForDeletion {
+					logger.Warn("Skipping deletion of record not matching the prefix of any node", "record", cName, "action", "blocked")
+					d.Changes.Add(common.Change{Action: "blocked", Kind: "node", Name: object, FQDN: cName, Type: "A", Err: common.ErrPrefixGuard})
+					continue
+				}
+				logger.Debug("Launching deletion", "record", cName)
+				pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+				pctx = audit.WithObject(pctx, "node", "", object)
+				_, err := deleteRecord(pctx, client, cfg.Zone, cName)
+				cancel()
+				d.Changes.Add(common.Change{Action: "delete", Kind: "node", Name: object, FQDN: cName, Type: "A", Err: err})
+				if err != nil {
+					metrics.ExecErrInc(err)
+					failed = append(failed, name)
+					logger.Error("Error occured while deleting records", "zone", cfg.Zone, "record", cName, "type", "A", "error", err.Error())
+				}
 			}
 		}
 	}
 
+	// Apply changed TTL annotations to the records of the other nodes
+	for _, name := range nodeHostnames {
+		if contains(addEntries, name) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		node := desired[name]
+		n := optionsName(node.RecordOptions)
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		pctx = audit.WithObject(pctx, "node", "", node.Name)
+		updated, err := updateRecordTTL(pctx, client, addresses[recordFQDN(n)], node.TTLOr(defaultTTL))
+		cancel()
+		if updated || err != nil {
+			d.Changes.Add(common.Change{Action: "update", Kind: "node", Name: node.Name, FQDN: recordFQDN(n), Type: "A", Content: node.ExternalIP, Err: err})
+		}
+		if err != nil {
+			failed = append(failed, name)
+			logger.Error("Error occured while updating record", "zone", cfg.Zone, "record", recordFQDN(n), "type", "A", "error", err.Error())
+		}
+	}
+
 	// Find kubernetes nodes to register
+	return common.SyncErr(failed)
 }
 
-func (c DigitalOceanDNS) SyncPods(pods []Pod) {
-	var names, dnsRecords []string
+func (c DigitalOceanDNS) SyncPods(ctx context.Context, pods []Pod) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.SyncPods")
+	defer func() { tracing.End(span, err) }()
+
+	var names, dnsRecords, failed []string
 	var txtRecordsFromPods []godo.DomainRecord
 
 	// Setup the client
@@ -113,19 +249,19 @@ func (c DigitalOceanDNS) SyncPods(pods []Pod) {
 	// The source of truth are the TXT records as they are created and deleted alongside 'A' records.
 	// The logical flow is the following:
 	// fetch txtRecords that have been created from a pod-sync operation --> indicator for this, is the existence of the `pod-sync=true` string on the txt data.
-	// save pod names that have the `casper-3.gather.town/sync: "true"` label.
-	// compare pod names with cNames --> if diff, then create dns records.
-	// compare cNames with pod names --> if diff, then delete the stale resources.
-	// compare pod names with existing txt records that have been created from a pod-sync operation --> if cname is equal to pod name, but the txtLabel has different assignedNode in comparison with the current pod assignedNode, then delete the outdated records and recreate them with proper configuration
+	// save pod hostnames of the pods that have the `casper-3.gather.town/sync: "true"` label.
+	// compare pod hostnames with cNames --> if diff, then create dns records.
+	// compare cNames with pod hostnames --> if diff, then delete the stale resources.
+	// compare pod hostnames with existing txt records that have been created from a pod-sync operation --> if cname is equal to pod hostname, but the txtLabel has different assignedNode in comparison with the current pod assignedNode, or the name changed, then delete the outdated records and recreate them with proper configuration
 
 	recordType := "TXT"
 
 	// Fetch all TXT DNS
-	txtRecords, err := getRecords(context.TODO(), client, cfg.Zone, recordType)
+	txtRecords, err := getRecords(ctx, client, cfg.Zone, recordType)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		logger.Error("Error occured while fetching records", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
-		return
+		metrics.ExecErrInc(err)
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", recordType, "error", err.Error())
+		return err
 	}
 
 	// Generate arrays
@@ -136,9 +272,22 @@ func (c DigitalOceanDNS) SyncPods(pods []Pod) {
 			txtRecordsFromPods = append(txtRecordsFromPods, txtRecord)
 		}
 	}
+	owned := make([]common.Record, 0, len(txtRecordsFromPods))
+	for _, txt := range txtRecordsFromPods {
+		owned = append(owned, common.Record{Kind: "pod", FQDN: recordFQDN(txt.Name), Type: txt.Type, Content: txt.Data})
+	}
+	addresses, err := getAddressRecords(ctx, client)
+	if err != nil {
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", "A", "error", err.Error())
+		return err
+	}
+	c.observe("pod", owned, addresses)
+	metrics.DNSOwnedRecords(cfg.Provider, "pod", float64(len(txtRecordsFromPods)))
 
+	desired := make(map[string]Pod)
 	for _, pod := range pods {
-		names = append(names, pod.Name)
+		names = append(names, pod.Hostname)
+		desired[pod.Hostname] = pod
 	}
 
 	logger.Debug("Pods found", "pods", names)
@@ -148,27 +297,27 @@ func (c DigitalOceanDNS) SyncPods(pods []Pod) {
 	if len(addEntries) > 0 {
 		logger.Info("Entries to be added", "entries", addEntries)
 		for _, name := range addEntries {
-			podName := ""
-			assignedNode := ""
-			addressIPv4 := ""
-
-			for _, pod := range pods {
-				if pod.Name == name {
-					podName = pod.Name
-					assignedNode = pod.AssignedNode.Name
-					addressIPv4 = pod.AssignedNode.ExternalIP
-				}
+			// Stop before starting a new record pair when shutting down
+			if err := ctx.Err(); err != nil {
+				return err
 			}
+			pod := desired[name]
+			addressIPv4 := pod.AssignedNode.ExternalIP
 
 			if addressIPv4 == "" {
-				logger.Info("IP address not found for entry", "name", name, "zone", cfg.Zone, "subdomain", cfg.Subdomain)
+				logger.Warn("IP address not found for entry", "record", name, "zone", cfg.Zone, "subdomain", pod.SubdomainOr(cfg.Subdomain), "action", "blocked")
+				c.Changes.Add(common.Change{Action: "blocked", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: recordFQDN(optionsName(pod.RecordOptions)), Type: "A", Err: common.ErrNoAddress})
 			} else {
-				txtLabel := fmt.Sprintf("heritage=casper-3,pod-sync=true,environment=%s,podName=%s,assignedNode=%s,addressIPv4=%s", cfg.Env, podName, assignedNode, addressIPv4)
-				txtRecordName := podName
-				_, err := addRecord(context.TODO(), client, cfg.Zone, podName, cfg.Subdomain, addressIPv4, txtRecordName, txtLabel, cfg.Env)
+				txtLabel := podLabel(pod.Namespace, pod.Name, pod.AssignedNode.Name, addressIPv4)
+				pctx, cancel := common.WithGrace(ctx, c.ShutdownTimeout)
+				pctx = audit.WithObject(pctx, "pod", pod.Namespace, pod.Name)
+				ids, err := addRecord(pctx, client, cfg.Zone, optionsName(pod.RecordOptions), addressIPv4, txtLabel, pod.TTLOr(defaultTTL))
+				cancel()
+				c.Changes.Add(common.Change{Action: "create", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: recordFQDN(optionsName(pod.RecordOptions)), Type: "A", Content: addressIPv4, RecordIDs: ids, Err: err})
 				if err != nil {
-					metrics.ExecErrInc(err.Error())
-					logger.Error("Error occured while adding record", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
+					metrics.ExecErrInc(err)
+					failed = append(failed, name)
+					logger.Error("Error occured while adding record", "zone", cfg.Zone, "record", recordFQDN(optionsName(pod.RecordOptions)), "type", "A", "error", err.Error())
 				}
 			}
 		}
@@ -178,54 +327,199 @@ func (c DigitalOceanDNS) SyncPods(pods []Pod) {
 	deleteEntries := common.Compare(dnsRecords, names)
 	if len(deleteEntries) > 0 {
 		logger.Info("Entries to be deleted", "entries", deleteEntries)
-		for _, name := range deleteEntries {
-			cName := fmt.Sprintf("%s.%s", name, cfg.Zone)
-			if cfg.Subdomain != "" {
-				cName = fmt.Sprintf("%s.%s.%s", name, cfg.Subdomain, cfg.Zone)
+		for _, txt := range txtRecordsFromPods {
+			name := strings.Split(txt.Name, ".")[0]
+			if !contains(deleteEntries, name) {
+				continue
+			}
+			// Stop before starting a new record pair when shutting down
+			if err := ctx.Err(); err != nil {
+				return err
 			}
+			cName := recordFQDN(txt.Name)
 			logger.Debug("Launching deletion", "record", cName)
-			_, err := deleteRecord(context.TODO(), client, cfg.Zone, cName)
+			pctx, cancel := common.WithGrace(ctx, c.ShutdownTimeout)
+			fields := common.ParseLabel(txt.Data)
+			pctx = audit.WithObject(pctx, "pod", fields["namespace"], fields["podName"])
+			_, err := deleteRecord(pctx, client, cfg.Zone, cName)
+			cancel()
+			c.Changes.Add(common.Change{Action: "delete", Kind: "pod", Namespace: fields["namespace"], Name: fields["podName"], FQDN: cName, Type: "A", Err: err})
 			if err != nil {
-				metrics.ExecErrInc(err.Error())
-				logger.Error("Error occured while deleting record", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
+				metrics.ExecErrInc(err)
+				failed = append(failed, name)
+				logger.Error("Error occured while deleting record", "zone", cfg.Zone, "record", cName, "type", "A", "error", err.Error())
 			}
 		}
 	}
 
-	// Detect if an already registered pod has been rescheduled on a different node and update records accordingly
+	// Detect if an already registered pod has been rescheduled on a different node, or moved to
+	// a different subdomain, and update records accordingly
+	moved := make(map[string]bool)
 	for _, pod := range pods {
-		podName := pod.Name
 		assignedNode := pod.AssignedNode.Name
 		addressIPv4 := pod.AssignedNode.ExternalIP
-		txtLabel := fmt.Sprintf("heritage=casper-3,pod-sync=true,environment=%s,podName=%s,assignedNode=%s,addressIPv4=%s", cfg.Env, podName, assignedNode, addressIPv4)
+		txtLabel := podLabel(pod.Namespace, pod.Name, assignedNode, addressIPv4)
+		name := optionsName(pod.RecordOptions)
 		for _, txt := range txtRecordsFromPods {
 			cName := strings.Split(txt.Name, ".")
-			if cName[0] == podName && !strings.Contains(txt.Data, assignedNode) {
-				// then delete existing record and recreate new ones
-				logger.Debug("Found a pod with that might got rescheduled on a different node", podName)
-				cName := fmt.Sprintf("%s.%s", podName, cfg.Zone)
-				if cfg.Subdomain != "" {
-					cName = fmt.Sprintf("%s.%s.%s", podName, cfg.Subdomain, cfg.Zone)
+			if cName[0] == pod.Hostname && (!strings.Contains(txt.Data, assignedNode) || txt.Name != name) {
+				// Stop before starting a new record pair when shutting down
+				if err := ctx.Err(); err != nil {
+					return err
 				}
+				// then delete existing record and recreate new ones
+				moved[pod.Hostname] = true
+				logger.Debug("Found a pod that might have been rescheduled on a different node", "pod", pod.Name)
+				cName := recordFQDN(txt.Name)
 				logger.Debug("Launching deletion", "record", cName)
-				_, err := deleteRecord(context.TODO(), client, cfg.Zone, cName)
+				pctx, cancel := common.WithGrace(ctx, c.ShutdownTimeout)
+				pctx = audit.WithObject(pctx, "pod", pod.Namespace, pod.Name)
+				_, err := deleteRecord(pctx, client, cfg.Zone, cName)
+				c.Changes.Add(common.Change{Action: "delete", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: cName, Type: "A", Err: err})
 				if err != nil {
-					metrics.ExecErrInc(err.Error())
-					logger.Error("Error occured while deleting record", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
+					metrics.ExecErrInc(err)
+					failed = append(failed, pod.Hostname)
+					logger.Error("Error occured while deleting record", "zone", cfg.Zone, "record", cName, "type", "A", "error", err.Error())
+					// The move is retried by the next reconcile, a new pair would duplicate the old one
+					cancel()
+					continue
 				}
-				_, _err := addRecord(context.TODO(), client, cfg.Zone, podName, cfg.Subdomain, addressIPv4, podName, txtLabel, cfg.Env)
+				ids, _err := addRecord(pctx, client, cfg.Zone, name, addressIPv4, txtLabel, pod.TTLOr(defaultTTL))
+				cancel()
+				c.Changes.Add(common.Change{Action: "create", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: recordFQDN(name), Type: "A", Content: addressIPv4, RecordIDs: ids, Err: _err})
 				if _err != nil {
-					metrics.ExecErrInc(err.Error())
-					logger.Error("Error occured while adding record", "provider", cfg.Provider, "zone", cfg.Zone, "host", cfg.Subdomain, "error", err.Error())
+					metrics.ExecErrInc(_err)
+					failed = append(failed, pod.Hostname)
+					logger.Error("Error occured while adding record", "zone", cfg.Zone, "record", recordFQDN(name), "type", "A", "error", _err.Error())
 				}
 			}
 		}
 	}
 
+	// Apply changed TTL annotations to the records of the other pods
+	for _, pod := range pods {
+		if contains(addEntries, pod.Hostname) || moved[pod.Hostname] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := optionsName(pod.RecordOptions)
+		pctx, cancel := common.WithGrace(ctx, c.ShutdownTimeout)
+		pctx = audit.WithObject(pctx, "pod", pod.Namespace, pod.Name)
+		updated, err := updateRecordTTL(pctx, client, addresses[recordFQDN(name)], pod.TTLOr(defaultTTL))
+		cancel()
+		if updated || err != nil {
+			c.Changes.Add(common.Change{Action: "update", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: recordFQDN(name), Type: "A", Content: pod.AssignedNode.ExternalIP, Err: err})
+		}
+		if err != nil {
+			failed = append(failed, pod.Hostname)
+			logger.Error("Error occured while updating record", "zone", cfg.Zone, "record", recordFQDN(name), "type", "A", "error", err.Error())
+		}
+	}
+
 	// Find kubernetes pods to register
+	return common.SyncErr(failed)
+}
+
+// Verify checks that the token can access the zone.
+func (d DigitalOceanDNS) Verify(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.Verify")
+	defer func() { tracing.End(span, err) }()
+
+	_, _, err = NewDOClient().Domains.Get(ctx, cfg.Zone)
+	return err
+}
+
+// DeletePod removes the record pair of a terminating pod, if its TXT record
+// marks it as created for that pod.
+func (d DigitalOceanDNS) DeletePod(ctx context.Context, pod Pod) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.DeletePod")
+	defer func() { tracing.End(span, err) }()
+
+	// Setup the client
+	client := NewDOClient()
+
+	name := optionsName(pod.RecordOptions)
+	txtRecords, err := getRecordsPerTypeAndName(ctx, client, cfg.Zone, "TXT", name)
+	if err != nil {
+		return err
+	}
+
+	for _, txt := range txtRecords {
+		fields := common.ParseLabel(txt.Data)
+		// The records of older releases carry no namespace
+		if !common.IsOwned(txt.Data, cfg.Env) || common.LabelKind(txt.Data) != "pod" || fields["podName"] != pod.Name || (fields["namespace"] != "" && fields["namespace"] != pod.Namespace) {
+			continue
+		}
+		logger.Info("Deleting records of terminating pod", "zone", cfg.Zone, "record", name, "pod", pod.Namespace+"/"+pod.Name, "action", "delete")
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		pctx = audit.WithObject(pctx, "pod", pod.Namespace, pod.Name)
+		defer cancel()
+		_, err := deleteRecord(pctx, client, cfg.Zone, recordFQDN(name))
+		d.Changes.Add(common.Change{Action: "delete", Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, FQDN: recordFQDN(name), Type: "A", Err: err})
+		return err
+	}
+	logger.Debug("No records found for terminating pod", "record", name, "pod", pod.Namespace+"/"+pod.Name)
+	return nil
+}
+
+// observe sets the owned TXT records of kind, and the A records of their names
+// among addresses, on d.Observed.
+func (d DigitalOceanDNS) observe(kind string, owned []common.Record, addresses map[string][]godo.DomainRecord) {
+	if d.Observed == nil {
+		return
+	}
+	names := make([]string, 0, len(owned))
+	for _, record := range owned {
+		names = append(names, record.FQDN)
+	}
+	for _, name := range names {
+		for _, record := range addresses[name] {
+			owned = append(owned, common.Record{Kind: kind, FQDN: name, Type: record.Type, Content: record.Data})
+		}
+	}
+	d.Observed.Set(kind, owned)
+}
+
+// getAddressRecords returns the A records of the zone by FQDN.
+func getAddressRecords(ctx context.Context, client *godo.Client) (map[string][]godo.DomainRecord, error) {
+	records, err := getRecords(ctx, client, cfg.Zone, "A")
+	if err != nil {
+		return nil, err
+	}
+	addresses := make(map[string][]godo.DomainRecord)
+	for _, record := range records {
+		addresses[recordFQDN(record.Name)] = append(addresses[recordFQDN(record.Name)], record)
+	}
+	return addresses, nil
+}
+
+// updateRecordTTL sets the TTL of records, the A records of a node or pod, if
+// it differs. It reports whether records were updated.
+func updateRecordTTL(ctx context.Context, client *godo.Client, records []godo.DomainRecord, ttl int) (updated bool, err error) {
+	for _, record := range records {
+		if record.TTL == ttl {
+			continue
+		}
+		request := &godo.DomainRecordEditRequest{Type: record.Type, Name: record.Name, Data: record.Data, TTL: ttl}
+		_, response, err := client.Domains.EditRecord(ctx, cfg.Zone, record.ID, request)
+		metrics.RecordOperation(cfg.Provider, record.Type, "update", err)
+		audit.Record(ctx, audit.Entry{Action: "update", Record: recordFQDN(record.Name), Type: record.Type, Before: &audit.Value{Content: record.Data, TTL: record.TTL}, After: &audit.Value{Content: record.Data, TTL: ttl}, Status: responseStatus(response)}, err)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return updated, err
+		}
+		logger.Info("Updated record", "zone", cfg.Zone, "record", record.Name, "type", record.Type, "content", record.Data, "ttl", ttl, "action", "update")
+		updated = true
+	}
+	return updated, nil
 }
 
-func getRecords(ctx context.Context, client *godo.Client, domain string, recordType string) ([]godo.DomainRecord, error) {
+func getRecords(ctx context.Context, client *godo.Client, domain string, recordType string) (_ []godo.DomainRecord, err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.Records")
+	defer func() { tracing.End(span, err) }()
+
 	records := []godo.DomainRecord{}
 	opt := &godo.ListOptions{
 		Page:    1,
@@ -235,21 +529,24 @@ func getRecords(ctx context.Context, client *godo.Client, domain string, recordT
 	for {
 		rr, _, err := client.Domains.RecordsByType(ctx, domain, recordType, opt)
 		if err != nil {
-			metrics.ExecErrInc(err.Error())
+			metrics.ExecErrInc(err)
 			return records, err
 		}
 
+		records = append(records, rr...)
 		if len(rr) < opt.PerPage {
 			return records, nil
 		}
 
 		opt.Page += 1
-		records = append(records, rr...)
 		logger.Debug(fmt.Sprintf("Fetched %d DNS records", len(rr)), "type", recordType, "records", records)
 	}
 }
 
-func deleteRecord(ctx context.Context, client *godo.Client, zone string, name string) (bool, error) {
+func deleteRecord(ctx context.Context, client *godo.Client, zone string, name string) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.deleteRecord", attribute.String("record", name))
+	defer func() { tracing.End(span, err) }()
+
 	opt := &godo.ListOptions{
 		Page:    1,
 		PerPage: 1000,
@@ -257,13 +554,13 @@ func deleteRecord(ctx context.Context, client *godo.Client, zone string, name st
 
 	txtRecords, _, err := client.Domains.RecordsByTypeAndName(ctx, zone, "TXT", name, opt)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return false, err
 	}
 
 	aRecords, _, err := client.Domains.RecordsByTypeAndName(ctx, zone, "A", name, opt)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
+		metrics.ExecErrInc(err)
 		return false, err
 	}
 
@@ -272,51 +569,68 @@ func deleteRecord(ctx context.Context, client *godo.Client, zone string, name st
 	for _, record := range records {
 		logger.Debug("Deleting", "record", record)
 		response, err := client.Domains.DeleteRecord(ctx, zone, record.ID)
+		metrics.RecordOperation(cfg.Provider, record.Type, "delete", err)
+		audit.Record(ctx, audit.Entry{Action: "delete", Record: recordFQDN(record.Name), Type: record.Type, Before: &audit.Value{Content: record.Data, TTL: record.TTL}, Status: responseStatus(response)}, err)
 		if err != nil {
-			metrics.ExecErrInc(err.Error())
+			metrics.ExecErrInc(err)
 			return false, err
 		}
-		logger.Info("Deleted DNS record", "zone", zone, "record", record.Name, "type", record.Type, "responseStatus", response.Status)
+		logger.Info("Deleted DNS record", "zone", zone, "record", record.Name, "type", record.Type, "responseStatus", response.Status, "action", "delete")
 	}
 	return true, nil
 }
 
-func addRecord(ctx context.Context, client *godo.Client, zone string, name string, sub string, addressIPv4 string, txtRecordName string, txtLabel string, env string) (bool, error) {
-	if txtRecordName == "" {
-		txtRecordName = name
-	}
+func addRecord(ctx context.Context, client *godo.Client, zone string, name string, addressIPv4 string, txtLabel string, ttl int) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.addRecord", attribute.String("record", name))
+	defer func() { tracing.End(span, err) }()
 
 	if txtLabel == "" {
 		txtLabel = label
 	}
 
+	// The name includes the subdomain, a workaround for subdomains to work properly on digital ocean.
 	aRecordRequest := &godo.DomainRecordEditRequest{
 		Type: "A",
-		Name: fmt.Sprintf("%s.%s", name, sub), // Workaround for subdomains to work properly on digital ocean.
+		Name: name,
 		Data: addressIPv4,
-		TTL:  1800,
+		TTL:  ttl,
 	}
 
 	txtRecordRequest := &godo.DomainRecordEditRequest{
 		Type: "TXT",
-		Name: fmt.Sprintf("%s.%s", txtRecordName, sub),
+		Name: name,
 		Data: txtLabel,
-		TTL:  1800,
+		TTL:  ttl,
 	}
 
-	_, aRecordResponse, err := client.Domains.CreateRecord(ctx, zone, aRecordRequest)
+	aRecord, aRecordResponse, err := client.Domains.CreateRecord(ctx, zone, aRecordRequest)
+	metrics.RecordOperation(cfg.Provider, "A", "create", err)
+	audit.Record(ctx, audit.Entry{Action: "create", Record: recordFQDN(name), Type: "A", After: &audit.Value{Content: addressIPv4, TTL: ttl}, Status: responseStatus(aRecordResponse)}, err)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		return false, err
+		metrics.ExecErrInc(err)
+		return nil, err
 	}
-	logger.Info("Added record", "zone", zone, "name", name, "type", "A", "responseStatus", aRecordResponse.Status)
+	logger.Info("Added record", "zone", zone, "record", name, "type", "A", "ttl", ttl, "responseStatus", aRecordResponse.Status, "action", "create")
 
-	_, txtRecordResponse, err := client.Domains.CreateRecord(ctx, zone, txtRecordRequest)
+	txtRecord, txtRecordResponse, err := client.Domains.CreateRecord(ctx, zone, txtRecordRequest)
+	metrics.RecordOperation(cfg.Provider, "TXT", "create", err)
+	audit.Record(ctx, audit.Entry{Action: "create", Record: recordFQDN(name), Type: "TXT", After: &audit.Value{Content: txtLabel, TTL: ttl}, Status: responseStatus(txtRecordResponse)}, err)
 	if err != nil {
-		metrics.ExecErrInc(err.Error())
-		return false, err
+		metrics.ExecErrInc(err)
+		// Roll back the A record, as it would never be seen by a sync without
+		// its TXT record. ctx may already be cancelled, hence the separate context.
+		rctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+		defer cancel()
+		rresponse, rerr := client.Domains.DeleteRecord(rctx, zone, aRecord.ID)
+		metrics.RecordOperation(cfg.Provider, "A", "delete", rerr)
+		audit.Record(ctx, audit.Entry{Action: "delete", Record: recordFQDN(name), Type: "A", Before: &audit.Value{Content: addressIPv4, TTL: ttl}, Status: responseStatus(rresponse)}, rerr)
+		if rerr != nil {
+			metrics.ExecErrInc(rerr)
+			logger.Error("Error occured while rolling back record", "zone", zone, "record", name, "type", "A", "error", rerr.Error())
+		}
+		return nil, err
 	}
-	logger.Info("Added DNS record", "zone", zone, "name", name, "type", "TXT", "responseStatus", txtRecordResponse.Status)
+	logger.Info("Added DNS record", "zone", zone, "record", name, "type", "TXT", "responseStatus", txtRecordResponse.Status, "action", "create")
 
-	return true, err
+	return []string{strconv.Itoa(aRecord.ID), strconv.Itoa(txtRecord.ID)}, nil
 }
@@ -0,0 +1,297 @@
+package digitalocean
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	common "github.com/gathertown/casper-3/pkg"
+)
+
+// fakeAPI is an in-memory DigitalOcean API serving the records of cfg.Zone.
+type fakeAPI struct {
+	mu      sync.Mutex
+	records []godo.DomainRecord
+	nextID  int
+	// fail, if set, fails the creates of the records it returns true for.
+	fail func(godo.DomainRecordEditRequest) bool
+	// failDelete, if set, fails the deletes of the records it returns true for.
+	failDelete func(godo.DomainRecord) bool
+}
+
+// newFakeAPI returns a fake API with records, used by the clients until the
+// test ends.
+func newFakeAPI(t *testing.T, records ...godo.DomainRecord) *fakeAPI {
+	t.Helper()
+	f := &fakeAPI{}
+	for _, r := range records {
+		f.create(r)
+	}
+	server := httptest.NewServer(f)
+	baseURL = server.URL + "/"
+	t.Cleanup(func() {
+		baseURL = ""
+		server.Close()
+	})
+	return f
+}
+
+func (f *fakeAPI) create(r godo.DomainRecord) godo.DomainRecord {
+	f.nextID++
+	r.ID = f.nextID
+	f.records = append(f.records, r)
+	return r
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prefix := "/v2/domains/" + cfg.Zone
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	switch {
+	case !strings.HasPrefix(r.URL.Path, prefix):
+		respond(w, http.StatusNotFound, nil)
+	case path == "" && r.Method == http.MethodGet:
+		respond(w, http.StatusOK, map[string]interface{}{"domain": godo.Domain{Name: cfg.Zone}})
+	case path == "/records" && r.Method == http.MethodGet:
+		respond(w, http.StatusOK, map[string]interface{}{"domain_records": f.list(r.URL.Query().Get("type"), r.URL.Query().Get("name"))})
+	case path == "/records" && r.Method == http.MethodPost:
+		var request godo.DomainRecordEditRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		if f.fail != nil && f.fail(request) {
+			respond(w, http.StatusUnprocessableEntity, nil)
+			return
+		}
+		record := f.create(godo.DomainRecord{Type: request.Type, Name: request.Name, Data: request.Data, TTL: request.TTL})
+		respond(w, http.StatusCreated, map[string]interface{}{"domain_record": record})
+	case strings.HasPrefix(path, "/records/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(path, "/records/"))
+		for i, record := range f.records {
+			if record.ID != id {
+				continue
+			}
+			switch r.Method {
+			case http.MethodDelete:
+				if f.failDelete != nil && f.failDelete(record) {
+					respond(w, http.StatusUnprocessableEntity, nil)
+					return
+				}
+				f.records = append(f.records[:i], f.records[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+			case http.MethodPut:
+				var request godo.DomainRecordEditRequest
+				_ = json.NewDecoder(r.Body).Decode(&request)
+				f.records[i] = godo.DomainRecord{ID: id, Type: request.Type, Name: request.Name, Data: request.Data, TTL: request.TTL}
+				respond(w, http.StatusOK, map[string]interface{}{"domain_record": f.records[i]})
+			}
+			return
+		}
+		respond(w, http.StatusNotFound, nil)
+	default:
+		respond(w, http.StatusNotFound, nil)
+	}
+}
+
+// list returns the records of recordType, named fqdn if not empty.
+func (f *fakeAPI) list(recordType string, fqdn string) []godo.DomainRecord {
+	records := []godo.DomainRecord{}
+	for _, record := range f.records {
+		if (recordType == "" || record.Type == recordType) && (fqdn == "" || recordFQDN(record.Name) == fqdn) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// zone returns the records as sorted "name type data" lines.
+func (f *fakeAPI) zone() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var lines []string
+	for _, r := range f.records {
+		lines = append(lines, fmt.Sprintf("%s %s %s", r.Name, r.Type, r.Data))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func respond(w http.ResponseWriter, code int, body interface{}) {
+	if code >= http.StatusBadRequest {
+		body = map[string]interface{}{"id": "error", "message": http.StatusText(code)}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func node(name string, ip string) Node {
+	return Node{Name: name, ExternalIP: ip, RecordOptions: common.RecordOptions{Hostname: name}}
+}
+
+func TestSync(t *testing.T) {
+	f := newFakeAPI(t,
+		godo.DomainRecord{Type: "TXT", Name: recordName("sfu-8quob"), Data: label},
+		godo.DomainRecord{Type: "A", Name: recordName("sfu-8quob"), Data: "1.1.1.2"},
+		godo.DomainRecord{Type: "A", Name: recordName("www"), Data: "1.1.1.9"},
+	)
+
+	changes := &common.Changes{}
+	d := DigitalOceanDNS{Changes: changes, Observed: &common.Observed{}}
+	if err := d.Sync(context.TODO(), []Node{node("sfu-8mh0d", "1.1.1.1")}); err != nil {
+		t.Fatalf("Sync() returned error: %v", err)
+	}
+
+	want := []string{
+		recordName("sfu-8mh0d") + " A 1.1.1.1",
+		recordName("sfu-8mh0d") + " TXT " + nodeLabel("sfu-8mh0d"),
+		recordName("www") + " A 1.1.1.9",
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+	if got := changes.Drain(); len(got) != 2 || got[0].Action != "create" || got[1].Action != "delete" {
+		t.Errorf("Expecting a create and a delete, got %+v", got)
+	}
+	// The A records of the owned names are observed, as found before the sync
+	observed := d.Observed.Drain()["node"]
+	if len(observed) != 2 || observed[1].Type != "A" || observed[1].Content != "1.1.1.2" {
+		t.Errorf("Expecting the TXT and A record of sfu-8quob, got %+v", observed)
+	}
+}
+
+func TestSyncContinuesAfterFailure(t *testing.T) {
+	f := newFakeAPI(t)
+	f.fail = func(r godo.DomainRecordEditRequest) bool { return r.Name == recordName("sfu-8mh0d") }
+
+	d := DigitalOceanDNS{Changes: &common.Changes{}}
+	err := d.Sync(context.TODO(), []Node{node("sfu-8mh0d", "1.1.1.1"), node("sfu-8quob", "1.1.1.2")})
+
+	var se *common.SyncError
+	if !errors.As(err, &se) || len(se.Records) != 1 || se.Records[0] != "sfu-8mh0d" {
+		t.Errorf("Expecting sfu-8mh0d to fail, got %v", err)
+	}
+	want := []string{
+		recordName("sfu-8quob") + " A 1.1.1.2",
+		recordName("sfu-8quob") + " TXT " + nodeLabel("sfu-8quob"),
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+}
+
+func TestSyncPodsRetriesMove(t *testing.T) {
+	txt := podLabel("default", "router-0", "node-a", "1.1.1.1")
+	f := newFakeAPI(t,
+		godo.DomainRecord{Type: "TXT", Name: recordName("router-0"), Data: txt},
+		godo.DomainRecord{Type: "A", Name: recordName("router-0"), Data: "1.1.1.1"},
+	)
+	f.failDelete = func(r godo.DomainRecord) bool { return true }
+
+	pod := Pod{Name: "router-0", Namespace: "default", AssignedNode: node("node-b", "1.1.1.2"), RecordOptions: common.RecordOptions{Hostname: "router-0"}}
+	d := DigitalOceanDNS{Changes: &common.Changes{}}
+	if err := d.SyncPods(context.TODO(), []Pod{pod}); err == nil {
+		t.Errorf("Expecting the move of router-0 to fail")
+	}
+	// The old pair is kept until a later reconcile deletes it, without a second pair
+	want := []string{
+		recordName("router-0") + " A 1.1.1.1",
+		recordName("router-0") + " TXT " + txt,
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+
+	f.failDelete = nil
+	if err := d.SyncPods(context.TODO(), []Pod{pod}); err != nil {
+		t.Fatalf("SyncPods() returned error: %v", err)
+	}
+	want = []string{
+		recordName("router-0") + " A 1.1.1.2",
+		recordName("router-0") + " TXT " + podLabel("default", "router-0", "node-b", "1.1.1.2"),
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+}
+
+func TestSyncUpdatesRecordOptions(t *testing.T) {
+	f := newFakeAPI(t,
+		godo.DomainRecord{Type: "TXT", Name: recordName("sfu-8mh0d"), Data: label},
+		godo.DomainRecord{Type: "A", Name: recordName("sfu-8mh0d"), Data: "1.1.1.1", TTL: defaultTTL},
+		godo.DomainRecord{Type: "TXT", Name: recordName("router-0"), Data: podLabel("default", "router-0", "sfu-8mh0d", "1.1.1.1")},
+		godo.DomainRecord{Type: "A", Name: recordName("router-0"), Data: "1.1.1.1", TTL: defaultTTL},
+	)
+
+	changes := &common.Changes{}
+	d := DigitalOceanDNS{Changes: changes}
+	n := node("sfu-8mh0d", "1.1.1.1")
+	n.TTL = 60
+	if err := d.Sync(context.TODO(), []Node{n}); err != nil {
+		t.Fatalf("Sync() returned error: %v", err)
+	}
+	pod := Pod{Name: "router-0", Namespace: "default", AssignedNode: node("sfu-8mh0d", "1.1.1.1"), RecordOptions: common.RecordOptions{Hostname: "router-0", TTL: 120}}
+	if err := d.SyncPods(context.TODO(), []Pod{pod}); err != nil {
+		t.Fatalf("SyncPods() returned error: %v", err)
+	}
+
+	want := map[string]int{recordName("sfu-8mh0d"): 60, recordName("router-0"): 120}
+	for _, record := range f.records {
+		if record.Type == "A" && record.TTL != want[record.Name] {
+			t.Errorf("Expecting TTL %d for %s, got %d", want[record.Name], record.Name, record.TTL)
+		}
+	}
+	if got := changes.Drain(); len(got) != 2 || got[0].Action != "update" || got[1].Action != "update" {
+		t.Errorf("Expecting two updates, got %+v", got)
+	}
+
+	// Records that match their annotations are left alone
+	if err := d.Sync(context.TODO(), []Node{n}); err != nil {
+		t.Fatalf("Sync() returned error: %v", err)
+	}
+	if err := d.SyncPods(context.TODO(), []Pod{pod}); err != nil {
+		t.Fatalf("SyncPods() returned error: %v", err)
+	}
+	if got := changes.Drain(); len(got) != 0 {
+		t.Errorf("Expecting no changes, got %+v", got)
+	}
+}
+
+func TestSyncReportsObjects(t *testing.T) {
+	newFakeAPI(t,
+		// A cordoned node, its record name is not the name of the node
+		godo.DomainRecord{Type: "TXT", Name: recordName("ip-10-0-0-1"), Data: nodeLabel("ip-10-0-0-1.ec2.internal")},
+		godo.DomainRecord{Type: "A", Name: recordName("ip-10-0-0-1"), Data: "1.1.1.1"},
+		godo.DomainRecord{Type: "TXT", Name: recordName("router-1"), Data: podLabel("media", "router-1", "ip-10-0-0-1.ec2.internal", "1.1.1.1")},
+		godo.DomainRecord{Type: "A", Name: recordName("router-1"), Data: "1.1.1.1"},
+	)
+
+	changes := &common.Changes{}
+	d := DigitalOceanDNS{Changes: changes}
+	if err := d.Sync(context.TODO(), []Node{node("ip-10-0-0-2", "1.1.1.2")}); err != nil {
+		t.Fatalf("Sync() returned error: %v", err)
+	}
+	if err := d.SyncPods(context.TODO(), nil); err != nil {
+		t.Fatalf("SyncPods() returned error: %v", err)
+	}
+
+	var deleted []string
+	for _, c := range changes.Drain() {
+		if c.Action == "delete" {
+			deleted = append(deleted, c.Kind+" "+c.Namespace+"/"+c.Name)
+		}
+	}
+	want := []string{"node /ip-10-0-0-1.ec2.internal", "pod media/router-1"}
+	if strings.Join(deleted, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting deletes of %q, got %q", want, deleted)
+	}
+}
@@ -0,0 +1,22 @@
+package digitalocean
+
+import (
+	"errors"
+
+	"github.com/digitalocean/godo"
+	"github.com/gathertown/casper-3/internal/metrics"
+)
+
+func init() {
+	metrics.RegisterStatusCode(statusCode)
+}
+
+// statusCode returns the HTTP status code of an error of the DigitalOcean API,
+// or 0 if unknown.
+func statusCode(err error) int {
+	var doErr *godo.ErrorResponse
+	if errors.As(err, &doErr) && doErr.Response != nil {
+		return doErr.Response.StatusCode
+	}
+	return 0
+}
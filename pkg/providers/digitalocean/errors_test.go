@@ -0,0 +1,32 @@
+package digitalocean
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"github.com/gathertown/casper-3/internal/metrics"
+)
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"rate limited", &godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusTooManyRequests}}, metrics.CategoryRateLimited},
+		{"unavailable", &godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusBadGateway}}, metrics.CategoryUnavailable},
+		{"invalid", fmt.Errorf("adding record: %w", &godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusUnprocessableEntity}}), metrics.CategoryInvalid},
+		{"without response", &godo.ErrorResponse{}, metrics.CategoryOther},
+		{"other", errors.New("deleteRecord() wants to delete wrong record"), metrics.CategoryOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metrics.ErrorCategory(tt.err); got != tt.want {
+				t.Errorf("Expecting category %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
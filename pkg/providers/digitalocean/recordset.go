@@ -0,0 +1,315 @@
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
+	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/audit"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type RecordSet = common.RecordSet
+
+// SyncRecordSets publishes the record sets of the given kind, e.g. "service",
+// and removes the owned record sets of that kind that are no longer wanted.
+// The targets of a record set are compared with the records in the zone, so
+// that targets joining and leaving are updated in place. The records of kind
+// are set on d.Observed as they are once synced.
+func (d DigitalOceanDNS) SyncRecordSets(ctx context.Context, kind string, sets []RecordSet) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.SyncRecordSets")
+	defer func() { tracing.End(span, err) }()
+
+	var failed []string
+	var observed []common.Record
+
+	// Setup the client
+	client := NewDOClient()
+
+	// The source of truth are the TXT records of the record sets of this kind.
+	txtRecords, err := getRecords(ctx, client, cfg.Zone, "TXT")
+	if err != nil {
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", "TXT", "error", err.Error())
+		return err
+	}
+
+	owned := make(map[string]godo.DomainRecord)
+	for _, record := range txtRecords {
+		if common.IsOwned(record.Data, cfg.Env) && common.LabelKind(record.Data) == kind {
+			owned[record.Name] = record
+		}
+	}
+
+	// Record names are relative to the zone, e.g. "router.dev"
+	desired := make(map[string]RecordSet)
+	for _, set := range sets {
+		desired[recordName(set.TXTName())] = set
+	}
+	metrics.DNSOwnedRecords(cfg.Provider, kind, float64(len(owned)))
+	logger.Debug("Record sets found", "kind", kind, "sets", len(sets), "owned", len(owned))
+
+	// Remove stale record sets first, a CNAME record may replace the A records of a name.
+	for txtName, txt := range owned {
+		if _, found := desired[txtName]; found {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		recordType := common.ParseLabel(txt.Data)["type"]
+		name := common.RecordSetName(strings.TrimSuffix(txtName, recordName("")), recordType)
+		logger.Info("Deleting record set", "zone", cfg.Zone, "kind", kind, "record", recordName(name), "type", recordType, "action", "delete")
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		pctx = audit.WithObject(pctx, "", "", common.ParseLabel(txt.Data)["owner"])
+		err := deleteRecordSet(pctx, client, cfg.Zone, recordName(name), recordType, txt)
+		cancel()
+		if err != nil {
+			metrics.ExecErrInc(err)
+			failed = append(failed, name)
+			logger.Error("Error occured while deleting record set", "zone", cfg.Zone, "kind", kind, "record", recordName(name), "error", err.Error())
+			observed = append(observed, common.Record{Kind: kind, FQDN: recordFQDN(txt.Name), Type: txt.Type, Content: txt.Data})
+		}
+	}
+
+	for _, txtName := range sortedKeys(desired) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		set := desired[txtName]
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		pctx = audit.WithObject(pctx, "", "", set.Owner)
+		txt, found := owned[txtName]
+		err := syncRecordSet(pctx, client, cfg.Zone, kind, set, txt, found)
+		cancel()
+		if err != nil {
+			metrics.ExecErrInc(err)
+			failed = append(failed, set.Name)
+			logger.Error("Error occured while syncing record set", "zone", cfg.Zone, "kind", kind, "record", recordName(set.Name), "error", err.Error())
+		}
+		if d.Observed != nil {
+			observed = append(observed, recordSetRecords(ctx, client, cfg.Zone, kind, set, err == nil)...)
+		}
+	}
+	d.Observed.Set(kind, observed)
+
+	return common.SyncErr(failed)
+}
+
+// syncRecordSet creates the ownership TXT record of set, if it is not found
+// and the name is free, and then adds, updates and removes records so that the
+// zone matches its targets and TTL.
+func syncRecordSet(ctx context.Context, client *godo.Client, zone string, kind string, set RecordSet, txt godo.DomainRecord, found bool) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.syncRecordSet", attribute.String("record", set.Name), attribute.String("type", set.Type))
+	defer func() { tracing.End(span, err) }()
+
+	name := recordName(set.Name)
+	txtLabel := common.RecordSetLabel(kind, cfg.Env, set)
+
+	// The TXT record goes first, so that records are never created without an owner.
+	if !found {
+		if err := checkNameFree(ctx, client, zone, name); err != nil {
+			return err
+		}
+		if err := createRecord(ctx, client, zone, "TXT", recordName(set.TXTName()), txtLabel, defaultTTL); err != nil {
+			return err
+		}
+	} else if txt.Data != txtLabel {
+		request := &godo.DomainRecordEditRequest{Type: "TXT", Name: txt.Name, Data: txtLabel, TTL: txt.TTL}
+		_, response, err := client.Domains.EditRecord(ctx, zone, txt.ID, request)
+		metrics.RecordOperation(cfg.Provider, "TXT", "update", err)
+		audit.Record(ctx, audit.Entry{Action: "update", Record: recordFQDN(txt.Name), Type: "TXT", Before: &audit.Value{Content: txt.Data, TTL: txt.TTL}, After: &audit.Value{Content: txtLabel, TTL: txt.TTL}, Status: responseStatus(response)}, err)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return err
+		}
+		logger.Info("Updated record", "zone", zone, "record", txt.Name, "type", "TXT", "content", txtLabel, "action", "update")
+	}
+
+	existing, err := getRecordsPerTypeAndName(ctx, client, zone, set.Type, name)
+	if err != nil {
+		return err
+	}
+
+	ttl := set.TTLOr(defaultTTL)
+	current := make(map[string]bool)
+	for _, record := range existing {
+		current[strings.TrimSuffix(record.Data, ".")] = true
+	}
+	var missing []string
+	for _, target := range set.Targets {
+		if !current[target] {
+			missing = append(missing, target)
+		}
+	}
+	for _, record := range existing {
+		data := record.Data
+		if !contains(set.Targets, strings.TrimSuffix(record.Data, ".")) {
+			// Point a stale record to a missing target rather than adding one,
+			// a name can't have two CNAME records, not even for a moment
+			if len(missing) == 0 {
+				continue
+			}
+			data, missing = recordData(set.Type, missing[0]), missing[1:]
+		} else if record.TTL == ttl {
+			continue
+		}
+		request := &godo.DomainRecordEditRequest{Type: record.Type, Name: record.Name, Data: data, TTL: ttl}
+		_, response, err := client.Domains.EditRecord(ctx, zone, record.ID, request)
+		metrics.RecordOperation(cfg.Provider, record.Type, "update", err)
+		audit.Record(ctx, audit.Entry{Action: "update", Record: recordFQDN(record.Name), Type: record.Type, Before: &audit.Value{Content: record.Data, TTL: record.TTL}, After: &audit.Value{Content: data, TTL: ttl}, Status: responseStatus(response)}, err)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return err
+		}
+		logger.Info("Updated record", "zone", zone, "record", record.Name, "type", record.Type, "content", data, "ttl", ttl, "action", "update")
+	}
+	for _, target := range missing {
+		if err := createRecord(ctx, client, zone, set.Type, name, recordData(set.Type, target), ttl); err != nil {
+			return err
+		}
+	}
+
+	return deleteRecordsPerType(ctx, client, zone, name, set.Type, set.Targets)
+}
+
+// recordSetRecords returns the records of set in the zone once synced. They
+// are fetched if the sync failed.
+func recordSetRecords(ctx context.Context, client *godo.Client, zone string, kind string, set RecordSet, synced bool) []common.Record {
+	name := recordName(set.Name)
+	if synced {
+		records := []common.Record{{Kind: kind, FQDN: recordFQDN(recordName(set.TXTName())), Type: "TXT", Content: common.RecordSetLabel(kind, cfg.Env, set)}}
+		for _, target := range set.Targets {
+			records = append(records, common.Record{Kind: kind, FQDN: recordFQDN(name), Type: set.Type, Content: target})
+		}
+		return records
+	}
+
+	var records []common.Record
+	for _, lookup := range []godo.DomainRecord{{Name: recordName(set.TXTName()), Type: "TXT"}, {Name: name, Type: set.Type}} {
+		existing, err := getRecordsPerTypeAndName(ctx, client, zone, lookup.Type, lookup.Name)
+		if err != nil {
+			logger.Warn("Error occured while fetching records", "zone", cfg.Zone, "record", lookup.Name, "type", lookup.Type, "error", err.Error())
+			continue
+		}
+		for _, record := range existing {
+			if record.Type == "TXT" && !(common.IsOwned(record.Data, cfg.Env) && common.LabelKind(record.Data) == kind) {
+				continue
+			}
+			records = append(records, common.Record{Kind: kind, FQDN: recordFQDN(record.Name), Type: record.Type, Content: record.Data})
+		}
+	}
+	return records
+}
+
+// checkNameFree returns common.ErrNameTaken if name has A or CNAME records,
+// e.g. added by hand or published for a node, that a new record set would
+// take over.
+func checkNameFree(ctx context.Context, client *godo.Client, zone string, name string) error {
+	for _, recordType := range []string{"A", "CNAME"} {
+		records, err := getRecordsPerTypeAndName(ctx, client, zone, recordType, name)
+		if err != nil {
+			return err
+		}
+		if len(records) > 0 {
+			return fmt.Errorf("%s %s: %w", recordFQDN(name), recordType, common.ErrNameTaken)
+		}
+	}
+	return nil
+}
+
+// deleteRecordSet removes all records of a record set and then its TXT record.
+func deleteRecordSet(ctx context.Context, client *godo.Client, zone string, name string, recordType string, txt godo.DomainRecord) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.deleteRecordSet", attribute.String("record", name), attribute.String("type", recordType))
+	defer func() { tracing.End(span, err) }()
+
+	if err := deleteRecordsPerType(ctx, client, zone, name, recordType, nil); err != nil {
+		return err
+	}
+	response, err := client.Domains.DeleteRecord(ctx, zone, txt.ID)
+	metrics.RecordOperation(cfg.Provider, "TXT", "delete", err)
+	audit.Record(ctx, audit.Entry{Action: "delete", Record: recordFQDN(txt.Name), Type: "TXT", Before: &audit.Value{Content: txt.Data, TTL: txt.TTL}, Status: responseStatus(response)}, err)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+	logger.Info("Deleted DNS record", "zone", zone, "record", txt.Name, "type", "TXT", "responseStatus", response.Status, "action", "delete")
+	return nil
+}
+
+// deleteRecordsPerType removes the records of name of the given type whose
+// data is not one of keep.
+func deleteRecordsPerType(ctx context.Context, client *godo.Client, zone string, name string, recordType string, keep []string) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.deleteRecordsPerType")
+	defer func() { tracing.End(span, err) }()
+
+	if recordType != "A" && recordType != "CNAME" {
+		return nil
+	}
+	records, err := getRecordsPerTypeAndName(ctx, client, zone, recordType, name)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if contains(keep, strings.TrimSuffix(record.Data, ".")) {
+			continue
+		}
+		response, err := client.Domains.DeleteRecord(ctx, zone, record.ID)
+		metrics.RecordOperation(cfg.Provider, record.Type, "delete", err)
+		audit.Record(ctx, audit.Entry{Action: "delete", Record: recordFQDN(record.Name), Type: record.Type, Before: &audit.Value{Content: record.Data, TTL: record.TTL}, Status: responseStatus(response)}, err)
+		if err != nil {
+			metrics.ExecErrInc(err)
+			return err
+		}
+		logger.Info("Deleted DNS record", "zone", zone, "record", record.Name, "type", record.Type, "content", record.Data, "responseStatus", response.Status, "action", "delete")
+	}
+	return nil
+}
+
+// recordData returns the data of a record of recordType pointing to target.
+func recordData(recordType string, target string) string {
+	if recordType == "CNAME" {
+		// DigitalOcean expects a fully qualified hostname
+		return target + "."
+	}
+	return target
+}
+
+// getRecordsPerTypeAndName returns the records of name, relative to the zone.
+func getRecordsPerTypeAndName(ctx context.Context, client *godo.Client, zone string, recordType string, name string) (_ []godo.DomainRecord, err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.RecordsByTypeAndName")
+	defer func() { tracing.End(span, err) }()
+
+	opt := &godo.ListOptions{
+		Page:    1,
+		PerPage: 200,
+	}
+	records, _, err := client.Domains.RecordsByTypeAndName(ctx, zone, recordType, recordFQDN(name), opt)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return nil, err
+	}
+	return records, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]RecordSet) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package digitalocean
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	common "github.com/gathertown/casper-3/pkg"
+)
+
+func TestSyncRecordSets(t *testing.T) {
+	turn := RecordSet{Name: "turn", Type: "A", Targets: []string{"1.1.1.1", "1.1.1.2"}, Owner: "record/default/turn", TTL: 60}
+	www := RecordSet{Name: "www", Type: "A", Targets: []string{"1.1.1.5"}, Owner: "record/default/www"}
+	f := newFakeAPI(t,
+		godo.DomainRecord{Type: "TXT", Name: recordName("turn"), Data: common.RecordSetLabel("record", cfg.Env, turn)},
+		godo.DomainRecord{Type: "A", Name: recordName("turn"), Data: "1.1.1.1", TTL: defaultTTL},
+		godo.DomainRecord{Type: "A", Name: recordName("turn"), Data: "1.1.1.3", TTL: defaultTTL},
+		// Added by hand, not owned by a record set
+		godo.DomainRecord{Type: "A", Name: recordName("www"), Data: "1.1.1.9", TTL: defaultTTL},
+	)
+
+	d := DigitalOceanDNS{Observed: &common.Observed{}}
+	err := d.SyncRecordSets(context.TODO(), "record", []RecordSet{turn, www})
+	if err == nil || !strings.Contains(err.Error(), "www") {
+		t.Errorf("Expecting www to fail, got %v", err)
+	}
+
+	want := []string{
+		recordName("turn") + " A 1.1.1.1",
+		recordName("turn") + " A 1.1.1.2",
+		recordName("turn") + " TXT " + common.RecordSetLabel("record", cfg.Env, turn),
+		recordName("www") + " A 1.1.1.9",
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+
+	// The records of www are fetched as its sync failed
+	var observed []string
+	for _, record := range d.Observed.Drain()["record"] {
+		observed = append(observed, record.FQDN+" "+record.Type+" "+record.Content)
+	}
+	wantObserved := []string{
+		recordFQDN(recordName("turn")) + " TXT " + common.RecordSetLabel("record", cfg.Env, turn),
+		recordFQDN(recordName("turn")) + " A 1.1.1.1",
+		recordFQDN(recordName("turn")) + " A 1.1.1.2",
+		recordFQDN(recordName("www")) + " A 1.1.1.9",
+	}
+	if strings.Join(observed, "\n") != strings.Join(wantObserved, "\n") {
+		t.Errorf("Expecting observed records %q, got %q", wantObserved, observed)
+	}
+	for _, record := range f.records {
+		if record.Name == recordName("turn") && record.Type == "A" && record.TTL != 60 {
+			t.Errorf("Expecting TTL 60 for %s, got %d", record.Data, record.TTL)
+		}
+	}
+}
+
+func TestSyncRecordSetsRetargetsCNAME(t *testing.T) {
+	app := RecordSet{Name: "app", Type: "CNAME", Targets: []string{"lb-2.example.com"}, Owner: "service/default/app"}
+	f := newFakeAPI(t,
+		godo.DomainRecord{Type: "TXT", Name: recordName(app.TXTName()), Data: common.RecordSetLabel("service", cfg.Env, app)},
+		godo.DomainRecord{Type: "CNAME", Name: recordName("app"), Data: "lb-1.example.com.", TTL: defaultTTL},
+	)
+	// Like DigitalOcean, reject a second CNAME record for the name
+	f.fail = func(r godo.DomainRecordEditRequest) bool { return r.Type == "CNAME" }
+
+	d := DigitalOceanDNS{}
+	if err := d.SyncRecordSets(context.TODO(), "service", []RecordSet{app}); err != nil {
+		t.Fatalf("SyncRecordSets() returned error: %v", err)
+	}
+
+	want := []string{
+		recordName("app") + " CNAME lb-2.example.com.",
+		recordName(app.TXTName()) + " TXT " + common.RecordSetLabel("service", cfg.Env, app),
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+}
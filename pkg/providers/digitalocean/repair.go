@@ -0,0 +1,194 @@
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/digitalocean/godo"
+	"github.com/gathertown/casper-3/internal/metrics"
+	"github.com/gathertown/casper-3/internal/tracing"
+	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/audit"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// pair is the desired content of an A/TXT record pair.
+type pair struct {
+	addressIPv4 string
+	ttl         int
+	label       string
+}
+
+// Repair completes or removes owned record pairs of which only the TXT record
+// exists, e.g. after a failure between the two creates of addRecord. An A
+// record is only marked as owned by its TXT record, so A records without one
+// may have been added by hand. Their TXT record is only added when they point
+// to the address of the node or pod of that name, the others are reported.
+func (d DigitalOceanDNS) Repair(ctx context.Context, nodes []Node, pods []Pod) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.Repair")
+	defer func() { tracing.End(span, err) }()
+
+	var failed []string
+
+	// Setup the client
+	client := NewDOClient()
+
+	txtRecords, err := getRecords(ctx, client, cfg.Zone, "TXT")
+	if err != nil {
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", "TXT", "error", err.Error())
+		return err
+	}
+
+	aRecords, err := getRecords(ctx, client, cfg.Zone, "A")
+	if err != nil {
+		logger.Error("Error occured while fetching records", "zone", cfg.Zone, "type", "A", "error", err.Error())
+		return err
+	}
+
+	// Record names are relative to the zone, e.g. "sfu-v81hha.dev"
+	desired := make(map[string]pair)
+	for _, node := range nodes {
+		desired[optionsName(node.RecordOptions)] = pair{node.ExternalIP, node.TTLOr(defaultTTL), nodeLabel(node.Name)}
+	}
+	for _, pod := range pods {
+		desired[optionsName(pod.RecordOptions)] = pair{pod.AssignedNode.ExternalIP, pod.TTLOr(defaultTTL), podLabel(pod.Namespace, pod.Name, pod.AssignedNode.Name, pod.AssignedNode.ExternalIP)}
+	}
+
+	owned := make(map[string]godo.DomainRecord)
+	for _, record := range txtRecords {
+		// Record sets are kept consistent by SyncRecordSets
+		kind := common.LabelKind(record.Data)
+		if common.IsOwned(record.Data, cfg.Env) && (kind == "node" || kind == "pod") {
+			owned[record.Name] = record
+		}
+	}
+
+	addresses := make(map[string]godo.DomainRecord)
+	for _, record := range aRecords {
+		addresses[record.Name] = record
+	}
+
+	var txtOnly, aOnly []string
+	for name := range owned {
+		if _, found := addresses[name]; !found {
+			txtOnly = append(txtOnly, name)
+		}
+	}
+	for name := range desired {
+		_, hasA := addresses[name]
+		_, hasTXT := owned[name]
+		if hasA && !hasTXT {
+			aOnly = append(aOnly, name)
+		}
+	}
+	sort.Strings(txtOnly)
+	sort.Strings(aOnly)
+
+	metrics.DNSInconsistentRecords(cfg.Provider, "txt_without_a", float64(len(txtOnly)))
+	metrics.DNSInconsistentRecords(cfg.Provider, "a_without_txt", float64(len(aOnly)))
+
+	// A TXT record without its A record makes the name look published. Create the
+	// A record if the name is still wanted, otherwise remove the TXT record.
+	for _, name := range txtOnly {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		if p, found := desired[name]; found && p.addressIPv4 != "" {
+			logger.Info("Completing record pair", "zone", cfg.Zone, "record", name, "type", "A", "content", p.addressIPv4, "action", "create")
+			err = createRecord(pctx, client, cfg.Zone, "A", name, p.addressIPv4, p.ttl)
+		} else {
+			logger.Info("Removing orphaned record", "zone", cfg.Zone, "record", name, "type", "TXT", "action", "delete")
+			var response *godo.Response
+			response, err = client.Domains.DeleteRecord(pctx, cfg.Zone, owned[name].ID)
+			metrics.RecordOperation(cfg.Provider, "TXT", "delete", err)
+			audit.Record(pctx, audit.Entry{Action: "delete", Record: recordFQDN(name), Type: "TXT", Before: &audit.Value{Content: owned[name].Data, TTL: owned[name].TTL}, Status: responseStatus(response)}, err)
+		}
+		cancel()
+		if err != nil {
+			metrics.ExecErrInc(err)
+			failed = append(failed, name)
+			logger.Error("Error occured while repairing record", "zone", cfg.Zone, "record", name, "error", err.Error())
+		}
+	}
+
+	// An A record without its TXT record is never seen by Sync, but it may as
+	// well be managed by hand. Take it over only if it already points where
+	// casper-3 would point it, e.g. after a failure in the middle of a move.
+	for _, name := range aOnly {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p := desired[name]
+		if p.addressIPv4 == "" || addresses[name].Data != p.addressIPv4 {
+			logger.Warn("Found A record without ownership record, leaving it alone", "zone", cfg.Zone, "record", name, "type", "A", "content", addresses[name].Data)
+			continue
+		}
+		logger.Info("Completing record pair", "zone", cfg.Zone, "record", name, "type", "TXT", "action", "create")
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		err := createRecord(pctx, client, cfg.Zone, "TXT", name, p.label, p.ttl)
+		cancel()
+		if err != nil {
+			metrics.ExecErrInc(err)
+			failed = append(failed, name)
+			logger.Error("Error occured while repairing record", "zone", cfg.Zone, "record", name, "error", err.Error())
+		}
+	}
+
+	return common.SyncErr(failed)
+}
+
+// recordName returns the name of the records of name relative to the zone, as
+// reported by DigitalOcean.
+func recordName(name string) string {
+	return subdomainName(name, cfg.Subdomain)
+}
+
+// optionsName returns the name of the records published with the record
+// options o, relative to the zone.
+func optionsName(o common.RecordOptions) string {
+	return subdomainName(o.Hostname, o.SubdomainOr(cfg.Subdomain))
+}
+
+func subdomainName(name string, subdomain string) string {
+	if subdomain != "" {
+		return fmt.Sprintf("%s.%s", name, subdomain)
+	}
+	return name
+}
+
+// responseStatus returns the HTTP status of a response, 0 if there was none.
+func responseStatus(response *godo.Response) int {
+	if response == nil || response.Response == nil {
+		return 0
+	}
+	return response.StatusCode
+}
+
+// recordFQDN returns the FQDN of a record name relative to the zone.
+func recordFQDN(name string) string {
+	return fmt.Sprintf("%s.%s", name, cfg.Zone)
+}
+
+func createRecord(ctx context.Context, client *godo.Client, zone string, recordType string, name string, data string, ttl int) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.createRecord", attribute.String("record", name), attribute.String("type", recordType))
+	defer func() { tracing.End(span, err) }()
+
+	request := &godo.DomainRecordEditRequest{
+		Type: recordType,
+		Name: name,
+		Data: data,
+		TTL:  ttl,
+	}
+
+	_, response, err := client.Domains.CreateRecord(ctx, zone, request)
+	metrics.RecordOperation(cfg.Provider, recordType, "create", err)
+	audit.Record(ctx, audit.Entry{Action: "create", Record: recordFQDN(name), Type: recordType, After: &audit.Value{Content: data, TTL: ttl}, Status: responseStatus(response)}, err)
+	if err != nil {
+		metrics.ExecErrInc(err)
+		return err
+	}
+	logger.Info("Added record", "zone", zone, "record", name, "type", recordType, "responseStatus", response.Status, "action", "create")
+	return nil
+}
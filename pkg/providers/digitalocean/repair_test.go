@@ -0,0 +1,40 @@
+package digitalocean
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestRepair(t *testing.T) {
+	f := newFakeAPI(t,
+		// A TXT record of a node without its A record
+		godo.DomainRecord{Type: "TXT", Name: recordName("sfu-8mh0d"), Data: label},
+		// A TXT record of a node that is gone
+		godo.DomainRecord{Type: "TXT", Name: recordName("sfu-8quob"), Data: label},
+		// An A record of a node without its TXT record, e.g. added by hand
+		godo.DomainRecord{Type: "A", Name: recordName("sfu-9cnbs"), Data: "1.1.1.9"},
+		// An A record of a node without its TXT record, pointing to the node
+		godo.DomainRecord{Type: "A", Name: recordName("sfu-7kd2x"), Data: "1.1.1.4"},
+		godo.DomainRecord{Type: "A", Name: recordName("www"), Data: "1.1.1.8"},
+	)
+
+	d := DigitalOceanDNS{}
+	if err := d.Repair(context.TODO(), []Node{node("sfu-8mh0d", "1.1.1.1"), node("sfu-9cnbs", "1.1.1.3"), node("sfu-7kd2x", "1.1.1.4")}, nil); err != nil {
+		t.Fatalf("Repair() returned error: %v", err)
+	}
+
+	want := []string{
+		recordName("sfu-7kd2x") + " A 1.1.1.4",
+		recordName("sfu-7kd2x") + " TXT " + nodeLabel("sfu-7kd2x"),
+		recordName("sfu-8mh0d") + " A 1.1.1.1",
+		recordName("sfu-8mh0d") + " TXT " + label,
+		recordName("sfu-9cnbs") + " A 1.1.1.9",
+		recordName("www") + " A 1.1.1.8",
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,98 @@
+package digitalocean
+
+import (
+	"context"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/gathertown/casper-3/internal/tracing"
+	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/snapshot"
+)
+
+// Records returns the A, AAAA and TXT records of the zone.
+func (d DigitalOceanDNS) Records(ctx context.Context) (_ []snapshot.Record, err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.ZoneRecords")
+	defer func() { tracing.End(span, err) }()
+
+	return zoneRecords(ctx, NewDOClient())
+}
+
+// zoneRecords returns the A, AAAA and TXT records of the zone, named by
+// their FQDN.
+func zoneRecords(ctx context.Context, client *godo.Client) ([]snapshot.Record, error) {
+	var records []snapshot.Record
+	for _, recordType := range snapshot.Types {
+		rs, err := getRecords(ctx, client, cfg.Zone, recordType)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rs {
+			name := cfg.Zone
+			if r.Name != "@" {
+				name = recordFQDN(r.Name)
+			}
+			records = append(records, snapshot.Record{Name: name, Type: r.Type, Content: r.Data, TTL: r.TTL})
+		}
+	}
+	return records, nil
+}
+
+// Restore creates the records missing from the zone, leaving the existing
+// ones alone. The record pairs of nodes and pods are created as by Sync, the
+// other records one by one. With dryRun, the records are only logged.
+func (d DigitalOceanDNS) Restore(ctx context.Context, records []snapshot.Record, dryRun bool) (err error) {
+	ctx, span := tracing.Start(ctx, "digitalocean.Restore")
+	defer func() { tracing.End(span, err) }()
+
+	client := NewDOClient()
+	existing, err := zoneRecords(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	pairs, others := snapshot.Pairs(snapshot.Missing(records, existing))
+	for _, p := range pairs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if dryRun {
+			logger.Info("Would restore record pair", "zone", cfg.Zone, "record", p.A.Name, "type", "A", "content", p.A.Content, "action", "create")
+			continue
+		}
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		_, err := addRecord(pctx, client, cfg.Zone, relativeName(p.A.Name), p.A.Content, p.TXT.Content, p.A.TTL)
+		cancel()
+		if err != nil {
+			failed = append(failed, p.A.Name)
+			logger.Error("Error occured while restoring record pair", "zone", cfg.Zone, "record", p.A.Name, "error", err.Error())
+		}
+	}
+	for _, r := range others {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if dryRun {
+			logger.Info("Would restore record", "zone", cfg.Zone, "record", r.Name, "type", r.Type, "content", r.Content, "action", "create")
+			continue
+		}
+		pctx, cancel := common.WithGrace(ctx, d.ShutdownTimeout)
+		err := createRecord(pctx, client, cfg.Zone, r.Type, relativeName(r.Name), r.Content, r.TTL)
+		cancel()
+		if err != nil {
+			failed = append(failed, r.Name)
+			logger.Error("Error occured while restoring record", "zone", cfg.Zone, "record", r.Name, "type", r.Type, "error", err.Error())
+		}
+	}
+	return common.SyncErr(failed)
+}
+
+// relativeName returns the name of a record relative to the zone, as the
+// DigitalOcean API expects it.
+func relativeName(fqdn string) string {
+	if fqdn == cfg.Zone {
+		return "@"
+	}
+	return strings.TrimSuffix(fqdn, "."+cfg.Zone)
+}
@@ -0,0 +1,64 @@
+package digitalocean
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	common "github.com/gathertown/casper-3/pkg"
+	"github.com/gathertown/casper-3/pkg/snapshot"
+)
+
+func TestRestore(t *testing.T) {
+	turn := common.RecordSetLabel("service", cfg.Env, RecordSet{Name: "turn", Type: "A", Owner: "service/infra/turn"})
+	f := newFakeAPI(t,
+		godo.DomainRecord{Type: "TXT", Name: recordName("sfu-b"), Data: label},
+		godo.DomainRecord{Type: "A", Name: recordName("sfu-b"), Data: "1.1.1.9"},
+		godo.DomainRecord{Type: "TXT", Name: recordName("turn"), Data: turn},
+		godo.DomainRecord{Type: "A", Name: recordName("turn"), Data: "1.1.1.3"},
+	)
+	records := []snapshot.Record{
+		{Name: recordFQDN(recordName("sfu-a")), Type: "A", Content: "1.1.1.1", TTL: 60},
+		{Name: recordFQDN(recordName("sfu-a")), Type: "TXT", Content: label, TTL: 60},
+		// The address of sfu-b changed since
+		{Name: recordFQDN(recordName("sfu-b")), Type: "A", Content: "1.1.1.2", TTL: 60},
+		{Name: recordFQDN(recordName("sfu-b")), Type: "TXT", Content: label, TTL: 60},
+		{Name: recordFQDN(recordName("turn")), Type: "A", Content: "1.1.1.3", TTL: 60},
+		{Name: recordFQDN(recordName("turn")), Type: "A", Content: "1.1.1.4", TTL: 60},
+		{Name: recordFQDN(recordName("turn")), Type: "TXT", Content: turn, TTL: 60},
+	}
+
+	d := DigitalOceanDNS{}
+	existing, err := d.Records(context.TODO())
+	if err != nil {
+		t.Fatalf("Records() returned error: %v", err)
+	}
+	if len(existing) != 4 {
+		t.Errorf("Expecting the 4 records of the zone, got %+v", existing)
+	}
+
+	before := f.zone()
+	if err := d.Restore(context.TODO(), records, true); err != nil {
+		t.Fatalf("Restore() of a dry run returned error: %v", err)
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(before, "\n") {
+		t.Errorf("Expecting records %q after a dry run, got %q", before, got)
+	}
+
+	if err := d.Restore(context.TODO(), records, false); err != nil {
+		t.Fatalf("Restore() returned error: %v", err)
+	}
+	want := []string{
+		recordName("sfu-a") + " A 1.1.1.1",
+		recordName("sfu-a") + " TXT " + label,
+		recordName("sfu-b") + " A 1.1.1.9",
+		recordName("sfu-b") + " TXT " + label,
+		recordName("turn") + " A 1.1.1.3",
+		recordName("turn") + " A 1.1.1.4",
+		recordName("turn") + " TXT " + turn,
+	}
+	if got := f.zone(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expecting records %q, got %q", want, got)
+	}
+}
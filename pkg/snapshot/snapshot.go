@@ -0,0 +1,178 @@
+// Package snapshot reads and writes the snapshots of the records casper-3
+// owns, see the export and restore commands.
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	common "github.com/gathertown/casper-3/pkg"
+	"sigs.k8s.io/yaml"
+)
+
+// Version is the version of the snapshot format. Snapshots of a later
+// version are refused.
+const Version = 1
+
+// Formats of Write.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Types are the types of the records in a snapshot.
+var Types = []string{"A", "AAAA", "TXT"}
+
+// Record is a DNS record.
+type Record struct {
+	Name    string `json:"name"` // FQDN
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied,omitempty"` // Cloudflare only
+}
+
+// Snapshot is the content of a snapshot file.
+type Snapshot struct {
+	Version     int       `json:"version"`
+	Provider    string    `json:"provider"`
+	Zone        string    `json:"zone"`
+	Subdomain   string    `json:"subdomain,omitempty"` // of the records, all of the zone if empty
+	Environment string    `json:"environment"`
+	ExportedAt  time.Time `json:"exportedAt"`
+	Records     []Record  `json:"records"`
+}
+
+// Owned returns the records owned by casper-3 for env: its TXT records, and
+// the A and AAAA records sharing their names. Only the names in subdomain of
+// zone are kept, unless subdomain is empty. The records are sorted by name
+// and type.
+func Owned(records []Record, env string, subdomain string, zone string) []Record {
+	names := make(map[string]bool)
+	for _, r := range records {
+		if r.Type == "TXT" && common.IsOwned(r.Content, env) {
+			names[r.Name] = true
+		}
+	}
+
+	suffix := "." + subdomain + "." + zone
+	owned := []Record{}
+	for _, r := range records {
+		if !names[r.Name] || (subdomain != "" && !strings.HasSuffix(r.Name, suffix)) {
+			continue
+		}
+		if r.Type == "A" || r.Type == "AAAA" || r.Type == "TXT" {
+			owned = append(owned, r)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		if owned[i].Name != owned[j].Name {
+			return owned[i].Name < owned[j].Name
+		}
+		return owned[i].Type < owned[j].Type
+	})
+	return owned
+}
+
+// Missing returns the records that aren't among existing, compared by name,
+// type and content so that the missing targets of a record set are found.
+// The records of a node or pod pair are compared by name and type only, as
+// restoring a previous address would publish it next to the current one.
+func Missing(records []Record, existing []Record) []Record {
+	found := make(map[string]bool)
+	for _, r := range existing {
+		found[r.Name+" "+r.Type] = true
+		found[r.Name+" "+r.Type+" "+r.Content] = true
+	}
+	pairs, _ := Pairs(records)
+	paired := make(map[string]bool)
+	for _, p := range pairs {
+		paired[p.A.Name] = true
+	}
+	missing := []Record{}
+	for _, r := range records {
+		if found[r.Name+" "+r.Type+" "+r.Content] || (paired[r.Name] && found[r.Name+" "+r.Type]) {
+			continue
+		}
+		missing = append(missing, r)
+	}
+	return missing
+}
+
+// Pair is a name with a single A record and its TXT record, as created for
+// a node or pod.
+type Pair struct {
+	A   Record
+	TXT Record
+}
+
+// Pairs splits records into the pairs of nodes and pods, and the other
+// records, e.g. of record sets.
+func Pairs(records []Record) ([]Pair, []Record) {
+	byName := make(map[string][]Record)
+	var names []string
+	for _, r := range records {
+		if _, found := byName[r.Name]; !found {
+			names = append(names, r.Name)
+		}
+		byName[r.Name] = append(byName[r.Name], r)
+	}
+
+	var pairs []Pair
+	var others []Record
+	for _, name := range names {
+		rs := byName[name]
+		if len(rs) == 2 && rs[0].Type != rs[1].Type && (rs[0].Type == "A" || rs[1].Type == "A") && (rs[0].Type == "TXT" || rs[1].Type == "TXT") {
+			if rs[0].Type == "A" {
+				pairs = append(pairs, Pair{A: rs[0], TXT: rs[1]})
+			} else {
+				pairs = append(pairs, Pair{A: rs[1], TXT: rs[0]})
+			}
+			continue
+		}
+		others = append(others, rs...)
+	}
+	return pairs, others
+}
+
+// Write writes s in format, FormatJSON or FormatYAML.
+func Write(w io.Writer, s Snapshot, format string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FormatJSON:
+		b = append(b, '\n')
+	case FormatYAML:
+		if b, err = yaml.JSONToYAML(b); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q, want %s or %s", format, FormatJSON, FormatYAML)
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Read reads a snapshot written by Write in either format.
+func Read(r io.Reader) (Snapshot, error) {
+	var s Snapshot
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return s, err
+	}
+	// JSON is YAML.
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return s, err
+	}
+	if s.Version < 1 || s.Version > Version {
+		return s, fmt.Errorf("unsupported snapshot version %d, want 1 to %d", s.Version, Version)
+	}
+	return s, nil
+}
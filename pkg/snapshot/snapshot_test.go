@@ -0,0 +1,114 @@
+package snapshot
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	nodeLabel = "heritage=casper-3,environment=dev"
+	podLabel  = "heritage=casper-3,environment=dev,podName=router-0,nodeName=sfu-a,ipAddress=1.2.3.5"
+	setLabel  = "heritage=casper-3,service-sync=true,environment=dev,owner=service/infra/turn,type=A"
+)
+
+var zone = []Record{
+	{Name: "sfu-a.dev.k8s.gather.town", Type: "A", Content: "1.2.3.4", TTL: 60},
+	{Name: "sfu-a.dev.k8s.gather.town", Type: "TXT", Content: nodeLabel, TTL: 60},
+	{Name: "router-0.dev.k8s.gather.town", Type: "TXT", Content: podLabel, TTL: 60},
+	{Name: "router-0.dev.k8s.gather.town", Type: "A", Content: "1.2.3.5", TTL: 60, Proxied: true},
+	{Name: "turn.dev.k8s.gather.town", Type: "A", Content: "1.2.3.4", TTL: 300},
+	{Name: "turn.dev.k8s.gather.town", Type: "A", Content: "1.2.3.5", TTL: 300},
+	{Name: "turn.dev.k8s.gather.town", Type: "TXT", Content: setLabel, TTL: 300},
+	{Name: "sfu-b.prod.k8s.gather.town", Type: "A", Content: "1.2.3.6"},
+	{Name: "sfu-b.prod.k8s.gather.town", Type: "TXT", Content: "heritage=casper-3,environment=prod"},
+	{Name: "www.k8s.gather.town", Type: "A", Content: "1.2.3.7"},
+}
+
+func TestOwned(t *testing.T) {
+	owned := Owned(zone, "dev", "dev", "k8s.gather.town")
+	var names []string
+	for _, r := range owned {
+		names = append(names, r.Name+" "+r.Type)
+	}
+	want := []string{
+		"router-0.dev.k8s.gather.town A",
+		"router-0.dev.k8s.gather.town TXT",
+		"sfu-a.dev.k8s.gather.town A",
+		"sfu-a.dev.k8s.gather.town TXT",
+		"turn.dev.k8s.gather.town A",
+		"turn.dev.k8s.gather.town A",
+		"turn.dev.k8s.gather.town TXT",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expecting owned records %q, got %q", want, names)
+	}
+	if got := Owned(zone, "dev", "prod", "k8s.gather.town"); len(got) != 0 {
+		t.Errorf("Expecting no records of environment dev in subdomain prod, got %v", got)
+	}
+}
+
+func TestPairs(t *testing.T) {
+	owned := Owned(zone, "dev", "", "k8s.gather.town")
+	pairs, others := Pairs(Missing(owned, zone[:2]))
+	if len(pairs) != 1 || pairs[0].A.Content != "1.2.3.5" || pairs[0].TXT.Content != podLabel {
+		t.Errorf("Expecting the pair of router-0, got %+v", pairs)
+	}
+	if len(others) != 3 {
+		t.Errorf("Expecting the 3 records of turn, got %+v", others)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	owned := Owned(zone, "dev", "dev", "k8s.gather.town")
+	existing := []Record{
+		// The address of sfu-a changed since, router-0 is gone
+		{Name: "sfu-a.dev.k8s.gather.town", Type: "A", Content: "1.2.3.9", TTL: 60},
+		{Name: "sfu-a.dev.k8s.gather.town", Type: "TXT", Content: nodeLabel, TTL: 60},
+		// A target of turn is gone
+		{Name: "turn.dev.k8s.gather.town", Type: "A", Content: "1.2.3.4", TTL: 300},
+		{Name: "turn.dev.k8s.gather.town", Type: "TXT", Content: setLabel, TTL: 300},
+	}
+	var missing []string
+	for _, r := range Missing(owned, existing) {
+		missing = append(missing, r.Name+" "+r.Type+" "+r.Content)
+	}
+	want := []string{
+		"router-0.dev.k8s.gather.town A 1.2.3.5",
+		"router-0.dev.k8s.gather.town TXT " + podLabel,
+		"turn.dev.k8s.gather.town A 1.2.3.5",
+	}
+	if !reflect.DeepEqual(missing, want) {
+		t.Errorf("Expecting missing records %q, got %q", want, missing)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	s := Snapshot{
+		Version:     Version,
+		Provider:    "cloudflare",
+		Zone:        "k8s.gather.town",
+		Subdomain:   "dev",
+		Environment: "dev",
+		ExportedAt:  time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC),
+		Records:     Owned(zone, "dev", "dev", "k8s.gather.town"),
+	}
+	for _, format := range []string{FormatJSON, FormatYAML} {
+		var buf bytes.Buffer
+		if err := Write(&buf, s, format); err != nil {
+			t.Fatalf("Write(%s) returned error: %v", format, err)
+		}
+		got, err := Read(&buf)
+		if err != nil {
+			t.Fatalf("Read(%s) returned error: %v", format, err)
+		}
+		if !reflect.DeepEqual(got, s) {
+			t.Errorf("Expecting %s snapshot %+v, got %+v", format, s, got)
+		}
+	}
+
+	if _, err := Read(bytes.NewBufferString(`{"version": 2, "records": []}`)); err == nil {
+		t.Error("Expecting an error for a snapshot of version 2")
+	}
+}
@@ -0,0 +1,364 @@
+// Package status keeps the desired and actual DNS state of the last
+// reconciles for the read-only status API.
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/gathertown/casper-3/pkg"
+)
+
+// maxCycles is the number of reconciles whose timing is kept.
+const maxCycles = 10
+
+// kinds are the kinds of objects whose records are reported.
+var kinds = []string{"node", "pod", "pool", "service", "record"}
+
+// Object is a node, pod or the owner of a record set that should be
+// published.
+type Object struct {
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name"`
+	FQDN      string   `json:"fqdn"`
+	Type      string   `json:"type"`
+	Addresses []string `json:"addresses"` // IPv4 addresses for "A", a hostname for "CNAME"
+}
+
+// Record is an owned record, the ownership TXT record or an address record,
+// as reported by the provider at the start of the sync of nodes and pods, or
+// once synced for record sets.
+type Record struct {
+	Kind    string `json:"kind"`
+	FQDN    string `json:"fqdn"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+// Diff is the difference between the desired and the actual records once
+// the record operations of the last reconcile are applied.
+type Diff struct {
+	Missing    []string `json:"missing"`    // desired names without address records
+	Mismatched []string `json:"mismatched"` // desired names whose address records differ from the desired addresses
+	Stale      []string `json:"stale"`      // owned names that are no longer desired
+}
+
+// RecordError is the last failed or blocked operation of a record.
+type RecordError struct {
+	FQDN   string    `json:"fqdn"`
+	Kind   string    `json:"kind"`
+	Action string    `json:"action"`
+	Error  string    `json:"error"`
+	Time   time.Time `json:"time"`
+}
+
+// Cycle is the timing of a reconcile.
+type Cycle struct {
+	ID       string    `json:"id"` // reconcileID of the log lines
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"durationSeconds"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Summary is the response of /status.
+type Summary struct {
+	Provider   string         `json:"provider"`
+	Zone       string         `json:"zone"`
+	Desired    map[string]int `json:"desired"`  // by kind
+	Observed   map[string]int `json:"observed"` // by kind
+	Missing    int            `json:"missing"`
+	Mismatched int            `json:"mismatched"`
+	Stale      int            `json:"stale"`
+	Errors     int            `json:"errors"`
+	Cycles     []Cycle        `json:"cycles"` // most recent first
+}
+
+// Records is the response of /status/records.
+type Records struct {
+	Desired  []Object      `json:"desired"`
+	Observed []Record      `json:"observed"`
+	Diff     Diff          `json:"diff"`
+	Errors   []RecordError `json:"errors"`
+}
+
+// Status is the state of the last reconciles. It is safe for concurrent use.
+type Status struct {
+	Provider  string
+	Zone      string
+	Subdomain string // default subdomain of the records
+
+	mu       sync.Mutex
+	desired  map[string][]Object // by kind
+	observed map[string][]Record // by kind
+	diff     Diff
+	errors   map[string]RecordError // by FQDN
+	cycles   []Cycle
+}
+
+// Update replaces the desired and actual state with that of a reconcile. The
+// record sets are given by kind, e.g. "service". The kinds the provider found
+// no records of, e.g. pods if they are not synced, keep their previous state.
+func (s *Status) Update(nodes []common.Node, pods []common.Pod, sets map[string][]common.RecordSet, observed map[string][]common.Record, changes []common.Change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.desired == nil {
+		s.desired = make(map[string][]Object)
+		s.observed = make(map[string][]Record)
+		s.errors = make(map[string]RecordError)
+	}
+
+	desired := make(map[string][]Object)
+	for _, n := range nodes {
+		desired["node"] = append(desired["node"], Object{Kind: "node", Name: n.Name, FQDN: n.FQDN(s.Subdomain, s.Zone), Type: "A", Addresses: addresses(n.ExternalIP)})
+	}
+	for _, p := range pods {
+		desired["pod"] = append(desired["pod"], Object{Kind: "pod", Namespace: p.Namespace, Name: p.Name, FQDN: p.FQDN(s.Subdomain, s.Zone), Type: "A", Addresses: addresses(p.AssignedNode.ExternalIP)})
+	}
+	for kind, kindSets := range sets {
+		for _, set := range kindSets {
+			o := Object{Kind: kind, FQDN: common.RecordOptions{Hostname: set.Name}.FQDN(s.Subdomain, s.Zone), Type: set.Type, Addresses: append([]string{}, set.Targets...)}
+			// The owner is e.g. "service/infra/router" or "pool/sfu"
+			switch parts := strings.SplitN(set.Owner, "/", 3); len(parts) {
+			case 3:
+				o.Namespace, o.Name = parts[1], parts[2]
+			case 2:
+				o.Name = parts[1]
+			default:
+				o.Name = set.Owner
+			}
+			desired[kind] = append(desired[kind], o)
+		}
+	}
+	for _, kind := range kinds {
+		records, found := observed[kind]
+		if !found {
+			continue
+		}
+		s.desired[kind] = append([]Object{}, desired[kind]...)
+		s.observed[kind] = make([]Record, 0, len(records))
+		for _, r := range records {
+			s.observed[kind] = append(s.observed[kind], Record{Kind: r.Kind, FQDN: r.FQDN, Type: r.Type, Content: r.Content})
+		}
+	}
+
+	changed := make(map[string]bool)
+	for _, c := range changes {
+		changed[c.FQDN] = true
+		if c.Err != nil {
+			s.errors[c.FQDN] = RecordError{FQDN: c.FQDN, Kind: c.Kind, Action: c.Action, Error: c.Err.Error(), Time: c.Time}
+		} else {
+			delete(s.errors, c.FQDN)
+		}
+	}
+	s.diff = diff(s.desired, s.observed, changes)
+
+	// Forget the errors of names that are gone.
+	known := make(map[string]bool)
+	for _, kind := range kinds {
+		for _, o := range s.desired[kind] {
+			known[o.FQDN] = true
+		}
+		for _, r := range s.observed[kind] {
+			known[r.FQDN] = true
+		}
+	}
+	for fqdn := range s.errors {
+		if !known[fqdn] && !changed[fqdn] {
+			delete(s.errors, fqdn)
+		}
+	}
+}
+
+// Complete records the timing of the reconcile id started at start.
+func (s *Status) Complete(id string, start time.Time, err error) {
+	cycle := Cycle{ID: id, Start: start, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		cycle.Error = err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycles = append([]Cycle{cycle}, s.cycles...)
+	if len(s.cycles) > maxCycles {
+		s.cycles = s.cycles[:maxCycles]
+	}
+}
+
+// diff returns the names of desired that have no address records or records
+// of other addresses, and of the owned records that are not desired, once the
+// successful changes are applied.
+func diff(desired map[string][]Object, observed map[string][]Record, changes []common.Change) Diff {
+	want := make(map[string]Object)
+	for _, objects := range desired {
+		for _, o := range objects {
+			want[o.FQDN] = o
+		}
+	}
+	owned := make(map[string]bool)
+	have := make(map[string][]string) // addresses by FQDN
+	for _, records := range observed {
+		for _, r := range records {
+			owned[r.FQDN] = true
+			if r.Type == "A" || r.Type == "CNAME" {
+				have[r.FQDN] = append(have[r.FQDN], r.Content)
+			}
+		}
+	}
+	for _, c := range changes {
+		if c.Err != nil {
+			continue
+		}
+		switch c.Action {
+		case "create":
+			owned[c.FQDN] = true
+			have[c.FQDN] = addresses(c.Content)
+		case "delete":
+			delete(owned, c.FQDN)
+			delete(have, c.FQDN)
+		}
+	}
+
+	d := Diff{Missing: []string{}, Mismatched: []string{}, Stale: []string{}}
+	for fqdn, o := range want {
+		switch {
+		case len(have[fqdn]) == 0:
+			d.Missing = append(d.Missing, fqdn)
+		case !sameAddresses(o.Addresses, have[fqdn]):
+			d.Mismatched = append(d.Mismatched, fqdn)
+		}
+	}
+	for fqdn := range owned {
+		if _, found := want[fqdn]; !found {
+			d.Stale = append(d.Stale, fqdn)
+		}
+	}
+	sort.Strings(d.Missing)
+	sort.Strings(d.Mismatched)
+	sort.Strings(d.Stale)
+	return d
+}
+
+// addresses returns address as a list, empty if the address is unknown.
+func addresses(address string) []string {
+	if address == "" {
+		return []string{}
+	}
+	return []string{address}
+}
+
+// sameAddresses returns whether a and b hold the same addresses in any order.
+// Hostnames match with or without the trailing dot.
+func sameAddresses(a []string, b []string) bool {
+	normalize := func(list []string) []string {
+		n := make([]string, 0, len(list))
+		for _, address := range list {
+			n = append(n, strings.TrimSuffix(address, "."))
+		}
+		sort.Strings(n)
+		return n
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// Summary returns the counts of the last reconcile and the timing of the
+// last cycles.
+func (s *Status) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := Summary{
+		Provider:   s.Provider,
+		Zone:       s.Zone,
+		Desired:    make(map[string]int),
+		Observed:   make(map[string]int),
+		Missing:    len(s.diff.Missing),
+		Mismatched: len(s.diff.Mismatched),
+		Stale:      len(s.diff.Stale),
+		Errors:     len(s.errors),
+		Cycles:     append([]Cycle{}, s.cycles...),
+	}
+	for kind, objects := range s.desired {
+		summary.Desired[kind] = len(objects)
+	}
+	for kind, records := range s.observed {
+		summary.Observed[kind] = len(records)
+	}
+	return summary
+}
+
+// Records returns the desired and actual records whose FQDN contains name,
+// all if empty.
+func (s *Status) Records(name string) Records {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	match := func(fqdn string) bool { return strings.Contains(fqdn, name) }
+	records := Records{Desired: []Object{}, Observed: []Record{}, Diff: Diff{Missing: []string{}, Mismatched: []string{}, Stale: []string{}}, Errors: []RecordError{}}
+	for _, kind := range kinds {
+		for _, o := range s.desired[kind] {
+			if match(o.FQDN) {
+				records.Desired = append(records.Desired, o)
+			}
+		}
+	}
+	for _, kind := range kinds {
+		for _, r := range s.observed[kind] {
+			if match(r.FQDN) {
+				records.Observed = append(records.Observed, r)
+			}
+		}
+	}
+	for _, fqdn := range s.diff.Missing {
+		if match(fqdn) {
+			records.Diff.Missing = append(records.Diff.Missing, fqdn)
+		}
+	}
+	for _, fqdn := range s.diff.Mismatched {
+		if match(fqdn) {
+			records.Diff.Mismatched = append(records.Diff.Mismatched, fqdn)
+		}
+	}
+	for _, fqdn := range s.diff.Stale {
+		if match(fqdn) {
+			records.Diff.Stale = append(records.Diff.Stale, fqdn)
+		}
+	}
+	for _, e := range s.errors {
+		if match(e.FQDN) {
+			records.Errors = append(records.Errors, e)
+		}
+	}
+	sort.Slice(records.Errors, func(i, j int) bool { return records.Errors[i].FQDN < records.Errors[j].FQDN })
+	return records
+}
+
+// ServeHTTP serves the summary on /status and the records on /status/records,
+// filtered by the name query parameter.
+func (s *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var v interface{}
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/status":
+		v = s.Summary()
+	case "/status/records":
+		v = s.Records(r.URL.Query().Get("name"))
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,130 @@
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	common "github.com/gathertown/casper-3/pkg"
+)
+
+func TestUpdate(t *testing.T) {
+	s := &Status{Provider: "cloudflare", Zone: "k8s.gather.town", Subdomain: "dev"}
+
+	nodes := []common.Node{
+		{Name: "sfu-a", ExternalIP: "1.2.3.4", RecordOptions: common.RecordOptions{Hostname: "sfu-a"}},
+		{Name: "sfu-b", ExternalIP: "1.2.3.5", RecordOptions: common.RecordOptions{Hostname: "sfu-b"}},
+		{Name: "sfu-c", RecordOptions: common.RecordOptions{Hostname: "sfu-c"}},
+		{Name: "sfu-d", ExternalIP: "1.2.3.7", RecordOptions: common.RecordOptions{Hostname: "sfu-d"}},
+	}
+	observed := map[string][]common.Record{
+		"node": {
+			{Kind: "node", FQDN: "sfu-a.dev.k8s.gather.town", Type: "TXT"},
+			{Kind: "node", FQDN: "sfu-a.dev.k8s.gather.town", Type: "A", Content: "1.2.3.4"},
+			{Kind: "node", FQDN: "sfu-d.dev.k8s.gather.town", Type: "TXT"},
+			{Kind: "node", FQDN: "sfu-d.dev.k8s.gather.town", Type: "A", Content: "1.2.3.6"},
+			{Kind: "node", FQDN: "sfu-old.dev.k8s.gather.town", Type: "TXT"},
+			{Kind: "node", FQDN: "sfu-gone.dev.k8s.gather.town", Type: "TXT"},
+		},
+	}
+	changes := []common.Change{
+		{Action: "create", Kind: "node", Name: "sfu-b", FQDN: "sfu-b.dev.k8s.gather.town", Type: "A", Content: "1.2.3.5"},
+		{Action: "blocked", Kind: "node", Name: "sfu-c", FQDN: "sfu-c.dev.k8s.gather.town", Err: common.ErrNoAddress},
+		{Action: "delete", Kind: "node", Name: "sfu-old", FQDN: "sfu-old.dev.k8s.gather.town", Err: errors.New("rate limited")},
+		{Action: "delete", Kind: "node", Name: "sfu-gone", FQDN: "sfu-gone.dev.k8s.gather.town"},
+	}
+	s.Update(nodes, nil, nil, observed, changes)
+	s.Complete("4f2a9c1e", time.Now(), nil)
+
+	records := s.Records("")
+	want := Diff{Missing: []string{"sfu-c.dev.k8s.gather.town"}, Mismatched: []string{"sfu-d.dev.k8s.gather.town"}, Stale: []string{"sfu-old.dev.k8s.gather.town"}}
+	if !reflect.DeepEqual(records.Diff, want) {
+		t.Errorf("Expecting diff %+v, got %+v", want, records.Diff)
+	}
+	if len(records.Errors) != 2 {
+		t.Fatalf("Expecting 2 errors, got %d", len(records.Errors))
+	}
+	if records.Errors[0].Error != common.ErrNoAddress.Error() {
+		t.Errorf("Expecting error %q, got %q", common.ErrNoAddress.Error(), records.Errors[0].Error)
+	}
+
+	// Pods were not synced, their state is unknown rather than empty.
+	summary := s.Summary()
+	if want := map[string]int{"node": 4}; !reflect.DeepEqual(summary.Desired, want) {
+		t.Errorf("Expecting desired %v, got %v", want, summary.Desired)
+	}
+	if summary.Missing != 1 || summary.Mismatched != 1 || summary.Stale != 1 || summary.Errors != 2 || len(summary.Cycles) != 1 {
+		t.Errorf("Expecting 1 missing, 1 mismatched, 1 stale, 2 errors and 1 cycle, got %+v", summary)
+	}
+
+	// A successful retry clears the error.
+	observed["node"] = []common.Record{
+		{Kind: "node", FQDN: "sfu-a.dev.k8s.gather.town", Type: "A", Content: "1.2.3.4"},
+		{Kind: "node", FQDN: "sfu-b.dev.k8s.gather.town", Type: "A", Content: "1.2.3.5"},
+		{Kind: "node", FQDN: "sfu-old.dev.k8s.gather.town", Type: "TXT"},
+	}
+	s.Update(nodes, nil, nil, observed, []common.Change{{Action: "delete", Kind: "node", FQDN: "sfu-old.dev.k8s.gather.town"}})
+	if got := s.Records("sfu-old").Errors; len(got) != 0 {
+		t.Errorf("Expecting no errors of sfu-old, got %+v", got)
+	}
+}
+
+func TestUpdateRecordSets(t *testing.T) {
+	s := &Status{Provider: "cloudflare", Zone: "k8s.gather.town", Subdomain: "dev"}
+
+	sets := map[string][]common.RecordSet{
+		"service": {
+			{Name: "router", Type: "A", Targets: []string{"10.0.0.1", "10.0.0.2"}, Owner: "service/infra/router"},
+			{Name: "www", Type: "CNAME", Targets: []string{"lb.example.com"}, Owner: "service/infra/www"},
+		},
+		"pool": {{Name: "sfu", Type: "A", Targets: []string{"1.2.3.4"}, Owner: "pool/sfu"}},
+	}
+	observed := map[string][]common.Record{
+		"service": {
+			{Kind: "service", FQDN: "router.dev.k8s.gather.town", Type: "A", Content: "10.0.0.2"},
+			{Kind: "service", FQDN: "router.dev.k8s.gather.town", Type: "A", Content: "10.0.0.1"},
+			{Kind: "service", FQDN: "www.dev.k8s.gather.town", Type: "CNAME", Content: "lb.example.com."},
+		},
+		"pool": {{Kind: "pool", FQDN: "sfu.dev.k8s.gather.town", Type: "A", Content: "1.2.3.5"}},
+	}
+	s.Update(nil, nil, sets, observed, nil)
+
+	records := s.Records("")
+	want := Diff{Missing: []string{}, Mismatched: []string{"sfu.dev.k8s.gather.town"}, Stale: []string{}}
+	if !reflect.DeepEqual(records.Diff, want) {
+		t.Errorf("Expecting diff %+v, got %+v", want, records.Diff)
+	}
+	router := Object{Kind: "service", Namespace: "infra", Name: "router", FQDN: "router.dev.k8s.gather.town", Type: "A", Addresses: []string{"10.0.0.1", "10.0.0.2"}}
+	if got := s.Records("router").Desired; len(got) != 1 || !reflect.DeepEqual(got[0], router) {
+		t.Errorf("Expecting desired %+v, got %+v", router, got)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	s := &Status{Provider: "digitalocean", Zone: "k8s.gather.town"}
+	nodes := []common.Node{{Name: "sfu-a", ExternalIP: "1.2.3.4", RecordOptions: common.RecordOptions{Hostname: "sfu-a"}}}
+	s.Update(nodes, nil, nil, map[string][]common.Record{"node": {}}, nil)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status/records?name=sfu-a", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expecting status %d, got %d", http.StatusOK, w.Code)
+	}
+	var records Records
+	if err := json.NewDecoder(w.Body).Decode(&records); err != nil {
+		t.Fatalf("Decoding /status/records: %v", err)
+	}
+	if want := []string{"sfu-a.k8s.gather.town"}; !reflect.DeepEqual(records.Diff.Missing, want) {
+		t.Errorf("Expecting missing %v, got %v", want, records.Diff.Missing)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expecting status %d for /status/unknown, got %d", http.StatusNotFound, w.Code)
+	}
+}